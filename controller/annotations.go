@@ -0,0 +1,647 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// annotationPrefix namespaces every annotation and label the controller
+// owns.
+const annotationPrefix = "expose.abdul-saqib.io/"
+
+const (
+	// enabledAnnotation opts a Deployment in to exposure. With namespace
+	// opt-in, the Deployment's namespace must carry it as well.
+	enabledAnnotation = annotationPrefix + "enabled"
+	// dnsHostnameAnnotation requests a DNS name for the generated Service.
+	dnsHostnameAnnotation = annotationPrefix + "dns-hostname"
+	// managedByOverrideAnnotation replaces the managed-by label value on the
+	// generated Service, for clusters where another tool uses the default.
+	managedByOverrideAnnotation = annotationPrefix + "managed-by-override"
+	// weightAnnotation carries a traffic weight passed through to the
+	// Service for gateway controllers.
+	weightAnnotation = annotationPrefix + "weight"
+	// noFallbackPortAnnotation disables the port 80 fallback for Deployments
+	// that declare no container ports.
+	noFallbackPortAnnotation = annotationPrefix + "no-fallback-port"
+	// excludePortsAnnotation and excludePortNamesAnnotation list container
+	// ports, by number and by name, that are not exposed.
+	excludePortsAnnotation     = annotationPrefix + "exclude-ports"
+	excludePortNamesAnnotation = annotationPrefix + "exclude-port-names"
+	// dualAnnotation additionally publishes a ClusterIP "-internal" Service
+	// next to the NodePort "-expose" Service.
+	dualAnnotation = annotationPrefix + "dual"
+	// readinessGateAnnotation names a Pod condition that must be True on at
+	// least one Pod before the Service is created.
+	readinessGateAnnotation = annotationPrefix + "readiness-gate"
+	// ipFamiliesAnnotation orders the Service's IP families, e.g. "IPv6,IPv4".
+	ipFamiliesAnnotation = annotationPrefix + "ip-families"
+	// ipFamilyPolicyAnnotation sets the Service's IP family policy instead of
+	// deriving it from the number of IP families.
+	ipFamilyPolicyAnnotation = annotationPrefix + "ip-family-policy"
+	// retainOnDeleteAnnotation keeps the Services when the Deployment is
+	// deleted.
+	retainOnDeleteAnnotation = annotationPrefix + "retain-on-delete"
+	// serviceTypeAnnotation selects the type of the "-expose" Service:
+	// ClusterIP, NodePort or LoadBalancer.
+	serviceTypeAnnotation = annotationPrefix + "service-type"
+	// portAnnotation and targetPortAnnotation replace the ports derived from
+	// the containers with a single port. Either may be set alone: the port
+	// defaults to 80 and the target port, a number or a container port name,
+	// to the first container port.
+	portAnnotation       = annotationPrefix + "port"
+	targetPortAnnotation = annotationPrefix + "target-port"
+	// portsAnnotation lists several ports to expose instead of the ports
+	// derived from the containers, as comma-separated [name:]port[->target]
+	// entries, e.g. "http:80->8080,grpc:9090".
+	portsAnnotation = annotationPrefix + "ports"
+	// sessionAffinityAnnotation sets the Service's session affinity, ClientIP
+	// or None; sessionAffinityTimeoutAnnotation bounds ClientIP stickiness
+	// in seconds.
+	sessionAffinityAnnotation        = annotationPrefix + "session-affinity"
+	sessionAffinityTimeoutAnnotation = annotationPrefix + "session-affinity-timeout"
+	// externalTrafficPolicyAnnotation sets the external traffic policy,
+	// Cluster or Local, of a NodePort or LoadBalancer Service.
+	externalTrafficPolicyAnnotation = annotationPrefix + "external-traffic-policy"
+	// internalTrafficPolicyAnnotation sets the internal traffic policy,
+	// Cluster or Local, for traffic from inside the cluster.
+	internalTrafficPolicyAnnotation = annotationPrefix + "internal-traffic-policy"
+	// loadBalancerClassAnnotation selects the load balancer implementation
+	// of a LoadBalancer Service.
+	loadBalancerClassAnnotation = annotationPrefix + "load-balancer-class"
+	// loadBalancerSourceRangesAnnotation restricts the client CIDRs a
+	// LoadBalancer Service accepts, as a comma-separated list.
+	loadBalancerSourceRangesAnnotation = annotationPrefix + "load-balancer-source-ranges"
+	// scrapeAnnotation adds the prometheus.io scrape annotations to the
+	// Service; scrapePathAnnotation overrides the scraped path.
+	scrapeAnnotation     = annotationPrefix + "scrape"
+	scrapePathAnnotation = annotationPrefix + "scrape-path"
+	// appProtocolAnnotation sets the application protocol of every exposed
+	// port instead of inferring it from the port names.
+	appProtocolAnnotation = annotationPrefix + "app-protocol"
+	// publishNotReadyAddressesAnnotation publishes the addresses of Pods
+	// that are not ready yet, for peers discovering each other on startup.
+	publishNotReadyAddressesAnnotation = annotationPrefix + "publish-not-ready-addresses"
+	// nodePortAnnotation pins the NodePort of the first port of a NodePort
+	// Service.
+	nodePortAnnotation = annotationPrefix + "node-port"
+	// removeWhenScaledToZeroAnnotation removes the Services while the
+	// Deployment has zero replicas and recreates them when it scales up.
+	removeWhenScaledToZeroAnnotation = annotationPrefix + "remove-when-scaled-to-zero"
+	// perPodAnnotation additionally gives every replica of a StatefulSet
+	// its own Service, selecting the Pod by name.
+	perPodAnnotation = annotationPrefix + "per-pod"
+)
+
+const (
+	// prometheusScrapeAnnotation, prometheusPortAnnotation and
+	// prometheusPathAnnotation are read by Prometheus' annotation-based
+	// Service discovery.
+	prometheusScrapeAnnotation = "prometheus.io/scrape"
+	prometheusPortAnnotation   = "prometheus.io/port"
+	prometheusPathAnnotation   = "prometheus.io/path"
+
+	// defaultScrapePath is scraped unless the scrape-path annotation is set.
+	defaultScrapePath = "/metrics"
+)
+
+const (
+	// DefaultDNSAnnotationKey is the Service annotation external-dns reads.
+	DefaultDNSAnnotationKey = "external-dns.alpha.kubernetes.io/hostname"
+	// DefaultWeightAnnotationKey is the Service annotation that receives the
+	// weight annotation value.
+	DefaultWeightAnnotationKey = weightAnnotation
+)
+
+// ExposeConfig is the typed form of a Deployment's expose annotations.
+type ExposeConfig struct {
+	Enabled          bool
+	ServiceType      v1.ServiceType
+	DNSHostname      string
+	ManagedBy        string
+	Weight           *int
+	Gateway          string
+	Host             string
+	NoFallbackPort   bool
+	ExcludePorts     map[int32]bool
+	ExcludePortNames map[string]bool
+	Dual             bool
+	ReadinessGate    string
+	IPFamilies       []v1.IPFamily
+	IPFamilyPolicy   *v1.IPFamilyPolicy
+	RetainOnDelete   bool
+	IngressHost      string
+	SessionAffinity  v1.ServiceAffinity
+	// SessionAffinityTimeout only applies to ClientIP affinity.
+	SessionAffinityTimeout *int32
+	ExternalTrafficPolicy  v1.ServiceExternalTrafficPolicy
+	InternalTrafficPolicy  *v1.ServiceInternalTrafficPolicy
+	NodePort               int32
+	RemoveWhenScaledToZero bool
+	// PerPod only applies to StatefulSets.
+	PerPod bool
+	// LoadBalancerClass and LoadBalancerSourceRanges only apply to
+	// LoadBalancer Services.
+	LoadBalancerClass        *string
+	LoadBalancerSourceRanges []string
+	Scrape                   bool
+	ScrapePath               string
+	AppProtocol              string
+	PublishNotReadyAddresses bool
+	// PortOverride, when set, is the only port exposed. A zero Port or
+	// TargetPort is defaulted when the ports are derived.
+	PortOverride *v1.ServicePort
+	// Ports, when set, are the ports exposed. They take precedence over
+	// PortOverride.
+	Ports []v1.ServicePort
+	// InvalidPortOverride is set when the port annotations are present but
+	// invalid. Exposing the container ports instead could publish ports the
+	// user meant to hide, so the Deployment is not reconciled.
+	InvalidPortOverride bool
+}
+
+// parseExposeConfig parses and validates every expose annotation of obj.
+// Invalid values are left at their zero value and reported as warnings, so
+// one bad annotation never blocks the rest of the configuration.
+func parseExposeConfig(obj metav1.Object) (*ExposeConfig, []string) {
+	cfg, p := parseAnnotations(obj.GetAnnotations())
+	return cfg, append(p.warnings, p.notices...)
+}
+
+// parseAnnotations parses annotations into an ExposeConfig and returns the
+// parser holding the warnings and notices it produced.
+func parseAnnotations(annotations map[string]string) (*ExposeConfig, *annotationParser) {
+	p := &annotationParser{annotations: annotations}
+	cfg := &ExposeConfig{
+		Enabled:          p.bool(enabledAnnotation),
+		DNSHostname:      p.annotations[dnsHostnameAnnotation],
+		ManagedBy:        p.annotations[managedByOverrideAnnotation],
+		Gateway:          p.annotations[gatewayAnnotation],
+		Host:             p.annotations[hostAnnotation],
+		NoFallbackPort:   p.bool(noFallbackPortAnnotation),
+		ExcludePorts:     p.portSet(excludePortsAnnotation),
+		ExcludePortNames: p.stringSet(excludePortNamesAnnotation),
+		Dual:             p.bool(dualAnnotation),
+		ReadinessGate:    p.annotations[readinessGateAnnotation],
+		RetainOnDelete:   p.bool(retainOnDeleteAnnotation),
+		IngressHost:      p.annotations[ingressHostAnnotation],
+	}
+	cfg.ServiceType = p.serviceType(serviceTypeAnnotation)
+	cfg.Weight = p.nonNegativeInt(weightAnnotation)
+	cfg.IPFamilies, cfg.IPFamilyPolicy = p.ipFamilies(ipFamiliesAnnotation)
+	if policy := p.ipFamilyPolicy(ipFamilyPolicyAnnotation, len(cfg.IPFamilies)); policy != nil {
+		cfg.IPFamilyPolicy = policy
+	}
+	cfg.PortOverride, cfg.InvalidPortOverride = p.portOverride(portAnnotation, targetPortAnnotation)
+	if _, ok := annotations[portsAnnotation]; ok {
+		if cfg.PortOverride != nil {
+			p.noticef("%s takes precedence over %s", portsAnnotation, portAnnotation)
+		}
+		cfg.Ports = p.portList(portsAnnotation)
+		cfg.InvalidPortOverride = cfg.InvalidPortOverride || len(cfg.Ports) == 0
+	}
+	cfg.SessionAffinity, cfg.SessionAffinityTimeout = p.sessionAffinity(sessionAffinityAnnotation, sessionAffinityTimeoutAnnotation)
+	cfg.ExternalTrafficPolicy = p.externalTrafficPolicy(externalTrafficPolicyAnnotation)
+	cfg.InternalTrafficPolicy = p.internalTrafficPolicy(internalTrafficPolicyAnnotation)
+	cfg.NodePort = p.nodePort(nodePortAnnotation)
+	cfg.LoadBalancerClass = p.loadBalancerClass(loadBalancerClassAnnotation)
+	cfg.LoadBalancerSourceRanges = p.cidrList(loadBalancerSourceRangesAnnotation)
+	cfg.AppProtocol = p.appProtocol(appProtocolAnnotation)
+	cfg.PublishNotReadyAddresses = p.bool(publishNotReadyAddressesAnnotation)
+	cfg.Scrape = p.bool(scrapeAnnotation)
+	cfg.ScrapePath = defaultScrapePath
+	if path, ok := annotations[scrapePathAnnotation]; ok {
+		if strings.HasPrefix(path, "/") {
+			cfg.ScrapePath = path
+		} else {
+			p.warnf("invalid %s %q, expected an absolute path", scrapePathAnnotation, path)
+		}
+	}
+	cfg.RemoveWhenScaledToZero = p.bool(removeWhenScaledToZeroAnnotation)
+	cfg.PerPod = p.bool(perPodAnnotation)
+
+	if (cfg.Gateway == "") != (cfg.Host == "") {
+		p.noticef("%s and %s must be set together", gatewayAnnotation, hostAnnotation)
+	}
+	return cfg, p
+}
+
+// annotationParser collects validation warnings while parsing annotations.
+type annotationParser struct {
+	annotations map[string]string
+	warnings    []string
+	// notices report values that parse but may be a mistake: they are
+	// applied anyway, or ignored because another annotation overrides or
+	// lacks them.
+	notices []string
+}
+
+func (p *annotationParser) warnf(format string, args ...interface{}) {
+	p.warnings = append(p.warnings, fmt.Sprintf(format, args...))
+}
+
+func (p *annotationParser) noticef(format string, args ...interface{}) {
+	p.notices = append(p.notices, fmt.Sprintf(format, args...))
+}
+
+func (p *annotationParser) bool(key string) bool {
+	value, ok := p.annotations[key]
+	if !ok {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		p.warnf("invalid %s %q, expected true or false", key, value)
+		return false
+	}
+	return b
+}
+
+// serviceType parses a Service type. It returns "" when the annotation is
+// missing or invalid, leaving the choice to Options.DefaultServiceType.
+func (p *annotationParser) serviceType(key string) v1.ServiceType {
+	value, ok := p.annotations[key]
+	if !ok {
+		return ""
+	}
+	t, err := ParseServiceType(value)
+	if err != nil {
+		p.warnf("invalid %s %q, expected ClusterIP, NodePort or LoadBalancer", key, value)
+		return ""
+	}
+	return t
+}
+
+// ParseServiceType validates a Service type the controller can create.
+func ParseServiceType(s string) (v1.ServiceType, error) {
+	switch t := v1.ServiceType(s); t {
+	case v1.ServiceTypeClusterIP, v1.ServiceTypeNodePort, v1.ServiceTypeLoadBalancer:
+		return t, nil
+	}
+	return "", fmt.Errorf("invalid service type %q, must be one of ClusterIP, NodePort, LoadBalancer", s)
+}
+
+func (p *annotationParser) nonNegativeInt(key string) *int {
+	value, ok := p.annotations[key]
+	if !ok {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		p.warnf("invalid %s %q, expected a non-negative integer", key, value)
+		return nil
+	}
+	return &n
+}
+
+func (p *annotationParser) portSet(key string) map[int32]bool {
+	out := map[int32]bool{}
+	for _, entry := range splitList(p.annotations[key]) {
+		n, err := strconv.ParseInt(entry, 10, 32)
+		if err != nil || n < 1 || n > 65535 {
+			p.warnf("invalid port %q in %s", entry, key)
+			continue
+		}
+		out[int32(n)] = true
+	}
+	return out
+}
+
+func (p *annotationParser) stringSet(key string) map[string]bool {
+	out := map[string]bool{}
+	for _, entry := range splitList(p.annotations[key]) {
+		out[entry] = true
+	}
+	return out
+}
+
+// ipFamilies parses an ordered family list into the families and the
+// matching policy: SingleStack for one family, RequireDualStack for two.
+func (p *annotationParser) ipFamilies(key string) ([]v1.IPFamily, *v1.IPFamilyPolicy) {
+	value, ok := p.annotations[key]
+	if !ok {
+		return nil, nil
+	}
+
+	var families []v1.IPFamily
+	seen := map[v1.IPFamily]bool{}
+	for _, entry := range splitList(value) {
+		family := v1.IPFamily(entry)
+		if (family != v1.IPv4Protocol && family != v1.IPv6Protocol) || seen[family] {
+			p.warnf("invalid %s %q, expected IPv4 and/or IPv6", key, value)
+			return nil, nil
+		}
+		seen[family] = true
+		families = append(families, family)
+	}
+	if len(families) == 0 {
+		p.warnf("invalid %s %q, expected IPv4 and/or IPv6", key, value)
+		return nil, nil
+	}
+
+	policy := v1.IPFamilyPolicySingleStack
+	if len(families) == 2 {
+		policy = v1.IPFamilyPolicyRequireDualStack
+	}
+	return families, &policy
+}
+
+// ipFamilyPolicy parses an IP family policy. SingleStack conflicts with two
+// requested families and is ignored then.
+func (p *annotationParser) ipFamilyPolicy(key string, families int) *v1.IPFamilyPolicy {
+	value, ok := p.annotations[key]
+	if !ok {
+		return nil
+	}
+	switch policy := v1.IPFamilyPolicy(value); policy {
+	case v1.IPFamilyPolicySingleStack:
+		if families > 1 {
+			p.warnf("%s %q conflicts with two %s", key, value, ipFamiliesAnnotation)
+			return nil
+		}
+		return &policy
+	case v1.IPFamilyPolicyPreferDualStack, v1.IPFamilyPolicyRequireDualStack:
+		return &policy
+	}
+	p.warnf("invalid %s %q, expected SingleStack, PreferDualStack or RequireDualStack", key, value)
+	return nil
+}
+
+// portOverride parses an optional port and an optional target port into a
+// single ServicePort, leaving whichever is unset at zero. It reports invalid
+// when either annotation is set but cannot be used.
+func (p *annotationParser) portOverride(key, targetKey string) (*v1.ServicePort, bool) {
+	portValue, hasPort := p.annotations[key]
+	targetValue, hasTarget := p.annotations[targetKey]
+	if !hasPort && !hasTarget {
+		return nil, false
+	}
+
+	var port int64
+	if hasPort {
+		var err error
+		port, err = strconv.ParseInt(portValue, 10, 32)
+		if err != nil || port < 1 || port > 65535 {
+			p.warnf("invalid %s %q, expected a port between 1 and 65535", key, portValue)
+			return nil, true
+		}
+	}
+
+	var target intstr.IntOrString
+	if hasTarget {
+		target = intstr.Parse(targetValue)
+		switch {
+		case target.Type == intstr.Int && (target.IntVal < 1 || target.IntVal > 65535):
+			p.warnf("invalid %s %q, expected a port between 1 and 65535", targetKey, targetValue)
+			return nil, true
+		case target.Type == intstr.String && len(validation.IsValidPortName(target.StrVal)) > 0:
+			p.warnf("invalid %s %q, expected a port number or name", targetKey, targetValue)
+			return nil, true
+		}
+	}
+
+	return &v1.ServicePort{
+		Protocol:   v1.ProtocolTCP,
+		Port:       int32(port),
+		TargetPort: target,
+	}, false
+}
+
+// portList parses comma-separated [name:]port[->target] entries into
+// ServicePorts. The name defaults to port-<port> and the target, a number or
+// a container port name, to the port. Malformed and duplicate entries are
+// skipped with a warning.
+func (p *annotationParser) portList(key string) []v1.ServicePort {
+	var ports []v1.ServicePort
+	seenPorts := map[int32]bool{}
+	seenNames := map[string]bool{}
+	for _, entry := range splitList(p.annotations[key]) {
+		name, rest, hasName := strings.Cut(entry, ":")
+		if !hasName {
+			name, rest = "", entry
+		}
+		portValue, targetValue, hasTarget := strings.Cut(rest, "->")
+
+		port, err := strconv.ParseInt(strings.TrimSpace(portValue), 10, 32)
+		if err != nil || port < 1 || port > 65535 {
+			p.warnf("invalid port in %s entry %q, expected a port between 1 and 65535", key, entry)
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if name == "" {
+			name = fmt.Sprintf("port-%d", port)
+		} else if len(validation.IsValidPortName(name)) > 0 {
+			p.warnf("invalid name in %s entry %q", key, entry)
+			continue
+		}
+
+		target := intstr.FromInt32(int32(port))
+		if hasTarget {
+			target = intstr.Parse(strings.TrimSpace(targetValue))
+			if (target.Type == intstr.Int && (target.IntVal < 1 || target.IntVal > 65535)) ||
+				(target.Type == intstr.String && len(validation.IsValidPortName(target.StrVal)) > 0) {
+				p.warnf("invalid target port in %s entry %q, expected a port number or name", key, entry)
+				continue
+			}
+		}
+
+		if seenPorts[int32(port)] || seenNames[name] {
+			p.warnf("duplicate port or name in %s entry %q", key, entry)
+			continue
+		}
+		seenPorts[int32(port)] = true
+		seenNames[name] = true
+
+		ports = append(ports, v1.ServicePort{
+			Name:       name,
+			Protocol:   v1.ProtocolTCP,
+			Port:       int32(port),
+			TargetPort: target,
+		})
+	}
+	return ports
+}
+
+// loadBalancerClass parses a load balancer class, which must be a
+// label-style qualified name such as "example.com/internal-lb".
+func (p *annotationParser) loadBalancerClass(key string) *string {
+	value, ok := p.annotations[key]
+	if !ok {
+		return nil
+	}
+	if errs := validation.IsQualifiedName(value); len(errs) > 0 {
+		p.warnf("invalid %s %q: %s", key, value, strings.Join(errs, "; "))
+		return nil
+	}
+	return &value
+}
+
+// appProtocol parses an application protocol: an IANA service name such as
+// "http" or a domain-prefixed name such as "kubernetes.io/h2c".
+func (p *annotationParser) appProtocol(key string) string {
+	value, ok := p.annotations[key]
+	if !ok {
+		return ""
+	}
+	if errs := validation.IsQualifiedName(value); len(errs) > 0 {
+		p.warnf("invalid %s %q: %s", key, value, strings.Join(errs, "; "))
+		return ""
+	}
+	return value
+}
+
+// cidrList parses a comma-separated list of CIDRs, skipping invalid ones
+// with a warning.
+func (p *annotationParser) cidrList(key string) []string {
+	var out []string
+	for _, entry := range splitList(p.annotations[key]) {
+		if _, _, err := net.ParseCIDR(entry); err != nil {
+			p.warnf("invalid CIDR %q in %s", entry, key)
+			continue
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+// externalTrafficPolicy parses an external traffic policy. It returns ""
+// when unset or invalid, leaving the API default of Cluster.
+func (p *annotationParser) externalTrafficPolicy(key string) v1.ServiceExternalTrafficPolicy {
+	value, ok := p.annotations[key]
+	if !ok {
+		return ""
+	}
+	switch policy := v1.ServiceExternalTrafficPolicy(value); policy {
+	case v1.ServiceExternalTrafficPolicyCluster, v1.ServiceExternalTrafficPolicyLocal:
+		return policy
+	}
+	p.warnf("invalid %s %q, expected Cluster or Local", key, value)
+	return ""
+}
+
+// internalTrafficPolicy parses an internal traffic policy. It returns nil
+// when unset or invalid, leaving the API default of Cluster.
+func (p *annotationParser) internalTrafficPolicy(key string) *v1.ServiceInternalTrafficPolicy {
+	value, ok := p.annotations[key]
+	if !ok {
+		return nil
+	}
+	switch policy := v1.ServiceInternalTrafficPolicy(value); policy {
+	case v1.ServiceInternalTrafficPolicyCluster, v1.ServiceInternalTrafficPolicyLocal:
+		return &policy
+	}
+	p.warnf("invalid %s %q, expected Cluster or Local", key, value)
+	return nil
+}
+
+// nodePort parses a NodePort. Values outside the default NodePort range are
+// accepted with a warning, since clusters may configure a different range.
+func (p *annotationParser) nodePort(key string) int32 {
+	value, ok := p.annotations[key]
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || n < 1 || n > 65535 {
+		p.warnf("invalid %s %q, expected a port number", key, value)
+		return 0
+	}
+	if n < 30000 || n > 32767 {
+		p.noticef("%s %d is outside the default NodePort range 30000-32767", key, n)
+	}
+	return int32(n)
+}
+
+// maxSessionAffinityTimeout is the longest ClientIP affinity timeout the
+// API server accepts, one day.
+const maxSessionAffinityTimeout = 86400
+
+// sessionAffinity parses the affinity and its ClientIP timeout. An invalid
+// affinity falls back to None; an invalid timeout to the API default.
+func (p *annotationParser) sessionAffinity(key, timeoutKey string) (v1.ServiceAffinity, *int32) {
+	affinity := v1.ServiceAffinityNone
+	if value, ok := p.annotations[key]; ok {
+		switch a := v1.ServiceAffinity(value); a {
+		case v1.ServiceAffinityClientIP, v1.ServiceAffinityNone:
+			affinity = a
+		default:
+			p.warnf("invalid %s %q, expected ClientIP or None", key, value)
+		}
+	}
+
+	value, ok := p.annotations[timeoutKey]
+	if !ok {
+		return affinity, nil
+	}
+	if affinity != v1.ServiceAffinityClientIP {
+		p.noticef("%s only applies when %s is ClientIP", timeoutKey, key)
+		return affinity, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || n < 1 || n > maxSessionAffinityTimeout {
+		p.warnf("invalid %s %q, expected 1 to %d seconds", timeoutKey, value, maxSessionAffinityTimeout)
+		return affinity, nil
+	}
+	timeout := int32(n)
+	return affinity, &timeout
+}
+
+// splitList splits a comma-separated annotation value, dropping blanks.
+func splitList(value string) []string {
+	var out []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// serviceAnnotations derives the managed Service annotations: the
+// Deployment annotations matching a copy prefix and the scrape annotations,
+// recorded in the copied-annotations annotation, then the ones derived from
+// cfg.
+func (c *Controller) serviceAnnotations(wl *workload, cfg *ExposeConfig, ports []v1.ServicePort) map[string]string {
+	out := c.copiedKeys(wl.Annotations)
+	if cfg.Scrape && len(ports) > 0 {
+		out[prometheusScrapeAnnotation] = "true"
+		out[prometheusPortAnnotation] = strconv.Itoa(int(scrapePort(ports)))
+		out[prometheusPathAnnotation] = cfg.ScrapePath
+	}
+	if len(out) > 0 {
+		keys := make([]string, 0, len(out))
+		for k := range out {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		out[copiedAnnotationsAnnotation] = strings.Join(keys, ",")
+	}
+	if cfg.RetainOnDelete {
+		out[retainedAnnotation] = "true"
+	}
+	if cfg.DNSHostname != "" {
+		out[c.opts.DNSAnnotationKey] = cfg.DNSHostname
+	}
+	if cfg.Weight != nil {
+		out[c.opts.WeightAnnotationKey] = strconv.Itoa(*cfg.Weight)
+	}
+	return out
+}
+
+// scrapePort returns the Service port named "metrics", or the first port.
+func scrapePort(ports []v1.ServicePort) int32 {
+	for _, p := range ports {
+		if p.Name == "metrics" {
+			return p.Port
+		}
+	}
+	return ports[0].Port
+}
@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseExposeConfig(t *testing.T) {
+	deref32 := func(p *int32) interface{} {
+		if p == nil {
+			return nil
+		}
+		return *p
+	}
+	portsOf := func(ports []v1.ServicePort) []string {
+		var out []string
+		for _, p := range ports {
+			out = append(out, fmt.Sprintf("%s:%d->%s", p.Name, p.Port, p.TargetPort.String()))
+		}
+		return out
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		// got extracts the parsed value under test from the config.
+		got  func(*ExposeConfig) interface{}
+		want interface{}
+		// wantWarning is a substring of the expected warning, empty if
+		// parsing must not warn.
+		wantWarning string
+	}{
+		{name: "enabled", annotations: map[string]string{enabledAnnotation: "true"}, got: func(c *ExposeConfig) interface{} { return c.Enabled }, want: true},
+		{name: "enabled is case-insensitive", annotations: map[string]string{enabledAnnotation: "TRUE"}, got: func(c *ExposeConfig) interface{} { return c.Enabled }, want: true},
+		{name: "enabled invalid", annotations: map[string]string{enabledAnnotation: "yes"}, got: func(c *ExposeConfig) interface{} { return c.Enabled }, want: false, wantWarning: "expected true or false"},
+		{name: "missing booleans are false", annotations: map[string]string{}, got: func(c *ExposeConfig) interface{} {
+			return c.Enabled || c.Dual || c.RetainOnDelete || c.NoFallbackPort || c.Scrape || c.PerPod || c.RemoveWhenScaledToZero
+		}, want: false},
+		{name: "dual", annotations: map[string]string{dualAnnotation: "true"}, got: func(c *ExposeConfig) interface{} { return c.Dual }, want: true},
+		{name: "per-pod", annotations: map[string]string{perPodAnnotation: "true"}, got: func(c *ExposeConfig) interface{} { return c.PerPod }, want: true},
+		{name: "retain on delete", annotations: map[string]string{retainOnDeleteAnnotation: "1"}, got: func(c *ExposeConfig) interface{} { return c.RetainOnDelete }, want: true},
+
+		{name: "service type", annotations: map[string]string{serviceTypeAnnotation: "LoadBalancer"}, got: func(c *ExposeConfig) interface{} { return c.ServiceType }, want: v1.ServiceTypeLoadBalancer},
+		{name: "service type unset", annotations: map[string]string{}, got: func(c *ExposeConfig) interface{} { return c.ServiceType }, want: v1.ServiceType("")},
+		{name: "service type invalid", annotations: map[string]string{serviceTypeAnnotation: "ExternalName"}, got: func(c *ExposeConfig) interface{} { return c.ServiceType }, want: v1.ServiceType(""), wantWarning: "expected ClusterIP, NodePort or LoadBalancer"},
+
+		{name: "weight", annotations: map[string]string{weightAnnotation: "10"}, got: func(c *ExposeConfig) interface{} { return *c.Weight }, want: 10},
+		{name: "weight negative", annotations: map[string]string{weightAnnotation: "-1"}, got: func(c *ExposeConfig) interface{} { return c.Weight == nil }, want: true, wantWarning: "non-negative integer"},
+
+		{name: "exclude ports", annotations: map[string]string{excludePortsAnnotation: "9090, 8081"}, got: func(c *ExposeConfig) interface{} { return c.ExcludePorts }, want: map[int32]bool{9090: true, 8081: true}},
+		{name: "exclude ports invalid entry", annotations: map[string]string{excludePortsAnnotation: "9090,abc"}, got: func(c *ExposeConfig) interface{} { return c.ExcludePorts }, want: map[int32]bool{9090: true}, wantWarning: `invalid port "abc"`},
+		{name: "exclude port names", annotations: map[string]string{excludePortNamesAnnotation: "metrics,,debug"}, got: func(c *ExposeConfig) interface{} { return c.ExcludePortNames }, want: map[string]bool{"metrics": true, "debug": true}},
+
+		{name: "single ip family", annotations: map[string]string{ipFamiliesAnnotation: "IPv6"}, got: func(c *ExposeConfig) interface{} {
+			return []interface{}{c.IPFamilies, *c.IPFamilyPolicy}
+		}, want: []interface{}{[]v1.IPFamily{v1.IPv6Protocol}, v1.IPFamilyPolicySingleStack}},
+		{name: "dual ip families", annotations: map[string]string{ipFamiliesAnnotation: "IPv4,IPv6"}, got: func(c *ExposeConfig) interface{} {
+			return []interface{}{c.IPFamilies, *c.IPFamilyPolicy}
+		}, want: []interface{}{[]v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}, v1.IPFamilyPolicyRequireDualStack}},
+		{name: "duplicate ip family", annotations: map[string]string{ipFamiliesAnnotation: "IPv4,IPv4"}, got: func(c *ExposeConfig) interface{} { return c.IPFamilies == nil && c.IPFamilyPolicy == nil }, want: true, wantWarning: "expected IPv4 and/or IPv6"},
+		{name: "ip family policy overrides", annotations: map[string]string{ipFamiliesAnnotation: "IPv4", ipFamilyPolicyAnnotation: "PreferDualStack"}, got: func(c *ExposeConfig) interface{} { return *c.IPFamilyPolicy }, want: v1.IPFamilyPolicyPreferDualStack},
+		{name: "single stack conflicts with two families", annotations: map[string]string{ipFamiliesAnnotation: "IPv4,IPv6", ipFamilyPolicyAnnotation: "SingleStack"}, got: func(c *ExposeConfig) interface{} { return *c.IPFamilyPolicy }, want: v1.IPFamilyPolicyRequireDualStack, wantWarning: "conflicts with two"},
+		{name: "ip family policy invalid", annotations: map[string]string{ipFamilyPolicyAnnotation: "DualStack"}, got: func(c *ExposeConfig) interface{} { return c.IPFamilyPolicy == nil }, want: true, wantWarning: "expected SingleStack"},
+
+		{name: "port override", annotations: map[string]string{portAnnotation: "80", targetPortAnnotation: "http"}, got: func(c *ExposeConfig) interface{} {
+			return []interface{}{c.PortOverride.Port, c.PortOverride.TargetPort.String(), c.InvalidPortOverride}
+		}, want: []interface{}{int32(80), "http", false}},
+		{name: "target port alone", annotations: map[string]string{targetPortAnnotation: "8080"}, got: func(c *ExposeConfig) interface{} {
+			return []interface{}{c.PortOverride.Port, c.PortOverride.TargetPort.String()}
+		}, want: []interface{}{int32(0), "8080"}},
+		{name: "port override invalid", annotations: map[string]string{portAnnotation: "0"}, got: func(c *ExposeConfig) interface{} { return c.InvalidPortOverride }, want: true, wantWarning: "expected a port between 1 and 65535"},
+		{name: "target port name invalid", annotations: map[string]string{targetPortAnnotation: "not_a_name"}, got: func(c *ExposeConfig) interface{} { return c.InvalidPortOverride }, want: true, wantWarning: "expected a port number or name"},
+
+		{name: "port list", annotations: map[string]string{portsAnnotation: "http:80->8080, 9090,grpc:50051->grpc"}, got: func(c *ExposeConfig) interface{} { return portsOf(c.Ports) }, want: []string{"http:80->8080", "port-9090:9090->9090", "grpc:50051->grpc"}},
+		{name: "port list skips duplicates", annotations: map[string]string{portsAnnotation: "80,http:80"}, got: func(c *ExposeConfig) interface{} { return portsOf(c.Ports) }, want: []string{"port-80:80->80"}, wantWarning: "duplicate port or name"},
+		{name: "port list without valid entries", annotations: map[string]string{portsAnnotation: "x:y"}, got: func(c *ExposeConfig) interface{} { return c.InvalidPortOverride }, want: true, wantWarning: "invalid port in"},
+		{name: "port list wins over port", annotations: map[string]string{portsAnnotation: "81", portAnnotation: "80"}, got: func(c *ExposeConfig) interface{} { return portsOf(c.Ports) }, want: []string{"port-81:81->81"}, wantWarning: "takes precedence"},
+
+		{name: "client ip affinity", annotations: map[string]string{sessionAffinityAnnotation: "ClientIP", sessionAffinityTimeoutAnnotation: "600"}, got: func(c *ExposeConfig) interface{} {
+			return []interface{}{c.SessionAffinity, deref32(c.SessionAffinityTimeout)}
+		}, want: []interface{}{v1.ServiceAffinityClientIP, int32(600)}},
+		{name: "affinity defaults to none", annotations: map[string]string{}, got: func(c *ExposeConfig) interface{} { return c.SessionAffinity }, want: v1.ServiceAffinityNone},
+		{name: "affinity invalid", annotations: map[string]string{sessionAffinityAnnotation: "Cookie"}, got: func(c *ExposeConfig) interface{} { return c.SessionAffinity }, want: v1.ServiceAffinityNone, wantWarning: "expected ClientIP or None"},
+		{name: "affinity timeout without client ip", annotations: map[string]string{sessionAffinityTimeoutAnnotation: "600"}, got: func(c *ExposeConfig) interface{} { return deref32(c.SessionAffinityTimeout) }, want: nil, wantWarning: "only applies when"},
+		{name: "affinity timeout too long", annotations: map[string]string{sessionAffinityAnnotation: "ClientIP", sessionAffinityTimeoutAnnotation: "86401"}, got: func(c *ExposeConfig) interface{} { return deref32(c.SessionAffinityTimeout) }, want: nil, wantWarning: "expected 1 to 86400 seconds"},
+
+		{name: "external traffic policy", annotations: map[string]string{externalTrafficPolicyAnnotation: "Local"}, got: func(c *ExposeConfig) interface{} { return c.ExternalTrafficPolicy }, want: v1.ServiceExternalTrafficPolicyLocal},
+		{name: "external traffic policy invalid", annotations: map[string]string{externalTrafficPolicyAnnotation: "local"}, got: func(c *ExposeConfig) interface{} { return c.ExternalTrafficPolicy }, want: v1.ServiceExternalTrafficPolicy(""), wantWarning: "expected Cluster or Local"},
+		{name: "internal traffic policy", annotations: map[string]string{internalTrafficPolicyAnnotation: "Local"}, got: func(c *ExposeConfig) interface{} { return *c.InternalTrafficPolicy }, want: v1.ServiceInternalTrafficPolicyLocal},
+		{name: "internal traffic policy invalid", annotations: map[string]string{internalTrafficPolicyAnnotation: "Node"}, got: func(c *ExposeConfig) interface{} { return c.InternalTrafficPolicy == nil }, want: true, wantWarning: "expected Cluster or Local"},
+
+		{name: "node port", annotations: map[string]string{nodePortAnnotation: "30080"}, got: func(c *ExposeConfig) interface{} { return c.NodePort }, want: int32(30080)},
+		{name: "node port outside default range", annotations: map[string]string{nodePortAnnotation: "8080"}, got: func(c *ExposeConfig) interface{} { return c.NodePort }, want: int32(8080), wantWarning: "outside the default NodePort range"},
+		{name: "node port invalid", annotations: map[string]string{nodePortAnnotation: "http"}, got: func(c *ExposeConfig) interface{} { return c.NodePort }, want: int32(0), wantWarning: "expected a port number"},
+
+		{name: "load balancer class", annotations: map[string]string{loadBalancerClassAnnotation: "example.com/internal"}, got: func(c *ExposeConfig) interface{} { return *c.LoadBalancerClass }, want: "example.com/internal"},
+		{name: "load balancer class invalid", annotations: map[string]string{loadBalancerClassAnnotation: "bad class"}, got: func(c *ExposeConfig) interface{} { return c.LoadBalancerClass == nil }, want: true, wantWarning: "invalid " + loadBalancerClassAnnotation},
+		{name: "source ranges", annotations: map[string]string{loadBalancerSourceRangesAnnotation: "10.0.0.0/8, 192.168.0.0/16"}, got: func(c *ExposeConfig) interface{} { return c.LoadBalancerSourceRanges }, want: []string{"10.0.0.0/8", "192.168.0.0/16"}},
+		{name: "source ranges invalid entry", annotations: map[string]string{loadBalancerSourceRangesAnnotation: "10.0.0.0/8,10.0.0.1"}, got: func(c *ExposeConfig) interface{} { return c.LoadBalancerSourceRanges }, want: []string{"10.0.0.0/8"}, wantWarning: `invalid CIDR "10.0.0.1"`},
+
+		{name: "scrape path defaults", annotations: map[string]string{scrapeAnnotation: "true"}, got: func(c *ExposeConfig) interface{} { return []interface{}{c.Scrape, c.ScrapePath} }, want: []interface{}{true, defaultScrapePath}},
+		{name: "scrape path", annotations: map[string]string{scrapePathAnnotation: "/stats"}, got: func(c *ExposeConfig) interface{} { return c.ScrapePath }, want: "/stats"},
+		{name: "scrape path relative", annotations: map[string]string{scrapePathAnnotation: "stats"}, got: func(c *ExposeConfig) interface{} { return c.ScrapePath }, want: defaultScrapePath, wantWarning: "expected an absolute path"},
+
+		{name: "app protocol", annotations: map[string]string{appProtocolAnnotation: "kubernetes.io/h2c"}, got: func(c *ExposeConfig) interface{} { return c.AppProtocol }, want: "kubernetes.io/h2c"},
+		{name: "app protocol invalid", annotations: map[string]string{appProtocolAnnotation: "h2c?"}, got: func(c *ExposeConfig) interface{} { return c.AppProtocol }, want: "", wantWarning: "invalid " + appProtocolAnnotation},
+
+		{name: "gateway and host", annotations: map[string]string{gatewayAnnotation: "infra/gw", hostAnnotation: "web.example.com"}, got: func(c *ExposeConfig) interface{} { return []interface{}{c.Gateway, c.Host} }, want: []interface{}{"infra/gw", "web.example.com"}},
+		{name: "gateway without host", annotations: map[string]string{gatewayAnnotation: "gw"}, got: func(c *ExposeConfig) interface{} { return c.Gateway }, want: "gw", wantWarning: "must be set together"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			cfg, warnings := parseExposeConfig(deploy)
+
+			if got := tt.got(cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsed %#v, want %#v", got, tt.want)
+			}
+			if tt.wantWarning == "" {
+				if len(warnings) > 0 {
+					t.Errorf("unexpected warnings %q", warnings)
+				}
+				return
+			}
+			if len(warnings) != 1 || !strings.Contains(warnings[0], tt.wantWarning) {
+				t.Errorf("warnings = %q, want one containing %q", warnings, tt.wantWarning)
+			}
+		})
+	}
+}
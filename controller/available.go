@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// availableRecheckBase and availableRecheckMax bound the backoff with
+	// which a Deployment waiting to become available is reconciled again.
+	availableRecheckBase = time.Second
+	availableRecheckMax  = time.Minute
+)
+
+// deploymentAvailable reports whether deploy has the Available condition
+// set to True or, before the Deployment controller has set conditions, any
+// available replica.
+func deploymentAvailable(deploy *appsv1.Deployment) bool {
+	for _, cond := range deploy.Status.Conditions {
+		if cond.Type == appsv1.DeploymentAvailable {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return deploy.Status.AvailableReplicas > 0
+}
+
+// waitingOnAvailability reports whether creating the Service must wait for
+// wl to become available. Only creation is deferred: an existing Service is
+// kept even if the workload later becomes unavailable.
+func (c *Controller) waitingOnAvailability(wl *workload, svcName string) (bool, error) {
+	if !c.opts.WaitForAvailable {
+		return false, nil
+	}
+
+	_, err := c.serviceLister.Services(wl.Namespace).Get(svcName)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to get service %s/%s: %v", wl.Namespace, svcName, err)
+	}
+	return !wl.available, nil
+}
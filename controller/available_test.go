@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDeploymentAvailable(t *testing.T) {
+	condition := func(status v1.ConditionStatus) []appsv1.DeploymentCondition {
+		return []appsv1.DeploymentCondition{{Type: appsv1.DeploymentAvailable, Status: status}}
+	}
+	tests := []struct {
+		name   string
+		status appsv1.DeploymentStatus
+		want   bool
+	}{
+		{name: "new deployment"},
+		{name: "available replicas without conditions", status: appsv1.DeploymentStatus{AvailableReplicas: 1}, want: true},
+		{name: "available condition", status: appsv1.DeploymentStatus{Conditions: condition(v1.ConditionTrue)}, want: true},
+		{name: "condition wins over replicas", status: appsv1.DeploymentStatus{AvailableReplicas: 1, Conditions: condition(v1.ConditionFalse)}},
+	}
+	for _, tt := range tests {
+		deploy := newDeployment("web")
+		deploy.Status = tt.status
+		if got := deploymentAvailable(deploy); got != tt.want {
+			t.Errorf("%s: deploymentAvailable = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWaitForAvailable(t *testing.T) {
+	f := newFixture(t, Options{WaitForAvailable: true}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	setAvailable := func(replicas int32) {
+		t.Helper()
+		deploy := f.getDeployment("web")
+		deploy.Status.AvailableReplicas = replicas
+		if _, err := f.client.AppsV1().Deployments("default").UpdateStatus(context.Background(), deploy, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("updating deployment status: %v", err)
+		}
+	}
+
+	for i := 1; i <= 2; i++ {
+		f.mustSync("default/web")
+		if f.service("default", "web-expose") != nil {
+			t.Fatal("service was created before the deployment was available")
+		}
+		if n := f.c.availableBackoff.NumRequeues("default/web"); n != i {
+			t.Errorf("rechecks after %d syncs = %d, want %d", i, n, i)
+		}
+	}
+
+	setAvailable(1)
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") == nil {
+		t.Fatal("service was not created once the deployment was available")
+	}
+	if n := f.c.availableBackoff.NumRequeues("default/web"); n != 0 {
+		t.Errorf("rechecks once available = %d, want the backoff reset", n)
+	}
+
+	// Only creation waits on availability.
+	setAvailable(0)
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") == nil {
+		t.Error("service was removed when the deployment became unavailable")
+	}
+}
@@ -2,182 +2,1064 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
+	stderrors "errors"
 	"fmt"
-	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	appsInformer "k8s.io/client-go/listers/apps/v1"
 	coreInformer "k8s.io/client-go/listers/core/v1"
+	networkingInformer "k8s.io/client-go/listers/networking/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 )
 
+const (
+	// exposeSuffix is the default suffix of the Service generated for a
+	// Deployment.
+	exposeSuffix = "-expose"
+	// internalSuffix names the additional ClusterIP Service of a Deployment
+	// published in dual mode.
+	internalSuffix = "-internal"
+)
+
+// conflictRetry retries a conflicting Service update once against the live
+// Service before the key is requeued.
+var conflictRetry = wait.Backoff{Steps: 2, Duration: 10 * time.Millisecond, Factor: 1, Jitter: 0.1}
+
+// Options holds the optional behaviour of the controller. The zero value
+// applies Services directly to the cluster.
+type Options struct {
+	// OutputDir, when set, makes the controller render Services as YAML
+	// files into this directory instead of applying them to the cluster.
+	OutputDir string
+	// PostCreateRequeue re-queues a key this long after its Service was
+	// created, covering the window before the Service informer observes it.
+	// Zero disables the requeue.
+	PostCreateRequeue time.Duration
+	// DNSAnnotationKey is the Service annotation that carries the hostname
+	// requested through the dns-hostname Deployment annotation.
+	DNSAnnotationKey string
+	// WeightAnnotationKey is the Service annotation that carries the value of
+	// the weight Deployment annotation.
+	WeightAnnotationKey string
+	// AmbiguousPortPolicy applies to multi-container Deployments without
+	// any declared port. Defaults to AmbiguousPortSkip.
+	AmbiguousPortPolicy AmbiguousPortPolicy
+	// RetryMetricThreshold is the number of requeues above which a key is
+	// exported in the expose_key_retries gauge. Keys at or below it are not
+	// exported, which bounds the metric's cardinality.
+	RetryMetricThreshold int
+	// OrphanDeleteDelay postpones removing the Service of a deleted
+	// Deployment, so a Deployment recreated within the delay keeps it.
+	// Services get no owner reference then, or garbage collection would
+	// remove them right away.
+	OrphanDeleteDelay time.Duration
+	// OrphanOnDelete retains the Services of every deleted Deployment, as if
+	// each carried the retain-on-delete annotation.
+	OrphanOnDelete bool
+	// PreferProbePort exposes a container's readiness probe port instead of
+	// its declared ports when the probe uses HTTP or TCP.
+	PreferProbePort bool
+	// NamespaceOptIn only exposes Deployments in namespaces annotated with
+	// the enabled annotation.
+	NamespaceOptIn bool
+	// ReadinessGates watches Pods so Deployments can defer exposure with
+	// the readiness-gate annotation.
+	ReadinessGates bool
+	// MaxRetries is the number of times a failing key is requeued before
+	// the controller gives up on it until the Deployment changes again.
+	// Zero retries forever.
+	MaxRetries int
+	// ServiceSuffix is appended to a Deployment's name to name its Service.
+	// Defaults to DefaultServiceSuffix.
+	ServiceSuffix string
+	// DryRun logs the Service changes the controller would make instead of
+	// applying them. Reads and reconcile decisions are unaffected.
+	DryRun bool
+	// CopyPrefixes lists label and annotation key prefixes copied from the
+	// Deployment onto its Services.
+	CopyPrefixes []string
+	// DrainTimeout bounds how long Run keeps processing queued keys after
+	// its context is cancelled. Defaults to DefaultDrainTimeout.
+	DrainTimeout time.Duration
+	// Selector, when set, restricts exposure to Deployments whose labels
+	// match it.
+	Selector labels.Selector
+	// ExcludeNamespaces lists namespaces whose Deployments are never
+	// exposed.
+	ExcludeNamespaces map[string]bool
+	// Finalizer adds a cleanup finalizer to exposed Deployments, so their
+	// Services are removed by the controller before the Deployment is gone
+	// rather than by garbage collection afterwards.
+	Finalizer bool
+	// Debounce delays a key enqueued within this long of its last reconcile
+	// until the window has passed, so bursts of events coalesce into one
+	// reconcile. Zero disables it.
+	Debounce time.Duration
+	// ImmutableChangePolicy applies when a Service update is rejected as
+	// invalid. Defaults to ImmutableChangeUpdate.
+	ImmutableChangePolicy ImmutableChangePolicy
+	// ControllerName identifies this controller on the objects it manages.
+	// Controllers with different names never touch each other's objects.
+	// Defaults to DefaultControllerName.
+	ControllerName string
+	// WatchStatefulSets exposes annotated StatefulSets the same way as
+	// Deployments. StatefulSets never get the cleanup finalizer or the
+	// status annotations.
+	WatchStatefulSets bool
+	// DefaultPorts are exposed when no container declares a port. When
+	// empty, port 80 is exposed as "http".
+	DefaultPorts []v1.ServicePort
+	// ReconcileTimeout bounds a single reconcile, including its API calls,
+	// so a hung call fails the key and frees the worker. Zero disables it.
+	ReconcileTimeout time.Duration
+	// WaitForAvailable defers creating a Service until its Deployment is
+	// available. An existing Service is never removed for it.
+	WaitForAvailable bool
+	// DefaultServiceType is the type of a Deployment's Service when its
+	// service-type annotation is missing or invalid. Defaults to NodePort.
+	DefaultServiceType v1.ServiceType
+	// ServiceNamespace, when set, additionally gets an ExternalName Service
+	// per exposed workload of another namespace, resolving to the
+	// workload's own Service. The informers must cover it.
+	ServiceNamespace string
+}
+
+// DefaultMaxRetries is the retry limit main applies unless configured
+// otherwise. With the default 5ms base delay, doubled on every failure, the
+// retries span about ten minutes, enough to ride out an API server restart.
+const DefaultMaxRetries = 17
+
+// DefaultDrainTimeout is the default Options.DrainTimeout.
+const DefaultDrainTimeout = 10 * time.Second
+
+// DefaultReconcileTimeout is the reconcile timeout main applies unless
+// configured otherwise.
+const DefaultReconcileTimeout = 30 * time.Second
+
 type Controller struct {
 	clientset     kubernetes.Interface
 	deployLister  appsInformer.DeploymentLister
 	serviceLister coreInformer.ServiceLister
-	queue         workqueue.RateLimitingInterface
+	ingressLister networkingInformer.IngressLister
+	queue         *trackedQueue
+	recorder      record.EventRecorder
+	opts          Options
+	synced        []cache.InformerSynced
 	StopCh        chan struct{}
+
+	// dynamicClient and routeLister are set when the Gateway API is
+	// installed; HTTPRoute reconciliation is skipped otherwise.
+	dynamicClient dynamic.Interface
+	routeLister   cache.GenericLister
+
+	// namespaceLister is set when namespace opt-in is enabled.
+	namespaceLister coreInformer.NamespaceLister
+
+	// podLister is set when readiness gates are enabled.
+	podLister coreInformer.PodLister
+
+	// statefulSetLister is set when StatefulSets are watched.
+	statefulSetLister appsInformer.StatefulSetLister
+
+	orphanMu        sync.Mutex
+	orphanDeadlines map[string]time.Time
+
+	debounceMu sync.Mutex
+	lastSynced map[string]time.Time
+
+	errLogMu sync.Mutex
+	errLogs  map[string]*errorLogState
+
+	// report holds the Service changes a dry run found, per workload key.
+	// It is only collected by RunOnce, so a long-running dry run does not
+	// keep every change it logged.
+	reportMu  sync.Mutex
+	report    map[string][]plannedChange
+	reporting atomic.Bool
+
+	// stuck holds the keys above the retry metric threshold.
+	stuckMu sync.Mutex
+	stuck   map[string]bool
+
+	// availableBackoff spaces out rechecks of Deployments waiting to
+	// become available.
+	availableBackoff workqueue.TypedRateLimiter[string]
+
+	// cachesSynced is set once WaitForCacheSync succeeds.
+	cachesSynced atomic.Bool
 }
 
-func NewController(clientset kubernetes.Interface, deployInformer appsInformer.DeploymentLister, serviceInformer coreInformer.ServiceLister, queue workqueue.RateLimitingInterface) *Controller {
-	return &Controller{
+// NewController builds the controller's listers from factory and registers
+// its event handlers. The factory must be started after this returns.
+func NewController(clientset kubernetes.Interface, factory informers.SharedInformerFactory, queue workqueue.RateLimitingInterface, opts Options) (*Controller, error) {
+	if opts.DNSAnnotationKey == "" {
+		opts.DNSAnnotationKey = DefaultDNSAnnotationKey
+	}
+	if opts.WeightAnnotationKey == "" {
+		opts.WeightAnnotationKey = DefaultWeightAnnotationKey
+	}
+	if opts.DefaultServiceType == "" {
+		opts.DefaultServiceType = v1.ServiceTypeNodePort
+	}
+	if opts.AmbiguousPortPolicy == "" {
+		opts.AmbiguousPortPolicy = AmbiguousPortSkip
+	}
+	if opts.ImmutableChangePolicy == "" {
+		opts.ImmutableChangePolicy = ImmutableChangeUpdate
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = DefaultDrainTimeout
+	}
+	if opts.ControllerName == "" {
+		opts.ControllerName = DefaultControllerName
+	}
+	if opts.ServiceSuffix == "" {
+		opts.ServiceSuffix = DefaultServiceSuffix
+	}
+	if err := ValidateServiceSuffix(opts.ServiceSuffix); err != nil {
+		return nil, err
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: opts.ControllerName})
+
+	deployInformer := factory.Apps().V1().Deployments()
+	serviceInformer := factory.Core().V1().Services()
+	ingressInformer := factory.Networking().V1().Ingresses()
+
+	c := &Controller{
 		clientset:     clientset,
-		deployLister:  deployInformer,
-		serviceLister: serviceInformer,
-		queue:         queue,
+		recorder:      recorder,
+		deployLister:  deployInformer.Lister(),
+		serviceLister: serviceInformer.Lister(),
+		ingressLister: ingressInformer.Lister(),
+		queue:         newTrackedQueue(queue),
+		opts:          opts,
+		synced:        []cache.InformerSynced{deployInformer.Informer().HasSynced, serviceInformer.Informer().HasSynced, ingressInformer.Informer().HasSynced},
 		StopCh:        make(chan struct{}),
+
+		orphanDeadlines: map[string]time.Time{},
+		lastSynced:      map[string]time.Time{},
+		errLogs:         map[string]*errorLogState{},
+		report:          map[string][]plannedChange{},
+		stuck:           map[string]bool{},
+
+		availableBackoff: workqueue.NewTypedItemExponentialFailureRateLimiter[string](availableRecheckBase, availableRecheckMax),
+	}
+
+	if _, err := deployInformer.Informer().AddEventHandler(c.deploymentHandlers()); err != nil {
+		return nil, fmt.Errorf("failed to add deployment event handler: %v", err)
+	}
+	if _, err := serviceInformer.Informer().AddEventHandler(c.serviceHandlers()); err != nil {
+		return nil, fmt.Errorf("failed to add service event handler: %v", err)
+	}
+
+	if opts.NamespaceOptIn {
+		namespaceInformer := factory.Core().V1().Namespaces()
+		c.namespaceLister = namespaceInformer.Lister()
+		c.synced = append(c.synced, namespaceInformer.Informer().HasSynced)
+		if _, err := namespaceInformer.Informer().AddEventHandler(c.namespaceHandlers()); err != nil {
+			return nil, fmt.Errorf("failed to add namespace event handler: %v", err)
+		}
+	}
+
+	if opts.ReadinessGates {
+		podInformer := factory.Core().V1().Pods()
+		c.podLister = podInformer.Lister()
+		c.synced = append(c.synced, podInformer.Informer().HasSynced)
+	}
+
+	if opts.WatchStatefulSets {
+		statefulSetInformer := factory.Apps().V1().StatefulSets()
+		c.statefulSetLister = statefulSetInformer.Lister()
+		c.synced = append(c.synced, statefulSetInformer.Informer().HasSynced)
+		if _, err := statefulSetInformer.Informer().AddEventHandler(c.statefulSetHandlers()); err != nil {
+			return nil, fmt.Errorf("failed to add statefulset event handler: %v", err)
+		}
+	}
+
+	return c, nil
+}
+
+// WaitForCacheSync blocks until the informers the controller reads from
+// have synced, returning false if StopCh closed first.
+func (c *Controller) WaitForCacheSync() bool {
+	if !cache.WaitForCacheSync(c.StopCh, c.synced...) {
+		return false
 	}
+	c.cachesSynced.Store(true)
+	return true
+}
+
+// Ready reports whether the controller's caches have synced.
+func (c *Controller) Ready() bool {
+	return c.cachesSynced.Load()
 }
 
+// EnqueueKey queues key for a reconcile, delaying it while the key is inside
+// its debounce window.
 func (c *Controller) EnqueueKey(key string) {
+	if c.opts.Debounce > 0 {
+		if wait := c.debounceDelay(key); wait > 0 {
+			c.queue.AddAfter(key, wait)
+			return
+		}
+	}
 	c.queue.Add(key)
 }
 
-func (c *Controller) Run(workers int) {
+// Run starts the workers and blocks until ctx is cancelled. It then shuts
+// the queue down and lets the workers drain the keys still queued for up to
+// DrainTimeout, after which in-flight API calls are cancelled. It returns
+// once every worker has exited.
+func (c *Controller) Run(ctx context.Context, workers int) {
+	// Reconciles run on their own context so cancelling ctx does not abort
+	// the work being drained.
+	workCtx, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+
+	var wg sync.WaitGroup
 	for range workers {
-		go wait.Until(c.worker, time.Second*30, c.StopCh)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.worker(workCtx)
+		}()
 	}
-	<-c.StopCh
+
+	<-ctx.Done()
+	logger := klog.FromContext(ctx)
+	logger.Info("Shutting down workers", "queued", c.queue.Len())
+	c.queue.ShutDown()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		logger.Info("Workers drained the queue")
+	case <-time.After(c.opts.DrainTimeout):
+		logger.Info("Workers did not drain the queue in time, dropping keys", "drainTimeout", c.opts.DrainTimeout, "dropped", c.queue.Len())
+		cancelWork()
+		<-done
+	}
+	logger.Info("Workers stopped")
 }
 
-func (c *Controller) worker() {
-	for c.processItem() {
+// worker processes keys until the queue shuts down. A panicking reconcile
+// is turned into an error by processItem, so a worker is never restarted.
+func (c *Controller) worker(ctx context.Context) {
+	for c.processItem(ctx) {
 	}
 }
 
-func (c *Controller) processItem() bool {
+func (c *Controller) processItem(ctx context.Context) bool {
 	obj, shutdown := c.queue.Get()
 	if shutdown {
 		return false
 	}
+	queueDepth.Set(float64(c.queue.Len()))
 
 	key, ok := obj.(string)
 	if !ok {
-		klog.Errorf("Expected string key in queue but got: %T", obj)
+		klog.FromContext(ctx).Error(nil, "Expected string key in queue", "type", fmt.Sprintf("%T", obj))
 		c.queue.Done(obj)
 		return true
 	}
 
-	klog.Infof("Processing key: %s", key)
-	err := c.syncHandler(key)
+	logger := klog.FromContext(ctx).WithValues("key", key)
+	logger.V(4).Info("Processing key")
+	// Marked before the reconcile, which forgets the key again when it
+	// finds the workload deleted.
+	c.markSynced(key)
+	err := c.safeSync(ctx, key)
 	c.queue.Done(obj)
+	recordReconcile(key, err)
 
+	var nre *nonRetryableError
+	if stderrors.As(err, &nre) {
+		logger.Error(err, "Error syncing, not retrying")
+		recordNonRetryable(key, nre.reason)
+		c.reconcileFailed(key, err)
+		c.forget(key)
+		return true
+	}
 	if err != nil {
-		klog.Errorf("Error syncing %s: %v", key, err)
+		c.reconcileFailed(key, err)
+		if c.opts.MaxRetries > 0 && c.queue.NumRequeues(key) >= c.opts.MaxRetries {
+			logger.Error(err, "Error syncing, giving up", "retries", c.opts.MaxRetries)
+			c.forget(key)
+			return true
+		}
+		c.logSyncError(logger, key, err)
 		c.queue.AddRateLimited(key)
+		recordRequeue(key)
+		c.recordRetries(key)
 		return true
 	}
 
+	c.forget(key)
 	return true
 }
 
-func (c *Controller) syncHandler(key string) error {
-	klog.Infof("syncHandler: processing key=%s", key)
+// RunOnce reconciles every Deployment once with the given number of workers
+// and returns how many reconciles failed. Failed keys are not retried, and
+// requeues made while it runs are dropped. The queue is shut down
+// afterwards, so RunOnce cannot be combined with Run. With DryRun, the
+// planned Service changes are collected for WriteDryRunReport.
+func (c *Controller) RunOnce(ctx context.Context, workers int) int {
+	c.reporting.Store(c.opts.DryRun)
+	c.EnqueueAll()
+	// Queued keys are still handed out after ShutDown, later adds are not.
+	c.queue.ShutDown()
 
-	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	var failed atomic.Int32
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				obj, shutdown := c.queue.Get()
+				if shutdown {
+					return
+				}
+				key := obj.(string)
+				err := c.safeSync(ctx, key)
+				c.queue.Done(obj)
+				recordReconcile(key, err)
+				if err != nil {
+					klog.FromContext(ctx).Error(err, "Error syncing", "key", key)
+					c.reconcileFailed(key, err)
+					failed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return int(failed.Load())
+}
+
+// safeSync runs syncHandler within the reconcile timeout, turning a panic
+// into an error so the key is marked done and retried instead of taking the
+// worker down with it.
+func (c *Controller) safeSync(ctx context.Context, key string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic syncing %s: %v", key, r)
+		}
+	}()
+	if c.opts.ReconcileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.ReconcileTimeout)
+		defer cancel()
+	}
+	return c.syncHandler(ctx, key)
+}
+
+// reconcileFailed records a Warning event on the Deployment behind key, if
+// it still exists.
+func (c *Controller) reconcileFailed(key string, err error) {
+	kind, objKey := splitWorkloadKey(key)
+	namespace, name, splitErr := cache.SplitMetaNamespaceKey(objKey)
+	if splitErr != nil {
+		return
+	}
+	wl, getErr := c.getWorkload(kind, namespace, name)
+	if getErr != nil {
+		return
+	}
+	c.event(wl, v1.EventTypeWarning, "ReconcileFailed", "Failed to reconcile: %v", err)
+}
+
+// forget resets the key's rate limiting and its retry metric.
+func (c *Controller) forget(key string) {
+	c.queue.Forget(key)
+	c.recordRetries(key)
+	c.forgetSyncError(key)
+}
+
+// nonRetryableError marks a reconcile failure that retrying cannot fix,
+// such as an invalid Deployment spec. The key is dropped from the queue
+// until the Deployment changes again.
+type nonRetryableError struct {
+	reason string
+	err    error
+}
+
+func (e *nonRetryableError) Error() string { return e.err.Error() }
+
+func (e *nonRetryableError) Unwrap() error { return e.err }
+
+func (c *Controller) syncHandler(ctx context.Context, key string) (err error) {
+	start := time.Now()
+	defer func() { reconcileDuration.Observe(time.Since(start).Seconds()) }()
+
+	ctx, span := tracer.Start(ctx, "syncHandler")
+	defer func() { endSpan(span, err) }()
+
+	kind, objKey := splitWorkloadKey(key)
+	namespace, name, err := cache.SplitMetaNamespaceKey(objKey)
 	if err != nil {
 		return fmt.Errorf("invalid resource key %s: %v", key, err)
 	}
+	span.SetAttributes(attribute.String("kind", kind), attribute.String("namespace", namespace), attribute.String("name", name))
+	c.reportVisited(key)
 
-	svcName := name + "-expose"
-	deploy, err := c.deployLister.Deployments(namespace).Get(name)
+	logger := klog.FromContext(ctx).WithValues("kind", kind, "namespace", namespace, "name", name)
+	ctx = klog.NewContext(ctx, logger)
+	logger.V(4).Info("Syncing workload")
+
+	svcName := c.exposeName(name)
+	wl, err := c.getWorkload(kind, namespace, name)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			klog.Infof("Deployment %s/%s deleted, cleaning up service %s", namespace, name, svcName)
-			return c.removeService(namespace, svcName)
+			c.availableBackoff.Forget(key)
+			c.forgetSynced(key)
+			if c.retainedService(namespace, svcName) || c.opts.OrphanOnDelete {
+				logger.Info("Deployment deleted, retaining service", "service", svcName)
+				return c.retainServices(ctx, kind, namespace, name)
+			}
+			deferred, err := c.deferOrphanCleanup(ctx, key, namespace, svcName)
+			if err != nil || deferred {
+				return err
+			}
+			logger.Info("Deployment deleted, cleaning up service", "service", svcName)
+			return c.cleanup(ctx, nil, kind, namespace, name)
 		}
-		return fmt.Errorf("failed to get deployment %s/%s: %v", namespace, name, err)
+		return fmt.Errorf("failed to get %s %s/%s: %v", strings.ToLower(kind), namespace, name, err)
 	}
+	c.cancelOrphanCleanup(ctx, key, namespace, svcName)
 
-	klog.Infof("syncHandler: deployment %s/%s exists, reconciling service...", namespace, name)
+	cfg, warnings := parseExposeConfig(wl)
+	for _, w := range warnings {
+		logger.Info("Invalid annotation", "warning", w)
+		c.recorder.Event(wl.object, v1.EventTypeWarning, "InvalidAnnotation", w)
+	}
+	if cfg.ServiceType == "" {
+		cfg.ServiceType = c.opts.DefaultServiceType
+	}
+	if c.opts.OrphanOnDelete {
+		cfg.RetainOnDelete = true
+	}
 
-	svc, err := c.serviceLister.Services(namespace).Get(svcName)
-	if err != nil && !errors.IsNotFound(err) {
-		return fmt.Errorf("failed to get service %s/%s: %v", namespace, svcName, err)
+	exposed := false
+	defer func() { c.recordStatus(ctx, wl, cfg.Enabled, exposed, err) }()
+
+	if wl.DeletionTimestamp != nil {
+		if !hasFinalizer(wl) {
+			return nil
+		}
+		if cfg.RetainOnDelete {
+			logger.Info("Deployment is being deleted, retaining service", "service", svcName)
+			if err := c.retainServices(ctx, kind, namespace, name); err != nil {
+				return err
+			}
+			return c.removeFinalizer(ctx, wl)
+		}
+		logger.Info("Deployment is being deleted, cleaning up service", "service", svcName)
+		return c.cleanup(ctx, wl, kind, namespace, name)
+	}
+
+	if !cfg.Enabled {
+		logger.V(4).Info("Deployment is not opted in, removing service if present", "service", svcName)
+		return c.cleanup(ctx, wl, kind, namespace, name)
 	}
 
-	selector := deploy.Spec.Template.Labels
-	if len(selector) == 0 {
-		klog.Warningf("Deployment %s/%s has no labels, cannot create service", namespace, name)
+	if c.opts.ExcludeNamespaces[namespace] {
+		logger.V(4).Info("Namespace is excluded, removing service if present", "service", svcName)
+		return c.cleanup(ctx, wl, kind, namespace, name)
+	}
+
+	if c.opts.Selector != nil && !c.opts.Selector.Matches(labels.Set(wl.Labels)) {
+		logger.V(4).Info("Deployment does not match selector, removing service if present", "selector", c.opts.Selector.String(), "service", svcName)
+		return c.cleanup(ctx, wl, kind, namespace, name)
+	}
+
+	if cfg.RemoveWhenScaledToZero && wl.replicas != nil && *wl.replicas == 0 {
+		logger.Info("Deployment is scaled to zero, removing service if present", "service", svcName)
+		return c.cleanup(ctx, wl, kind, namespace, name)
+	}
+
+	optedIn, err := c.namespaceOptedIn(namespace)
+	if err != nil {
+		return err
+	}
+	if !optedIn {
+		logger.V(4).Info("Namespace is not opted in, removing service if present", "service", svcName)
+		return c.cleanup(ctx, wl, kind, namespace, name)
+	}
+
+	logger.V(4).Info("Reconciling service", "service", svcName)
+
+	selector := serviceSelector(wl)
+	if err := validateSelector(wl); err != nil {
+		logger.Info("Deployment cannot be selected by a service, not creating one", "reason", err.Error())
+		c.event(wl, v1.EventTypeWarning, "InvalidSelector", "Not exposing deployment: %v", err)
+		return nil
+	}
+
+	if cfg.InvalidPortOverride {
+		logger.Info("Deployment has invalid port annotations, skipping reconcile")
+		return nil
+	}
+
+	ports, err := c.servicePorts(ctx, wl, cfg)
+	if err != nil {
+		return err
+	}
+	if len(ports) == 0 {
+		logger.Info("Deployment has no ports to expose, not creating service")
 		return nil
 	}
 
-	desired := &v1.Service{
+	if gate := cfg.ReadinessGate; gate != "" {
+		waiting, err := c.waitingOnReadinessGate(ctx, wl, gate, svcName)
+		if err != nil {
+			return err
+		}
+		if waiting {
+			logger.Info("No pod passes the readiness gate yet, deferring service", "gate", gate)
+			c.queue.AddAfter(key, readinessGateRecheck)
+			return nil
+		}
+	}
+
+	waiting, err := c.waitingOnAvailability(wl, svcName)
+	if err != nil {
+		return err
+	}
+	if waiting {
+		delay := c.availableBackoff.When(key)
+		logger.Info("Deployment is not available yet, deferring service", "recheck", delay)
+		c.queue.AddAfter(key, delay)
+		return nil
+	}
+	c.availableBackoff.Forget(key)
+
+	if err := c.ensureFinalizer(ctx, wl); err != nil {
+		return err
+	}
+
+	desired := c.desiredService(wl, cfg, svcName, cfg.ServiceType, selector, ports)
+	if err := c.reconcileService(ctx, key, wl, desired); err != nil {
+		return err
+	}
+
+	internalName := c.internalName(name)
+	if cfg.Dual {
+		internal := c.desiredService(wl, cfg, internalName, v1.ServiceTypeClusterIP, selector, ports)
+		if err := c.reconcileService(ctx, key, wl, internal); err != nil {
+			return err
+		}
+	} else if err := c.removeManagedService(ctx, wl, kind, namespace, name, internalName); err != nil {
+		return err
+	}
+
+	if cfg.PerPod && kind == statefulSetKind {
+		if err := c.reconcilePerPodServices(ctx, key, wl, cfg, selector, ports); err != nil {
+			return err
+		}
+	} else {
+		if cfg.PerPod {
+			logger.Info("Per-pod Services only apply to StatefulSets, ignoring annotation", "annotation", perPodAnnotation)
+		}
+		if err := c.removePerPodServices(ctx, wl, kind, namespace, name, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := c.reconcileHubService(ctx, key, wl, cfg, desired); err != nil {
+		return err
+	}
+
+	if err := c.reconcileHTTPRoute(ctx, cfg, desired); err != nil {
+		return err
+	}
+	if err := c.reconcileIngress(ctx, cfg, desired); err != nil {
+		return err
+	}
+
+	exposed = true
+	logger.V(4).Info("Reconciliation completed")
+	return nil
+}
+
+// serviceSelector returns the Service selector for wl: the match labels of
+// the workload's own selector, which pick exactly its Pods, or the pod
+// template labels when the selector has none.
+func serviceSelector(wl *workload) map[string]string {
+	if wl.selector != nil && len(wl.selector.MatchLabels) > 0 {
+		return wl.selector.MatchLabels
+	}
+	return wl.template.Labels
+}
+
+// validateSelector checks that the pod template labels are non-empty and
+// selected by the workload's own selector, so the Service selector derived
+// from either targets exactly the workload's Pods.
+func validateSelector(wl *workload) error {
+	kind := strings.ToLower(wl.kind)
+	if len(wl.template.Labels) == 0 {
+		return fmt.Errorf("pod template has no labels")
+	}
+	if wl.selector == nil {
+		return fmt.Errorf("%s has no selector", kind)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(wl.selector)
+	if err != nil {
+		return fmt.Errorf("invalid %s selector: %v", kind, err)
+	}
+	if selector.Empty() {
+		return fmt.Errorf("%s selector is empty", kind)
+	}
+	if !selector.Matches(labels.Set(wl.template.Labels)) {
+		return fmt.Errorf("pod template labels do not match %s selector %s", kind, selector)
+	}
+	return nil
+}
+
+// desiredService builds the Service the controller wants for wl.
+func (c *Controller) desiredService(wl *workload, cfg *ExposeConfig, svcName string, svcType v1.ServiceType, selector map[string]string, ports []v1.ServicePort) *v1.Service {
+	svc := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      svcName,
-			Namespace: namespace,
+			Name:        svcName,
+			Namespace:   wl.Namespace,
+			Labels:      c.serviceLabels(wl, cfg),
+			Annotations: c.serviceAnnotations(wl, cfg, ports),
 		},
 		Spec: v1.ServiceSpec{
-			Type:     v1.ServiceTypeNodePort,
+			Type:     svcType,
 			Selector: selector,
-			Ports: []v1.ServicePort{
-				{
-					Name:       "http",
-					Port:       80,
-					TargetPort: intstr.FromInt(80),
-				},
-			},
+			Ports:    ports,
 		},
 	}
 
-	if errors.IsNotFound(err) {
-		return c.createService(desired, namespace, svcName)
+	if cfg.NodePort != 0 && svcType == v1.ServiceTypeNodePort {
+		svc.Spec.Ports = append([]v1.ServicePort(nil), ports...)
+		svc.Spec.Ports[0].NodePort = cfg.NodePort
 	}
 
-	if !reflect.DeepEqual(svc.Spec.Selector, desired.Spec.Selector) ||
-		!reflect.DeepEqual(svc.Spec.Ports, desired.Spec.Ports) {
+	svc.Spec.IPFamilies, svc.Spec.IPFamilyPolicy = cfg.IPFamilies, cfg.IPFamilyPolicy
 
-		klog.Infof("Service %s/%s requires update", namespace, svcName)
-		return c.updateService(svc, desired, namespace, svcName)
+	// Only Services reachable from outside the cluster have an external
+	// traffic policy; the API rejects one on a ClusterIP Service.
+	if svcType != v1.ServiceTypeClusterIP {
+		svc.Spec.ExternalTrafficPolicy = cfg.ExternalTrafficPolicy
 	}
+	svc.Spec.InternalTrafficPolicy = cfg.InternalTrafficPolicy
+	svc.Spec.PublishNotReadyAddresses = cfg.PublishNotReadyAddresses
 
-	klog.Infof("Reconciliation of %s/%s completed successfully", namespace, name)
+	if svcType == v1.ServiceTypeLoadBalancer {
+		svc.Spec.LoadBalancerClass = cfg.LoadBalancerClass
+		svc.Spec.LoadBalancerSourceRanges = cfg.LoadBalancerSourceRanges
+	}
+
+	svc.Spec.SessionAffinity = cfg.SessionAffinity
+	if cfg.SessionAffinity == v1.ServiceAffinityClientIP && cfg.SessionAffinityTimeout != nil {
+		svc.Spec.SessionAffinityConfig = &v1.SessionAffinityConfig{
+			ClientIP: &v1.ClientIPConfig{TimeoutSeconds: cfg.SessionAffinityTimeout},
+		}
+	}
+
+	// The owner reference lets garbage collection remove the Service with
+	// the Deployment, which a retained Service must not be subject to, nor
+	// one whose removal waits for the orphan delete delay.
+	if !cfg.RetainOnDelete && c.opts.OrphanDeleteDelay <= 0 {
+		svc.OwnerReferences = []metav1.OwnerReference{
+			*metav1.NewControllerRef(wl, appsv1.SchemeGroupVersion.WithKind(wl.kind)),
+		}
+	}
+
+	recordManagedLabels(svc)
+	svc.Annotations[specHashAnnotation] = specHash(svc)
+	return svc
+}
+
+// reconcileService creates desired if it is missing, or updates the existing
+// Service when it has drifted.
+func (c *Controller) reconcileService(ctx context.Context, key string, wl *workload, desired *v1.Service) error {
+	namespace, svcName := desired.Namespace, desired.Name
+
+	svc, err := c.serviceLister.Services(namespace).Get(svcName)
+	if errors.IsNotFound(err) {
+		svc, err = nil, nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get service %s/%s: %v", namespace, svcName, err)
+	}
+
+	action, warning := c.planService(wl, svc, desired)
+	c.recordPlan(key, svc, desired, action)
+	if warning != "" {
+		klog.FromContext(ctx).Info("Service change cannot be applied", "service", svcName, "reason", warning)
+	}
+	switch action {
+	case serviceCreate:
+		if err := c.createService(ctx, wl, desired, namespace, svcName); err != nil {
+			return err
+		}
+		if c.opts.PostCreateRequeue > 0 && c.opts.OutputDir == "" && !c.opts.DryRun {
+			c.queue.AddAfter(key, c.opts.PostCreateRequeue)
+		}
+	case serviceUpdate:
+		klog.FromContext(ctx).Info("Service requires update", "service", svcName)
+		return c.updateService(ctx, wl, svc, desired, namespace, svcName)
+	case serviceConflict:
+		klog.FromContext(ctx).Info("Service exists and is not managed by this controller, leaving it alone", "service", svcName)
+		c.event(wl, v1.EventTypeWarning, "ServiceConflict", "Service %s exists and is not managed by %s", svcName, c.opts.ControllerName)
+	case serviceCollision:
+		owner := workloadControllerRef(svc)
+		klog.FromContext(ctx).Info("Service belongs to another workload, leaving it alone", "service", svcName, "ownerKind", owner.Kind, "owner", owner.Name)
+		c.event(wl, v1.EventTypeWarning, "ServiceNameCollision", "Service %s already belongs to %s %s", svcName, strings.ToLower(owner.Kind), owner.Name)
+	}
 	return nil
 }
 
-func (c *Controller) createService(desired *v1.Service, namespace, svcName string) error {
-	klog.Infof("Service %s/%s missing, creating...", namespace, svcName)
-	_, err := c.clientset.CoreV1().Services(namespace).Create(
-		context.Background(),
+// cleanup removes everything the controller created for the kind's object
+// name. Services it does not manage are left alone, since a workload that
+// never opted in may share a name with a user's Service. wl is nil once
+// the workload has been deleted.
+func (c *Controller) cleanup(ctx context.Context, wl *workload, kind, namespace, name string) error {
+	if err := c.removeManagedService(ctx, wl, kind, namespace, name, c.exposeName(name)); err != nil {
+		return err
+	}
+	if err := c.removeManagedService(ctx, wl, kind, namespace, name, c.internalName(name)); err != nil {
+		return err
+	}
+	if err := c.removePerPodServices(ctx, wl, kind, namespace, name, nil); err != nil {
+		return err
+	}
+	if err := c.removeHubService(ctx, wl, kind, namespace, name); err != nil {
+		return err
+	}
+	if err := c.removeIngress(ctx, namespace, c.exposeName(name)); err != nil {
+		return err
+	}
+	if err := c.removeHTTPRoute(ctx, namespace, c.exposeName(name)); err != nil {
+		return err
+	}
+	if wl != nil {
+		return c.removeFinalizer(ctx, wl)
+	}
+	return nil
+}
+
+func (c *Controller) createService(ctx context.Context, wl *workload, desired *v1.Service, namespace, svcName string) (err error) {
+	logger := klog.FromContext(ctx).WithValues("service", svcName)
+	logger.Info("Service missing, creating")
+	if c.opts.OutputDir != "" {
+		return c.writeService(ctx, desired)
+	}
+	if c.opts.DryRun {
+		logDryRun(ctx, "create", "Service", namespace, svcName, v1.Service{}, *desired)
+		return nil
+	}
+
+	ctx, span := tracer.Start(ctx, "CreateService")
+	defer func() { endSpan(span, err) }()
+
+	_, err = c.clientset.CoreV1().Services(namespace).Create(
+		ctx,
 		desired,
 		metav1.CreateOptions{},
 	)
+	if errors.IsAlreadyExists(err) {
+		existing, getErr := c.clientset.CoreV1().Services(namespace).Get(ctx, svcName, metav1.GetOptions{})
+		if getErr != nil {
+			return fmt.Errorf("failed to get existing service %s/%s: %v", namespace, svcName, getErr)
+		}
+		if !c.ownsService(existing, wl) {
+			return fmt.Errorf("service %s/%s already exists and is not managed by %s", namespace, svcName, c.opts.ControllerName)
+		}
+		if ownedByOtherWorkload(existing, wl.kind, wl.Name) {
+			owner := workloadControllerRef(existing)
+			return fmt.Errorf("service %s/%s already belongs to %s %s", namespace, svcName, strings.ToLower(owner.Kind), owner.Name)
+		}
+		// Either the lister lagged behind a create we already made or the
+		// Service predates this controller instance; adopt it through the
+		// regular update path.
+		logger.Info("Service already exists, adopting it")
+		return c.updateService(ctx, wl, existing, desired, namespace, svcName)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create service %s/%s: %v", namespace, svcName, err)
 	}
-	klog.Infof("Service %s/%s created", namespace, svcName)
+	logger.Info("Service created")
+	c.event(wl, v1.EventTypeNormal, "ServiceCreated", "Created service %s", svcName)
 	return nil
 }
 
-func (c *Controller) updateService(svc, desired *v1.Service, namespace, svcName string) error {
-	updated := svc.DeepCopy()
-	updated.Spec.Selector = desired.Spec.Selector
-	updated.Spec.Ports = desired.Spec.Ports
+// updateService patches svc with the fields the controller manages from
+// desired, see servicePatch.
+func (c *Controller) updateService(ctx context.Context, wl *workload, svc, desired *v1.Service, namespace, svcName string) (err error) {
+	updated := c.mergeService(svc, desired)
 
-	_, err := c.clientset.CoreV1().Services(namespace).Update(
-		context.Background(),
-		updated,
-		metav1.UpdateOptions{},
-	)
+	if c.opts.OutputDir != "" {
+		return c.writeService(ctx, updated)
+	}
+	if c.opts.DryRun {
+		logDryRun(ctx, "update", "Service", namespace, svcName, *svc, *updated)
+		return nil
+	}
+
+	ctx, span := tracer.Start(ctx, "UpdateService")
+	defer func() { endSpan(span, err) }()
+
+	services := c.clientset.CoreV1().Services(namespace)
+	// A drifted selector stops the Service from routing, so it is confirmed
+	// against the live Service rather than acted on from a stale lister copy.
+	refetch := !equality.Semantic.DeepEqual(svc.Spec.Selector, desired.Spec.Selector)
+	err = retry.RetryOnConflict(conflictRetry, func() error {
+		// After a conflict the lister copy was stale; retry against the
+		// live Service.
+		if refetch {
+			live, err := services.Get(ctx, svcName, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if !c.ownsService(live, wl) {
+				return fmt.Errorf("service %s/%s is no longer managed by %s", namespace, svcName, c.opts.ControllerName)
+			}
+			if !equality.Semantic.DeepEqual(live.Spec.Selector, desired.Spec.Selector) {
+				klog.FromContext(ctx).Info("Restoring drifted Service selector", "service", svcName, "selector", live.Spec.Selector)
+			}
+			svc, updated = live, c.mergeService(live, desired)
+		}
+		refetch = true
+		patch, err := servicePatch(svc, updated)
+		if err != nil {
+			return err
+		}
+		_, err = services.Patch(ctx, svcName, types.MergePatchType, patch, metav1.PatchOptions{})
+		return err
+	})
+	if errors.IsInvalid(err) && c.opts.ImmutableChangePolicy == ImmutableChangeRecreate {
+		klog.FromContext(ctx).Info("Service update rejected, recreating it", "service", svcName, "err", err)
+		return c.recreateService(ctx, wl, desired, namespace, svcName)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to update service %s/%s: %v", namespace, svcName, err)
 	}
 
-	klog.Infof("Service %s/%s updated", namespace, svcName)
+	klog.FromContext(ctx).Info("Service updated", "service", svcName)
+	c.event(wl, v1.EventTypeNormal, "ServiceUpdated", "Updated service %s", svcName)
 	return nil
 }
 
-func (c *Controller) removeService(namespace, svcName string) error {
+// servicePatch returns a JSON merge patch turning svc into updated, so only
+// the fields that changed are sent and fields other actors set on the
+// Service survive. It carries svc's resourceVersion, so a patch computed
+// from a stale copy fails with a conflict rather than undoing a newer
+// change. A strategic merge patch would key ports by number alone and
+// merge a TCP and a UDP port sharing one.
+func servicePatch(svc, updated *v1.Service) ([]byte, error) {
+	original := svc.DeepCopy()
+	original.ResourceVersion = ""
+	originalJSON, err := json.Marshal(original)
+	if err != nil {
+		return nil, err
+	}
+	updatedJSON, err := json.Marshal(updated)
+	if err != nil {
+		return nil, err
+	}
+	return jsonpatch.CreateMergePatch(originalJSON, updatedJSON)
+}
+
+// mergeService returns a copy of svc with the fields the controller manages
+// taken from desired.
+func (c *Controller) mergeService(svc, desired *v1.Service) *v1.Service {
+	updated := svc.DeepCopy()
+	updated.Spec.Type = desired.Spec.Type
+	updated.Spec.Selector = desired.Spec.Selector
+	updated.Spec.ExternalName = desired.Spec.ExternalName
+	updated.Spec.ExternalTrafficPolicy = desired.Spec.ExternalTrafficPolicy
+	updated.Spec.InternalTrafficPolicy = desired.Spec.InternalTrafficPolicy
+	updated.Spec.PublishNotReadyAddresses = desired.Spec.PublishNotReadyAddresses
+	updated.Spec.LoadBalancerClass = desired.Spec.LoadBalancerClass
+	updated.Spec.LoadBalancerSourceRanges = desired.Spec.LoadBalancerSourceRanges
+	updated.Spec.SessionAffinity = desired.Spec.SessionAffinity
+	updated.Spec.SessionAffinityConfig = desired.Spec.SessionAffinityConfig
+	updated.Spec.Ports = desired.Spec.Ports
+	if desired.Spec.Type != v1.ServiceTypeClusterIP {
+		updated.Spec.Ports = preserveNodePorts(svc.Spec.Ports, desired.Spec.Ports)
+	}
+	applyIPFamilies(updated, desired)
+	applyOwner(updated, desired)
+	c.applyMetadata(updated, desired)
+	return updated
+}
+
+// removeManagedService deletes the Service svcName generated for the kind's
+// object name. Only a Service the controller owns is deleted, so a user's
+// Service sharing the name, or one belonging to another workload, is left
+// alone.
+func (c *Controller) removeManagedService(ctx context.Context, wl *workload, kind, namespace, name, svcName string) error {
+	if c.opts.OutputDir != "" {
+		return c.deleteServiceFile(ctx, namespace, svcName)
+	}
+
+	svc, err := c.serviceLister.Services(namespace).Get(svcName)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get service %s/%s: %v", namespace, svcName, err)
+	}
+	if ownedByOtherWorkload(svc, kind, name) {
+		return nil
+	}
+	if action, _ := c.planService(wl, svc, nil); action != serviceDelete {
+		return nil
+	}
+	c.recordPlan(workloadKey(kind, namespace+"/"+name), svc, nil, serviceDelete)
+	return c.removeService(ctx, wl, namespace, svcName)
+}
+
+func (c *Controller) removeService(ctx context.Context, wl *workload, namespace, svcName string) (err error) {
+	if c.opts.OutputDir != "" {
+		return c.deleteServiceFile(ctx, namespace, svcName)
+	}
+	if c.opts.DryRun {
+		var current v1.Service
+		if svc, err := c.serviceLister.Services(namespace).Get(svcName); err == nil {
+			current = *svc
+		}
+		logDryRun(ctx, "delete", "Service", namespace, svcName, current, v1.Service{})
+		return nil
+	}
+
+	ctx, span := tracer.Start(ctx, "DeleteService")
+	defer func() { endSpan(span, err) }()
+
 	delErr := c.clientset.CoreV1().Services(namespace).Delete(
-		context.Background(),
+		ctx,
 		svcName,
 		metav1.DeleteOptions{},
 	)
@@ -185,6 +1067,18 @@ func (c *Controller) removeService(namespace, svcName string) error {
 		return fmt.Errorf("failed to delete service %s/%s: %v", namespace, svcName, delErr)
 	}
 
-	klog.Infof("Service %s/%s deleted (if existed)", namespace, svcName)
+	klog.FromContext(ctx).Info("Service deleted (if existed)", "service", svcName)
+	if delErr == nil {
+		c.event(wl, v1.EventTypeNormal, "ServiceDeleted", "Deleted service %s", svcName)
+	}
 	return nil
 }
+
+// event records an event against wl. Events for a workload that has already
+// been deleted are dropped, since there is nothing to attach them to.
+func (c *Controller) event(wl *workload, eventType, reason, messageFmt string, args ...interface{}) {
+	if wl == nil {
+		return
+	}
+	c.recorder.Eventf(wl.object, eventType, reason, messageFmt, args...)
+}
@@ -0,0 +1,1384 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// fixture runs a Controller against a fake clientset. Its informers are
+// never started; instead the indexers are loaded from the clientset before
+// every sync, so a sync sees the objects earlier syncs wrote.
+type fixture struct {
+	t        *testing.T
+	client   *fake.Clientset
+	factory  informers.SharedInformerFactory
+	c        *Controller
+	recorder *record.FakeRecorder
+}
+
+func newFixture(t *testing.T, opts Options, objects ...runtime.Object) *fixture {
+	t.Helper()
+	client := fake.NewSimpleClientset(objects...)
+	factory := informers.NewSharedInformerFactory(client, 0)
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test")
+	c, err := NewController(client, factory, queue, opts)
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	t.Cleanup(queue.ShutDown)
+
+	recorder := record.NewFakeRecorder(100)
+	c.recorder = recorder
+	return &fixture{t: t, client: client, factory: factory, c: c, recorder: recorder}
+}
+
+// refresh replaces the contents of the indexers with the clientset's
+// objects.
+func (f *fixture) refresh() {
+	f.t.Helper()
+	ctx := context.Background()
+
+	deploys, err := f.client.AppsV1().Deployments("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		f.t.Fatalf("listing deployments: %v", err)
+	}
+	var items []interface{}
+	for i := range deploys.Items {
+		items = append(items, &deploys.Items[i])
+	}
+	f.replace(f.factory.Apps().V1().Deployments().Informer().GetIndexer().Replace(items, ""))
+
+	services, err := f.client.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		f.t.Fatalf("listing services: %v", err)
+	}
+	items = nil
+	for i := range services.Items {
+		items = append(items, &services.Items[i])
+	}
+	f.replace(f.factory.Core().V1().Services().Informer().GetIndexer().Replace(items, ""))
+
+	ingresses, err := f.client.NetworkingV1().Ingresses("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		f.t.Fatalf("listing ingresses: %v", err)
+	}
+	items = nil
+	for i := range ingresses.Items {
+		items = append(items, &ingresses.Items[i])
+	}
+	f.replace(f.factory.Networking().V1().Ingresses().Informer().GetIndexer().Replace(items, ""))
+
+	if f.c.statefulSetLister != nil {
+		sets, err := f.client.AppsV1().StatefulSets("").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			f.t.Fatalf("listing statefulsets: %v", err)
+		}
+		items = nil
+		for i := range sets.Items {
+			items = append(items, &sets.Items[i])
+		}
+		f.replace(f.factory.Apps().V1().StatefulSets().Informer().GetIndexer().Replace(items, ""))
+	}
+
+	if f.c.namespaceLister != nil {
+		namespaces, err := f.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			f.t.Fatalf("listing namespaces: %v", err)
+		}
+		items = nil
+		for i := range namespaces.Items {
+			items = append(items, &namespaces.Items[i])
+		}
+		f.replace(f.factory.Core().V1().Namespaces().Informer().GetIndexer().Replace(items, ""))
+	}
+
+	if f.c.podLister != nil {
+		pods, err := f.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			f.t.Fatalf("listing pods: %v", err)
+		}
+		items = nil
+		for i := range pods.Items {
+			items = append(items, &pods.Items[i])
+		}
+		f.replace(f.factory.Core().V1().Pods().Informer().GetIndexer().Replace(items, ""))
+	}
+}
+
+func (f *fixture) replace(err error) {
+	f.t.Helper()
+	if err != nil {
+		f.t.Fatalf("loading indexer: %v", err)
+	}
+}
+
+// sync refreshes the indexers and reconciles key.
+func (f *fixture) sync(key string) error {
+	f.t.Helper()
+	f.refresh()
+	return f.c.syncHandler(context.Background(), key)
+}
+
+// mustSync is sync failing the test on an error.
+func (f *fixture) mustSync(key string) {
+	f.t.Helper()
+	if err := f.sync(key); err != nil {
+		f.t.Fatalf("sync %s: %v", key, err)
+	}
+}
+
+// service returns the Service from the clientset, or nil if it does not
+// exist.
+func (f *fixture) service(namespace, name string) *v1.Service {
+	f.t.Helper()
+	svc, err := f.client.CoreV1().Services(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		f.t.Fatalf("getting service %s/%s: %v", namespace, name, err)
+	}
+	return svc
+}
+
+// deleteDeployment deletes the Deployment from the clientset.
+func (f *fixture) deleteDeployment(namespace, name string) {
+	f.t.Helper()
+	if err := f.client.AppsV1().Deployments(namespace).Delete(context.Background(), name, metav1.DeleteOptions{}); err != nil {
+		f.t.Fatalf("deleting deployment %s/%s: %v", namespace, name, err)
+	}
+}
+
+// getDeployment returns the Deployment default/name from the clientset.
+func (f *fixture) getDeployment(name string) *appsv1.Deployment {
+	f.t.Helper()
+	deploy, err := f.client.AppsV1().Deployments("default").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		f.t.Fatalf("getting deployment %s: %v", name, err)
+	}
+	return deploy
+}
+
+// updateDeployment writes deploy to the clientset.
+func (f *fixture) updateDeployment(deploy *appsv1.Deployment) {
+	f.t.Helper()
+	if _, err := f.client.AppsV1().Deployments(deploy.Namespace).Update(context.Background(), deploy, metav1.UpdateOptions{}); err != nil {
+		f.t.Fatalf("updating deployment %s: %v", deploy.Name, err)
+	}
+}
+
+// newDeployment returns an exposed Deployment with one container declaring
+// the given ports.
+func newDeployment(name string, ports ...v1.ContainerPort) *appsv1.Deployment {
+	labels := map[string]string{"app": name}
+	return &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: deploymentKind},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			UID:         types.UID("uid-" + name),
+			Annotations: map[string]string{enabledAnnotation: "true"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{Name: "app", Image: "app", Ports: ports}},
+				},
+			},
+		},
+	}
+}
+
+func TestNewController(t *testing.T) {
+	f := newFixture(t, Options{WatchStatefulSets: true})
+
+	if f.c.deployLister == nil {
+		t.Error("deployment lister is nil")
+	}
+	if f.c.serviceLister == nil {
+		t.Error("service lister is nil")
+	}
+	if f.c.ingressLister == nil {
+		t.Error("ingress lister is nil")
+	}
+	if f.c.statefulSetLister == nil {
+		t.Error("statefulset lister is nil with WatchStatefulSets")
+	}
+	if f.c.StopCh == nil {
+		t.Error("StopCh is nil")
+	}
+	if f.c.opts.ServiceSuffix != DefaultServiceSuffix || f.c.opts.ControllerName != DefaultControllerName {
+		t.Errorf("defaults not applied: suffix %q, controller name %q", f.c.opts.ServiceSuffix, f.c.opts.ControllerName)
+	}
+}
+
+func TestNewControllerRejectsInvalidSuffix(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(client, 0)
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test")
+	defer queue.ShutDown()
+
+	if _, err := NewController(client, factory, queue, Options{ServiceSuffix: internalSuffix}); err == nil {
+		t.Error("NewController accepted the reserved internal suffix")
+	}
+}
+
+func TestSyncCreatesService(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{Name: "http", ContainerPort: 8080})
+	f := newFixture(t, Options{}, deploy)
+
+	f.mustSync("default/web")
+
+	svc := f.service("default", "web-expose")
+	if svc == nil {
+		t.Fatal("service web-expose was not created")
+	}
+	if svc.Spec.Type != v1.ServiceTypeNodePort {
+		t.Errorf("type = %s, want NodePort", svc.Spec.Type)
+	}
+	if got := svc.Spec.Selector["app"]; got != "web" {
+		t.Errorf("selector app = %q, want web", got)
+	}
+	if !f.c.isManagedService(svc) {
+		t.Error("service does not carry the controller label")
+	}
+}
+
+func TestServiceOwnerReference(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        Options
+		annotations map[string]string
+		wantOwner   bool
+	}{
+		{name: "default", wantOwner: true},
+		{name: "retain on delete", annotations: map[string]string{retainOnDeleteAnnotation: "true"}},
+		{name: "orphan delete delay", opts: Options{OrphanDeleteDelay: time.Minute}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+			for k, v := range tt.annotations {
+				deploy.Annotations[k] = v
+			}
+			f := newFixture(t, tt.opts, deploy)
+			f.mustSync("default/web")
+
+			svc := f.service("default", "web-expose")
+			if svc == nil {
+				t.Fatal("service was not created")
+			}
+			if !tt.wantOwner {
+				if len(svc.OwnerReferences) != 0 {
+					t.Errorf("owner references = %v, want none", svc.OwnerReferences)
+				}
+				return
+			}
+			if len(svc.OwnerReferences) != 1 {
+				t.Fatalf("owner references = %v, want exactly one", svc.OwnerReferences)
+			}
+			ref := svc.OwnerReferences[0]
+			if ref.UID != deploy.UID || ref.Kind != deploymentKind || ref.Name != deploy.Name {
+				t.Errorf("owner reference = %+v, want Deployment web with UID %s", ref, deploy.UID)
+			}
+			if ref.Controller == nil || !*ref.Controller || ref.BlockOwnerDeletion == nil || !*ref.BlockOwnerDeletion {
+				t.Errorf("owner reference %+v is not a blocking controller reference", ref)
+			}
+		})
+	}
+}
+
+// immediateRetries makes the controller's retries skip their backoff, so a
+// requeued key is ready again at once.
+func (f *fixture) immediateRetries() {
+	f.c.queue = newTrackedQueue(workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(0, 0)))
+	f.t.Cleanup(f.c.queue.ShutDown)
+}
+
+func TestProcessItemForgetsOnSuccess(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.immediateRetries()
+	f.refresh()
+
+	f.c.queue.AddRateLimited("default/web")
+	if !f.c.processItem(context.Background()) {
+		t.Fatal("processItem reported a shut down queue")
+	}
+	if n := f.c.queue.NumRequeues("default/web"); n != 0 {
+		t.Errorf("requeues after a successful sync = %d, want 0", n)
+	}
+	if n := f.c.queue.Len(); n != 0 {
+		t.Errorf("queue length after a successful sync = %d, want 0", n)
+	}
+}
+
+func TestProcessItemGivesUpAfterMaxRetries(t *testing.T) {
+	f := newFixture(t, Options{MaxRetries: 3})
+	f.immediateRetries()
+
+	// A key that cannot be split fails every sync.
+	const key = "a/b/c"
+	f.c.queue.Add(key)
+	syncs := 0
+	for f.c.queue.Len() > 0 {
+		if syncs++; syncs > 10 {
+			t.Fatal("key is still requeued after 10 syncs")
+		}
+		f.c.processItem(context.Background())
+	}
+
+	if want := 1 + f.c.opts.MaxRetries; syncs != want {
+		t.Errorf("syncs = %d, want %d", syncs, want)
+	}
+	if n := f.c.queue.NumRequeues(key); n != 0 {
+		t.Errorf("requeues after giving up = %d, want 0", n)
+	}
+}
+
+func TestUpdateServiceRetriesConflict(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	deploy.Spec.Template.Spec.Containers[0].Ports[0].ContainerPort = 9090
+	if _, err := f.client.AppsV1().Deployments("default").Update(context.Background(), deploy, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating deployment: %v", err)
+	}
+	conflicts := 0
+	f.client.PrependReactor("patch", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			return false, nil, nil
+		}
+		conflicts++
+		return true, nil, errors.NewConflict(v1.Resource("services"), "web-expose", fmt.Errorf("the object has been modified"))
+	})
+	f.client.ClearActions()
+	f.mustSync("default/web")
+
+	var verbs []string
+	for _, action := range f.client.Actions() {
+		if action.GetResource().Resource == "services" && action.GetVerb() != "list" {
+			verbs = append(verbs, action.GetVerb())
+		}
+	}
+	if want := []string{"patch", "get", "patch"}; !slices.Equal(verbs, want) {
+		t.Errorf("service actions = %v, want %v", verbs, want)
+	}
+	if svc := f.service("default", "web-expose"); len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Port != 9090 {
+		t.Errorf("ports after the retried update = %+v, want 9090", svc.Spec.Ports)
+	}
+}
+
+// serviceActions lists the verbs of the Service calls made since the actions
+// were last cleared, leaving out the lists of refresh.
+func (f *fixture) serviceActions() []string {
+	var verbs []string
+	for _, action := range f.client.Actions() {
+		if action.GetResource().Resource == "services" && action.GetVerb() != "list" {
+			verbs = append(verbs, action.GetVerb())
+		}
+	}
+	return verbs
+}
+
+func TestPostCreateRequeue(t *testing.T) {
+	f := newFixture(t, Options{PostCreateRequeue: time.Millisecond}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") == nil {
+		t.Fatal("service was not created")
+	}
+
+	key, _ := f.c.queue.Get()
+	if key != "default/web" {
+		t.Fatalf("requeued key = %v, want default/web", key)
+	}
+	f.c.queue.Done(key)
+
+	f.client.ClearActions()
+	f.mustSync("default/web")
+	if verbs := f.serviceActions(); len(verbs) != 0 {
+		t.Errorf("service actions of the requeued sync = %v, want none", verbs)
+	}
+	if n := f.c.queue.Len(); n != 0 {
+		t.Errorf("queue length after the requeued sync = %d, want 0", n)
+	}
+}
+
+// events drains the events recorded so far, each formatted as
+// "<type> <reason> <message>".
+func (f *fixture) events() []string {
+	var out []string
+	for {
+		select {
+		case e := <-f.recorder.Events:
+			out = append(out, e)
+		default:
+			return out
+		}
+	}
+}
+
+// hasEvent reports whether one of events has the type and reason.
+func hasEvent(events []string, eventType, reason string) bool {
+	return slices.ContainsFunc(events, func(e string) bool {
+		return strings.HasPrefix(e, eventType+" "+reason+" ")
+	})
+}
+
+func TestDualPublishMode(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Annotations[dualAnnotation] = "true"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	exposed, internal := f.service("default", "web-expose"), f.service("default", "web-internal")
+	if exposed == nil || internal == nil {
+		t.Fatalf("services = %v, %v, want both", exposed, internal)
+	}
+	if exposed.Spec.Type != v1.ServiceTypeNodePort || internal.Spec.Type != v1.ServiceTypeClusterIP {
+		t.Errorf("types = %s, %s, want NodePort, ClusterIP", exposed.Spec.Type, internal.Spec.Type)
+	}
+	if !equality.Semantic.DeepEqual(internal.Spec.Selector, exposed.Spec.Selector) || !equality.Semantic.DeepEqual(internal.Spec.Ports, exposed.Spec.Ports) {
+		t.Errorf("internal service %+v does not match the exposed one %+v", internal.Spec, exposed.Spec)
+	}
+
+	deploy = f.getDeployment("web")
+	delete(deploy.Annotations, dualAnnotation)
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if f.service("default", "web-internal") != nil {
+		t.Error("internal service outlived the dual annotation")
+	}
+	if f.service("default", "web-expose") == nil {
+		t.Error("exposed service was removed with the dual annotation")
+	}
+}
+
+func TestEnabledAnnotation(t *testing.T) {
+	tests := []struct {
+		name string
+		// enabled is the annotation value, empty to leave it out.
+		enabled string
+		want    bool
+	}{
+		{name: "true", enabled: "true", want: true},
+		{name: "absent"},
+		{name: "false", enabled: "false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+			delete(deploy.Annotations, enabledAnnotation)
+			if tt.enabled != "" {
+				deploy.Annotations[enabledAnnotation] = tt.enabled
+			}
+			f := newFixture(t, Options{}, deploy)
+			f.mustSync("default/web")
+
+			if got := f.service("default", "web-expose") != nil; got != tt.want {
+				t.Errorf("service exists = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisablingRemovesService(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") == nil {
+		t.Fatal("service was not created")
+	}
+
+	deploy := f.getDeployment("web")
+	deploy.Annotations[enabledAnnotation] = "false"
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") != nil {
+		t.Error("service outlived the enabled annotation turning false")
+	}
+}
+
+func TestDisabledLeavesUnmanagedService(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Annotations[enabledAnnotation] = "false"
+	user := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web-expose", Namespace: "default"}}
+	f := newFixture(t, Options{}, deploy, user)
+	f.mustSync("default/web")
+
+	if f.service("default", "web-expose") == nil {
+		t.Error("a service the controller does not manage was deleted")
+	}
+}
+
+func TestServiceTypeAnnotation(t *testing.T) {
+	tests := []struct {
+		value string
+		want  v1.ServiceType
+	}{
+		{value: "ClusterIP", want: v1.ServiceTypeClusterIP},
+		{value: "NodePort", want: v1.ServiceTypeNodePort},
+		{value: "LoadBalancer", want: v1.ServiceTypeLoadBalancer},
+		{value: "ExternalName", want: v1.ServiceTypeNodePort},
+		{value: "", want: v1.ServiceTypeNodePort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+			if tt.value != "" {
+				deploy.Annotations[serviceTypeAnnotation] = tt.value
+			}
+			f := newFixture(t, Options{}, deploy)
+			f.mustSync("default/web")
+
+			if got := f.service("default", "web-expose").Spec.Type; got != tt.want {
+				t.Errorf("type = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceTypeChangeUpdatesService(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+
+	deploy := f.getDeployment("web")
+	deploy.Annotations[serviceTypeAnnotation] = "ClusterIP"
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if got := f.service("default", "web-expose").Spec.Type; got != v1.ServiceTypeClusterIP {
+		t.Errorf("type after changing the annotation = %s, want ClusterIP", got)
+	}
+}
+
+func TestServiceEvents(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+
+	f.mustSync("default/web")
+	if events := f.events(); !hasEvent(events, v1.EventTypeNormal, "ServiceCreated") {
+		t.Errorf("events after creating = %q, want ServiceCreated", events)
+	}
+
+	deploy := f.getDeployment("web")
+	deploy.Spec.Template.Spec.Containers[0].Ports[0].ContainerPort = 9090
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if events := f.events(); !hasEvent(events, v1.EventTypeNormal, "ServiceUpdated") {
+		t.Errorf("events after updating = %q, want ServiceUpdated", events)
+	}
+
+	deploy = f.getDeployment("web")
+	deploy.Annotations[enabledAnnotation] = "false"
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if events := f.events(); !hasEvent(events, v1.EventTypeNormal, "ServiceDeleted") {
+		t.Errorf("events after deleting = %q, want ServiceDeleted", events)
+	}
+}
+
+func TestReconcileFailedEvent(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.immediateRetries()
+	f.refresh()
+	f.client.PrependReactor("create", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("create failed")
+	})
+
+	f.c.queue.Add("default/web")
+	f.c.processItem(context.Background())
+	if events := f.events(); !hasEvent(events, v1.EventTypeWarning, "ReconcileFailed") {
+		t.Errorf("events = %q, want ReconcileFailed", events)
+	}
+}
+
+// blockingController returns a controller for the Deployment default/web
+// whose Service create never gets an answer before the test ends. The fake
+// clientset ignores contexts, so the create goes to a real server instead.
+// The returned channel is closed once the create was sent.
+func blockingController(t *testing.T, opts Options) (*Controller, <-chan struct{}) {
+	t.Helper()
+	created, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/services") {
+			once.Do(func() { close(created) })
+			<-release
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("{}"))
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatalf("creating clientset: %v", err)
+	}
+	factory := informers.NewSharedInformerFactory(client, 0)
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test")
+	t.Cleanup(queue.ShutDown)
+	c, err := NewController(client, factory, queue, opts)
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	c.recorder = record.NewFakeRecorder(100)
+	if err := factory.Apps().V1().Deployments().Informer().GetIndexer().Add(newDeployment("web", v1.ContainerPort{ContainerPort: 8080})); err != nil {
+		t.Fatalf("loading indexer: %v", err)
+	}
+	return c, created
+}
+
+func TestProcessItemCancelled(t *testing.T) {
+	c, created := blockingController(t, Options{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	c.queue.Add("default/web")
+	go func() {
+		defer close(done)
+		c.processItem(ctx)
+	}()
+
+	<-created
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("processItem did not return after its context was cancelled")
+	}
+	if n := c.queue.NumRequeues("default/web"); n != 1 {
+		t.Errorf("requeues of the cancelled reconcile = %d, want 1", n)
+	}
+}
+
+func TestReconcileTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	c, _ := blockingController(t, Options{ReconcileTimeout: timeout})
+
+	start := time.Now()
+	done := make(chan struct{})
+	c.queue.Add("default/web")
+	go func() {
+		defer close(done)
+		c.processItem(context.Background())
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("processItem did not return after the reconcile timeout")
+	}
+	if elapsed := time.Since(start); elapsed < timeout {
+		t.Errorf("processItem returned after %v, before the %v timeout", elapsed, timeout)
+	}
+	if n := c.queue.NumRequeues("default/web"); n != 1 {
+		t.Errorf("requeues of the timed out reconcile = %d, want 1", n)
+	}
+}
+
+func TestCreateAdoptsExistingService(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		adopted bool
+	}{
+		{name: "managed", labels: map[string]string{controllerLabel: DefaultControllerName}, adopted: true},
+		{name: "user owned", labels: map[string]string{"team": "payments"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+			f.refresh()
+			// Created after the lister was loaded, so the create conflicts.
+			existing := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web-expose", Namespace: "default", Labels: tt.labels},
+				Spec:       v1.ServiceSpec{Type: v1.ServiceTypeClusterIP, Ports: []v1.ServicePort{{Port: 80}}},
+			}
+			if _, err := f.client.CoreV1().Services("default").Create(context.Background(), existing, metav1.CreateOptions{}); err != nil {
+				t.Fatalf("creating service: %v", err)
+			}
+
+			err := f.c.syncHandler(context.Background(), "default/web")
+			svc := f.service("default", "web-expose")
+			if !tt.adopted {
+				if err == nil || !strings.Contains(err.Error(), "not managed by") {
+					t.Errorf("error = %v, want a refusal to adopt", err)
+				}
+				if svc.Spec.Type != v1.ServiceTypeClusterIP || len(svc.Spec.Selector) != 0 {
+					t.Errorf("user's service was modified: %+v", svc.Spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("sync: %v", err)
+			}
+			if svc.Spec.Type != v1.ServiceTypeNodePort || svc.Spec.Selector["app"] != "web" || svc.Spec.Ports[0].Port != 8080 {
+				t.Errorf("adopted service was not updated: %+v", svc.Spec)
+			}
+		})
+	}
+}
+
+func TestRunReturnsOnCancel(t *testing.T) {
+	f := newFixture(t, Options{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.c.Run(ctx, 2)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after its context was cancelled")
+	}
+	if !f.c.queue.ShuttingDown() {
+		t.Error("queue was not shut down")
+	}
+}
+
+func TestRunDrainsQueueOnShutdown(t *testing.T) {
+	names := []string{"api", "web", "worker"}
+	var objects []runtime.Object
+	for _, name := range names {
+		objects = append(objects, newDeployment(name, v1.ContainerPort{ContainerPort: 8080}))
+	}
+	f := newFixture(t, Options{}, objects...)
+	f.refresh()
+	for _, name := range names {
+		f.c.queue.Add("default/" + name)
+	}
+
+	// Cancelled before the workers start, so every key is processed by the
+	// drain.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f.c.Run(ctx, 1)
+
+	for _, name := range names {
+		if f.service("default", name+"-expose") == nil {
+			t.Errorf("key default/%s was dropped on shutdown", name)
+		}
+	}
+}
+
+func TestSelector(t *testing.T) {
+	selector, err := labels.Parse("tier=frontend")
+	if err != nil {
+		t.Fatalf("parsing selector: %v", err)
+	}
+	matching := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	matching.Labels = map[string]string{"tier": "frontend"}
+	other := newDeployment("db", v1.ContainerPort{ContainerPort: 5432})
+	other.Labels = map[string]string{"tier": "backend"}
+	f := newFixture(t, Options{Selector: selector}, matching, other)
+
+	f.mustSync("default/web")
+	f.mustSync("default/db")
+	if f.service("default", "web-expose") == nil {
+		t.Error("service of the matching deployment was not created")
+	}
+	if f.service("default", "db-expose") != nil {
+		t.Error("service of the non-matching deployment was created")
+	}
+
+	deploy := f.getDeployment("web")
+	deploy.Labels["tier"] = "backend"
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") != nil {
+		t.Error("service outlived its deployment leaving the selector")
+	}
+}
+
+func TestUserServiceLeftUntouched(t *testing.T) {
+	user := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-expose", Namespace: "default", Labels: map[string]string{"team": "payments"}},
+		Spec:       v1.ServiceSpec{Type: v1.ServiceTypeClusterIP, Ports: []v1.ServicePort{{Port: 80}}},
+	}
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}), user)
+
+	f.client.ClearActions()
+	f.mustSync("default/web")
+	if verbs := f.serviceActions(); len(verbs) != 0 {
+		t.Errorf("service actions = %v, want the user's service left alone", verbs)
+	}
+	if events := f.events(); !hasEvent(events, v1.EventTypeWarning, "ServiceConflict") {
+		t.Errorf("events = %q, want ServiceConflict", events)
+	}
+
+	f.deleteDeployment("default", "web")
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") == nil {
+		t.Error("user's service was deleted with the deployment")
+	}
+}
+
+func TestSessionAffinity(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Annotations[sessionAffinityAnnotation] = "ClientIP"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	svc := f.service("default", "web-expose")
+	if svc.Spec.SessionAffinity != v1.ServiceAffinityClientIP {
+		t.Errorf("session affinity = %s, want ClientIP", svc.Spec.SessionAffinity)
+	}
+	if svc.Spec.SessionAffinityConfig != nil {
+		t.Errorf("session affinity config = %+v, want the API default", svc.Spec.SessionAffinityConfig)
+	}
+
+	deploy = f.getDeployment("web")
+	deploy.Annotations[sessionAffinityTimeoutAnnotation] = "600"
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	cfg := f.service("default", "web-expose").Spec.SessionAffinityConfig
+	if cfg == nil || cfg.ClientIP == nil || cfg.ClientIP.TimeoutSeconds == nil || *cfg.ClientIP.TimeoutSeconds != 600 {
+		t.Errorf("session affinity config = %+v, want a 600s timeout", cfg)
+	}
+
+	deploy = f.getDeployment("web")
+	deploy.Annotations[sessionAffinityTimeoutAnnotation] = "60"
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if got := *f.service("default", "web-expose").Spec.SessionAffinityConfig.ClientIP.TimeoutSeconds; got != 60 {
+		t.Errorf("timeout after changing the annotation = %d, want 60", got)
+	}
+
+	deploy = f.getDeployment("web")
+	delete(deploy.Annotations, sessionAffinityAnnotation)
+	delete(deploy.Annotations, sessionAffinityTimeoutAnnotation)
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	svc = f.service("default", "web-expose")
+	if svc.Spec.SessionAffinity != v1.ServiceAffinityNone || svc.Spec.SessionAffinityConfig != nil {
+		t.Errorf("session affinity = %s %+v after removing the annotations, want None", svc.Spec.SessionAffinity, svc.Spec.SessionAffinityConfig)
+	}
+}
+
+func TestWorkersProcessEveryKey(t *testing.T) {
+	var objects []runtime.Object
+	for i := range 20 {
+		objects = append(objects, newDeployment(fmt.Sprintf("app-%d", i), v1.ContainerPort{ContainerPort: 8080}))
+	}
+	f := newFixture(t, Options{}, objects...)
+	f.refresh()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.c.Run(ctx, 4)
+	}()
+	f.c.EnqueueAll()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		services, err := f.client.CoreV1().Services("default").List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("listing services: %v", err)
+		}
+		if len(services.Items) == 20 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("services = %d after 5s, want 20", len(services.Items))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+
+func TestWorkerExitsOnShutdown(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.refresh()
+	f.c.queue.Add("default/web")
+	f.c.queue.ShutDown()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.c.worker(context.Background())
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker kept running after the queue shut down")
+	}
+	// The key queued before the shutdown was still processed, and one
+	// added after it is not.
+	if f.service("default", "web-expose") == nil {
+		t.Error("key queued before the shutdown was dropped")
+	}
+	f.c.queue.Add("default/web")
+	if n := f.c.queue.Len(); n != 0 {
+		t.Errorf("queue length after shutdown = %d, want adds ignored", n)
+	}
+}
+
+func TestInvalidSelector(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*appsv1.Deployment)
+	}{
+		{name: "empty pod labels", modify: func(d *appsv1.Deployment) { d.Spec.Template.Labels = nil }},
+		{name: "mismatched selector", modify: func(d *appsv1.Deployment) {
+			d.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}}
+		}},
+		{name: "empty selector", modify: func(d *appsv1.Deployment) { d.Spec.Selector = &metav1.LabelSelector{} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+			tt.modify(deploy)
+			f := newFixture(t, Options{}, deploy)
+			f.mustSync("default/web")
+
+			if f.service("default", "web-expose") != nil {
+				t.Error("service was created for a deployment it cannot select")
+			}
+			if events := f.events(); !hasEvent(events, v1.EventTypeWarning, "InvalidSelector") {
+				t.Errorf("events = %q, want InvalidSelector", events)
+			}
+		})
+	}
+}
+
+func TestExternalTrafficPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		serviceType string
+		policy      string
+		want        v1.ServiceExternalTrafficPolicy
+	}{
+		{name: "local", policy: "Local", want: v1.ServiceExternalTrafficPolicyLocal},
+		{name: "cluster", policy: "Cluster", want: v1.ServiceExternalTrafficPolicyCluster},
+		{name: "load balancer", serviceType: "LoadBalancer", policy: "Local", want: v1.ServiceExternalTrafficPolicyLocal},
+		{name: "ignored for cluster ip", serviceType: "ClusterIP", policy: "Local"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+			deploy.Annotations[externalTrafficPolicyAnnotation] = tt.policy
+			if tt.serviceType != "" {
+				deploy.Annotations[serviceTypeAnnotation] = tt.serviceType
+			}
+			f := newFixture(t, Options{}, deploy)
+			f.mustSync("default/web")
+
+			if got := f.service("default", "web-expose").Spec.ExternalTrafficPolicy; got != tt.want {
+				t.Errorf("external traffic policy = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExternalTrafficPolicyChangeUpdatesService(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+
+	deploy := f.getDeployment("web")
+	deploy.Annotations[externalTrafficPolicyAnnotation] = "Local"
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if got := f.service("default", "web-expose").Spec.ExternalTrafficPolicy; got != v1.ServiceExternalTrafficPolicyLocal {
+		t.Errorf("external traffic policy after changing the annotation = %q, want Local", got)
+	}
+}
+
+func TestRemoveWhenScaledToZero(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Annotations[removeWhenScaledToZeroAnnotation] = "true"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") == nil {
+		t.Fatal("service was not created")
+	}
+
+	scale := func(replicas int32) {
+		t.Helper()
+		old := f.getDeployment("web")
+		scaled := old.DeepCopy()
+		scaled.ResourceVersion = old.ResourceVersion + "1"
+		scaled.Spec.Replicas = &replicas
+		f.updateDeployment(scaled)
+		// Scaling must reach the queue through the update handler.
+		f.c.deploymentHandlers().OnUpdate(old, scaled)
+		if f.c.queue.Len() != 1 {
+			t.Fatalf("scaling to %d replicas did not enqueue the deployment", replicas)
+		}
+		key, _ := f.c.queue.Get()
+		f.c.queue.Done(key)
+		f.mustSync("default/web")
+	}
+
+	scale(0)
+	if f.service("default", "web-expose") != nil {
+		t.Fatal("service of a deployment scaled to zero was kept")
+	}
+
+	scale(2)
+	if f.service("default", "web-expose") == nil {
+		t.Error("service was not recreated after scaling up")
+	}
+}
+
+func TestScaledToZeroKeepsServiceByDefault(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+
+	deploy := f.getDeployment("web")
+	deploy.Spec.Replicas = new(int32)
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") == nil {
+		t.Error("service was removed without the annotation")
+	}
+}
+
+func TestInternalTrafficPolicy(t *testing.T) {
+	local, cluster := v1.ServiceInternalTrafficPolicyLocal, v1.ServiceInternalTrafficPolicyCluster
+	tests := []struct {
+		name        string
+		policy      string
+		want        *v1.ServiceInternalTrafficPolicy
+		wantWarning bool
+	}{
+		{name: "local", policy: "Local", want: &local},
+		{name: "cluster", policy: "Cluster", want: &cluster},
+		{name: "invalid", policy: "Node", wantWarning: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+			deploy.Annotations[internalTrafficPolicyAnnotation] = tt.policy
+			f := newFixture(t, Options{}, deploy)
+			f.mustSync("default/web")
+
+			svc := f.service("default", "web-expose")
+			if svc == nil {
+				t.Fatal("service was not created")
+			}
+			if got := svc.Spec.InternalTrafficPolicy; !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("internal traffic policy = %v, want %v", got, tt.want)
+			}
+			if got := hasEvent(f.events(), v1.EventTypeWarning, "InvalidAnnotation"); got != tt.wantWarning {
+				t.Errorf("InvalidAnnotation event = %v, want %v", got, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestInternalTrafficPolicyChangeUpdatesService(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+
+	deploy := f.getDeployment("web")
+	deploy.Annotations[internalTrafficPolicyAnnotation] = "Local"
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if got := f.service("default", "web-expose").Spec.InternalTrafficPolicy; got == nil || *got != v1.ServiceInternalTrafficPolicyLocal {
+		t.Errorf("internal traffic policy after changing the annotation = %v, want Local", got)
+	}
+}
+
+func TestImmutableChangePolicy(t *testing.T) {
+	tests := []struct {
+		policy      ImmutableChangePolicy
+		wantErr     bool
+		wantActions []string
+	}{
+		{policy: ImmutableChangeUpdate, wantErr: true, wantActions: []string{"patch"}},
+		{policy: ImmutableChangeRecreate, wantActions: []string{"patch", "delete", "create"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			f := newFixture(t, Options{ImmutableChangePolicy: tt.policy}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+			f.mustSync("default/web")
+
+			deploy := f.getDeployment("web")
+			deploy.Spec.Template.Spec.Containers[0].Ports[0].ContainerPort = 9090
+			f.updateDeployment(deploy)
+			f.client.PrependReactor("patch", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.NewInvalid(v1.SchemeGroupVersion.WithKind("Service").GroupKind(), "web-expose", nil)
+			})
+			f.client.ClearActions()
+			f.events()
+
+			err := f.sync("default/web")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sync error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := f.serviceActions(); !slices.Equal(got, tt.wantActions) {
+				t.Errorf("service actions = %v, want %v", got, tt.wantActions)
+			}
+			if tt.wantErr {
+				return
+			}
+			if svc := f.service("default", "web-expose"); svc == nil || len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Port != 9090 {
+				t.Errorf("recreated service = %+v, want one with port 9090", svc)
+			}
+			if !hasEvent(f.events(), v1.EventTypeNormal, "ServiceRecreated") {
+				t.Error("no ServiceRecreated event")
+			}
+		})
+	}
+}
+
+func TestParseImmutableChangePolicy(t *testing.T) {
+	for _, s := range []string{"update", "recreate"} {
+		if got, err := ParseImmutableChangePolicy(s); err != nil || string(got) != s {
+			t.Errorf("ParseImmutableChangePolicy(%q) = %q, %v", s, got, err)
+		}
+	}
+	if _, err := ParseImmutableChangePolicy("replace"); err == nil {
+		t.Error("ParseImmutableChangePolicy accepted an unknown policy")
+	}
+}
+
+func TestServiceSelectorFromDeploymentSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector *metav1.LabelSelector
+		want     map[string]string
+	}{
+		{
+			name:     "match labels",
+			selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			want:     map[string]string{"app": "web"},
+		},
+		{
+			name: "only match expressions",
+			selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"web"}},
+			}},
+			want: map[string]string{"app": "web", "version": "v1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+			deploy.Spec.Selector = tt.selector
+			deploy.Spec.Template.Labels = map[string]string{"app": "web", "version": "v1"}
+			f := newFixture(t, Options{}, deploy)
+			f.mustSync("default/web")
+
+			svc := f.service("default", "web-expose")
+			if svc == nil {
+				t.Fatal("service was not created")
+			}
+			if !maps.Equal(svc.Spec.Selector, tt.want) {
+				t.Errorf("service selector = %v, want %v", svc.Spec.Selector, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadBalancerAnnotations(t *testing.T) {
+	annotations := map[string]string{
+		loadBalancerClassAnnotation:        "example.com/internal",
+		loadBalancerSourceRangesAnnotation: "10.0.0.0/8,192.168.0.0/16",
+	}
+	tests := []struct {
+		serviceType string
+		wantClass   string
+		wantRanges  []string
+	}{
+		{serviceType: "LoadBalancer", wantClass: "example.com/internal", wantRanges: []string{"10.0.0.0/8", "192.168.0.0/16"}},
+		{serviceType: "NodePort"},
+		{serviceType: "ClusterIP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serviceType, func(t *testing.T) {
+			deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+			maps.Copy(deploy.Annotations, annotations)
+			deploy.Annotations[serviceTypeAnnotation] = tt.serviceType
+			f := newFixture(t, Options{}, deploy)
+			f.mustSync("default/web")
+
+			svc := f.service("default", "web-expose")
+			if svc == nil {
+				t.Fatal("service was not created")
+			}
+			var class string
+			if svc.Spec.LoadBalancerClass != nil {
+				class = *svc.Spec.LoadBalancerClass
+			}
+			if class != tt.wantClass {
+				t.Errorf("load balancer class = %q, want %q", class, tt.wantClass)
+			}
+			if !slices.Equal(svc.Spec.LoadBalancerSourceRanges, tt.wantRanges) {
+				t.Errorf("source ranges = %v, want %v", svc.Spec.LoadBalancerSourceRanges, tt.wantRanges)
+			}
+		})
+	}
+}
+
+func TestLoadBalancerSourceRangesChangeUpdatesService(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Annotations[serviceTypeAnnotation] = "LoadBalancer"
+	deploy.Annotations[loadBalancerSourceRangesAnnotation] = "10.0.0.0/8"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	deploy = f.getDeployment("web")
+	deploy.Annotations[loadBalancerSourceRangesAnnotation] = "10.0.0.0/8,172.16.0.0/12"
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if got, want := f.service("default", "web-expose").Spec.LoadBalancerSourceRanges, []string{"10.0.0.0/8", "172.16.0.0/12"}; !slices.Equal(got, want) {
+		t.Errorf("source ranges after changing the annotation = %v, want %v", got, want)
+	}
+}
+
+func TestUpdatePreservesFieldsOfOtherControllers(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+
+	// Another controller fills in fields this one does not own.
+	svc := f.service("default", "web-expose")
+	svc.Annotations["lb.example.com/id"] = "lb-1234"
+	svc.Spec.ExternalIPs = []string{"203.0.113.10"}
+	if _, err := f.client.CoreV1().Services("default").Update(context.Background(), svc, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating service: %v", err)
+	}
+
+	deploy := f.getDeployment("web")
+	deploy.Spec.Template.Spec.Containers[0].Ports[0].ContainerPort = 9090
+	f.updateDeployment(deploy)
+	f.client.ClearActions()
+	f.mustSync("default/web")
+
+	var patches []string
+	for _, action := range f.client.Actions() {
+		if patch, ok := action.(k8stesting.PatchAction); ok && action.GetResource().Resource == "services" {
+			patches = append(patches, string(patch.GetPatch()))
+		}
+	}
+	if len(patches) != 1 {
+		t.Fatalf("service patches = %q, want one", patches)
+	}
+	for _, field := range []string{"externalIPs", "lb.example.com/id"} {
+		if strings.Contains(patches[0], field) {
+			t.Errorf("patch %s sends %s", patches[0], field)
+		}
+	}
+
+	svc = f.service("default", "web-expose")
+	if got := portNumbers(svc.Spec.Ports); !slices.Equal(got, []int32{9090}) {
+		t.Errorf("ports = %v, want [9090]", got)
+	}
+	if got := svc.Annotations["lb.example.com/id"]; got != "lb-1234" {
+		t.Errorf("annotation of the other controller = %q, want lb-1234", got)
+	}
+	if !slices.Equal(svc.Spec.ExternalIPs, []string{"203.0.113.10"}) {
+		t.Errorf("external IPs = %v, want the other controller's", svc.Spec.ExternalIPs)
+	}
+}
+
+func TestDefaultServiceType(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		want       v1.ServiceType
+	}{
+		{name: "default applies", want: v1.ServiceTypeClusterIP},
+		{name: "annotation overrides", annotation: "LoadBalancer", want: v1.ServiceTypeLoadBalancer},
+		{name: "invalid annotation falls back", annotation: "ExternalName", want: v1.ServiceTypeClusterIP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+			if tt.annotation != "" {
+				deploy.Annotations[serviceTypeAnnotation] = tt.annotation
+			}
+			f := newFixture(t, Options{DefaultServiceType: v1.ServiceTypeClusterIP}, deploy)
+			f.mustSync("default/web")
+
+			if got := f.service("default", "web-expose").Spec.Type; got != tt.want {
+				t.Errorf("type = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseServiceType(t *testing.T) {
+	for _, s := range []string{"ClusterIP", "NodePort", "LoadBalancer"} {
+		if got, err := ParseServiceType(s); err != nil || string(got) != s {
+			t.Errorf("ParseServiceType(%q) = %q, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"", "ExternalName", "clusterip"} {
+		if _, err := ParseServiceType(s); err == nil {
+			t.Errorf("ParseServiceType(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestPublishNotReadyAddresses(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Annotations[publishNotReadyAddressesAnnotation] = "true"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+	if !f.service("default", "web-expose").Spec.PublishNotReadyAddresses {
+		t.Fatal("not ready addresses are not published with the annotation")
+	}
+
+	deploy = f.getDeployment("web")
+	delete(deploy.Annotations, publishNotReadyAddressesAnnotation)
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if f.service("default", "web-expose").Spec.PublishNotReadyAddresses {
+		t.Error("not ready addresses are still published after removing the annotation")
+	}
+}
+
+func TestEmptiedSelectorRestored(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+
+	svc := f.service("default", "web-expose")
+	want := svc.Spec.Selector
+	svc.Spec.Selector = nil
+	if _, err := f.client.CoreV1().Services("default").Update(context.Background(), svc, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("emptying selector: %v", err)
+	}
+	f.client.ClearActions()
+	f.mustSync("default/web")
+
+	if got, want := f.serviceActions(), []string{"get", "patch"}; !slices.Equal(got, want) {
+		t.Errorf("service actions = %v, want the live service fetched before the patch %v", got, want)
+	}
+	if got := f.service("default", "web-expose").Spec.Selector; !maps.Equal(got, want) {
+		t.Errorf("selector = %v, want it restored to %v", got, want)
+	}
+}
@@ -0,0 +1,40 @@
+package controller
+
+import "time"
+
+// debounceDelay returns how long an enqueue of key should wait so that it is
+// reconciled no sooner than Debounce after its last reconcile, or zero when
+// it may be queued right away. Expired entries are dropped on the way.
+func (c *Controller) debounceDelay(key string) time.Duration {
+	c.debounceMu.Lock()
+	defer c.debounceMu.Unlock()
+
+	last, ok := c.lastSynced[key]
+	if !ok {
+		return 0
+	}
+	wait := c.opts.Debounce - time.Since(last)
+	if wait <= 0 {
+		delete(c.lastSynced, key)
+		return 0
+	}
+	return wait
+}
+
+// markSynced records that a reconcile of key is starting.
+func (c *Controller) markSynced(key string) {
+	if c.opts.Debounce <= 0 {
+		return
+	}
+	c.debounceMu.Lock()
+	defer c.debounceMu.Unlock()
+	c.lastSynced[key] = time.Now()
+}
+
+// forgetSynced drops the reconcile time of key once its workload is gone,
+// so the keys of deleted workloads do not accumulate.
+func (c *Controller) forgetSynced(key string) {
+	c.debounceMu.Lock()
+	defer c.debounceMu.Unlock()
+	delete(c.lastSynced, key)
+}
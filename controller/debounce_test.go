@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestDebounceDelaysEnqueue(t *testing.T) {
+	f := newFixture(t, Options{Debounce: time.Hour}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.refresh()
+
+	f.c.EnqueueKey("default/web")
+	if f.c.queue.Len() != 1 {
+		t.Fatal("first enqueue was delayed")
+	}
+	f.c.processItem(context.Background())
+
+	f.c.EnqueueKey("default/web")
+	if f.c.queue.Len() != 0 {
+		t.Error("enqueue within the debounce window was not delayed")
+	}
+	if wait := f.c.debounceDelay("default/web"); wait <= 0 || wait > time.Hour {
+		t.Errorf("debounce delay = %v, want within an hour", wait)
+	}
+}
+
+func TestDebounceForgetsDeletedWorkloads(t *testing.T) {
+	f := newFixture(t, Options{Debounce: time.Hour}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.refresh()
+	f.c.EnqueueKey("default/web")
+	f.c.processItem(context.Background())
+	if _, ok := f.c.lastSynced["default/web"]; !ok {
+		t.Fatal("reconcile time was not recorded")
+	}
+
+	f.deleteDeployment("default", "web")
+	f.refresh()
+	f.c.queue.Add("default/web")
+	f.c.processItem(context.Background())
+	if _, ok := f.c.lastSynced["default/web"]; ok {
+		t.Error("reconcile time of a deleted deployment was kept")
+	}
+}
@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// trackedQueue indexes the keys of a workqueue, which does not expose its
+// contents, for the /debug/queue endpoint. A key is tracked from when it is
+// first added until it is forgotten, i.e. until a reconcile succeeds or is
+// given up on, and is neither queued again nor being processed.
+type trackedQueue struct {
+	workqueue.RateLimitingInterface
+
+	mu   sync.Mutex
+	keys map[interface{}]*keyState
+}
+
+// keyState is what trackedQueue knows about a key.
+type keyState struct {
+	// queued is set while the key is added and not yet handed to a worker,
+	// including while it waits out a delay.
+	queued bool
+	// processing is set while a worker holds the key.
+	processing bool
+	// forgotten is set when the key was forgotten while being processed,
+	// so Done drops it unless it was queued again.
+	forgotten bool
+}
+
+func newTrackedQueue(queue workqueue.RateLimitingInterface) *trackedQueue {
+	return &trackedQueue{RateLimitingInterface: queue, keys: map[interface{}]*keyState{}}
+}
+
+func (q *trackedQueue) track(item interface{}) {
+	if q.ShuttingDown() {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	state, ok := q.keys[item]
+	if !ok {
+		state = &keyState{}
+		q.keys[item] = state
+	}
+	state.queued, state.forgotten = true, false
+}
+
+func (q *trackedQueue) Add(item interface{}) {
+	q.track(item)
+	q.RateLimitingInterface.Add(item)
+}
+
+func (q *trackedQueue) AddAfter(item interface{}, duration time.Duration) {
+	q.track(item)
+	q.RateLimitingInterface.AddAfter(item, duration)
+}
+
+func (q *trackedQueue) AddRateLimited(item interface{}) {
+	q.track(item)
+	q.RateLimitingInterface.AddRateLimited(item)
+}
+
+func (q *trackedQueue) Get() (interface{}, bool) {
+	item, shutdown := q.RateLimitingInterface.Get()
+	if !shutdown {
+		q.mu.Lock()
+		state, ok := q.keys[item]
+		if !ok {
+			state = &keyState{}
+			q.keys[item] = state
+		}
+		state.queued, state.processing = false, true
+		q.mu.Unlock()
+	}
+	return item, shutdown
+}
+
+func (q *trackedQueue) Done(item interface{}) {
+	q.mu.Lock()
+	if state, ok := q.keys[item]; ok {
+		state.processing = false
+		if state.forgotten && !state.queued {
+			delete(q.keys, item)
+		}
+	}
+	q.mu.Unlock()
+	q.RateLimitingInterface.Done(item)
+}
+
+// Forget stops tracking item unless it was added again or a worker still
+// holds it, so a key re-added while in flight stays visible.
+func (q *trackedQueue) Forget(item interface{}) {
+	q.mu.Lock()
+	if state, ok := q.keys[item]; ok {
+		switch {
+		case state.queued:
+		case state.processing:
+			state.forgotten = true
+		default:
+			delete(q.keys, item)
+		}
+	}
+	q.mu.Unlock()
+	q.RateLimitingInterface.Forget(item)
+}
+
+// queueSnapshot is the body served by QueueHandler.
+type queueSnapshot struct {
+	// Length is the number of keys ready to be handed to a worker.
+	Length int `json:"length"`
+	// Pending are tracked keys that have not failed yet.
+	Pending []queuedKey `json:"pending"`
+	// Retrying are tracked keys whose last reconcile failed.
+	Retrying []queuedKey `json:"retrying"`
+}
+
+type queuedKey struct {
+	Key        string `json:"key"`
+	Requeues   int    `json:"requeues,omitempty"`
+	Processing bool   `json:"processing,omitempty"`
+}
+
+// snapshot returns the tracked keys, sorted, split by whether they are
+// being retried.
+func (q *trackedQueue) snapshot() queueSnapshot {
+	q.mu.Lock()
+	keys := make([]queuedKey, 0, len(q.keys))
+	for item, state := range q.keys {
+		if key, ok := item.(string); ok {
+			keys = append(keys, queuedKey{Key: key, Processing: state.processing})
+		}
+	}
+	q.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+
+	s := queueSnapshot{Length: q.Len(), Pending: []queuedKey{}, Retrying: []queuedKey{}}
+	for _, k := range keys {
+		k.Requeues = q.NumRequeues(k.Key)
+		if k.Requeues > 0 {
+			s.Retrying = append(s.Retrying, k)
+		} else {
+			s.Pending = append(s.Pending, k)
+		}
+	}
+	return s
+}
+
+// QueueHandler serves a JSON snapshot of the work queue: its length and the
+// keys queued, in flight or waiting to be retried, with their requeue counts.
+func (c *Controller) QueueHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(c.queue.snapshot()); err != nil {
+			klog.FromContext(r.Context()).Error(err, "Error writing queue snapshot")
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+func newTestTrackedQueue(t *testing.T) *trackedQueue {
+	q := newTrackedQueue(workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(0, 0)))
+	t.Cleanup(q.ShutDown)
+	return q
+}
+
+func snapshotKeys(keys []queuedKey) []string {
+	var out []string
+	for _, k := range keys {
+		out = append(out, k.Key)
+	}
+	return out
+}
+
+func TestTrackedQueueSnapshot(t *testing.T) {
+	q := newTestTrackedQueue(t)
+	q.Add("default/b")
+	q.Add("default/a")
+	q.Add("default/c")
+
+	// default/b, added first, is in flight and default/c failed once.
+	item, _ := q.Get()
+	q.AddRateLimited("default/c")
+
+	s := q.snapshot()
+	if s.Length != 2 {
+		t.Errorf("length = %d, want 2", s.Length)
+	}
+	if got := snapshotKeys(s.Pending); len(got) != 2 || got[0] != "default/a" || got[1] != "default/b" {
+		t.Errorf("pending = %v, want [default/a default/b]", got)
+	}
+	if len(s.Retrying) != 1 || s.Retrying[0].Key != "default/c" || s.Retrying[0].Requeues != 1 {
+		t.Errorf("retrying = %+v, want default/c with one requeue", s.Retrying)
+	}
+	for _, k := range s.Pending {
+		if k.Processing != (k.Key == item) {
+			t.Errorf("%s processing = %v", k.Key, k.Processing)
+		}
+	}
+}
+
+func TestTrackedQueueForget(t *testing.T) {
+	tracked := func(q *trackedQueue, key string) bool {
+		for _, k := range append(q.snapshot().Pending, q.snapshot().Retrying...) {
+			if k.Key == key {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Run("after a reconcile", func(t *testing.T) {
+		q := newTestTrackedQueue(t)
+		q.Add("default/web")
+		item, _ := q.Get()
+		q.Done(item)
+		q.Forget(item)
+		if tracked(q, "default/web") {
+			t.Error("forgotten key is still tracked")
+		}
+	})
+
+	t.Run("re-added while in flight", func(t *testing.T) {
+		q := newTestTrackedQueue(t)
+		q.Add("default/web")
+		item, _ := q.Get()
+		q.Add("default/web")
+		q.Done(item)
+		q.Forget(item)
+		if !tracked(q, "default/web") {
+			t.Fatal("key re-added while in flight was dropped")
+		}
+		if q.Len() != 1 {
+			t.Fatalf("length = %d, want the re-added key", q.Len())
+		}
+
+		item, _ = q.Get()
+		q.Done(item)
+		q.Forget(item)
+		if tracked(q, "default/web") {
+			t.Error("key is still tracked after its second reconcile")
+		}
+	})
+
+	t.Run("forgotten while in flight", func(t *testing.T) {
+		q := newTestTrackedQueue(t)
+		q.Add("default/web")
+		item, _ := q.Get()
+		q.Forget(item)
+		if !tracked(q, "default/web") {
+			t.Fatal("key was dropped while a worker holds it")
+		}
+		q.Done(item)
+		if tracked(q, "default/web") {
+			t.Error("key is still tracked after the worker finished")
+		}
+	})
+}
+
+func TestQueueHandler(t *testing.T) {
+	f := newFixture(t, Options{})
+	f.c.queue.Add("default/web")
+
+	rec := httptest.NewRecorder()
+	f.c.QueueHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/queue", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content type = %q, want application/json", ct)
+	}
+	var s queueSnapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatalf("decoding snapshot: %v", err)
+	}
+	if s.Length != 1 || len(s.Pending) != 1 || s.Pending[0].Key != "default/web" {
+		t.Errorf("snapshot = %+v, want default/web pending", s)
+	}
+}
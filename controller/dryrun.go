@@ -0,0 +1,16 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/klog/v2"
+)
+
+// logDryRun logs a change the controller would have applied, with a diff
+// from before to after. before is the zero value for creates and after for
+// deletes.
+func logDryRun(ctx context.Context, action, kind, namespace, name string, before, after interface{}) {
+	klog.FromContext(ctx).Info("Dry run, not applying change", "action", action, "objectKind", kind,
+		"objectNamespace", namespace, "objectName", name, "diff", cmp.Diff(before, after))
+}
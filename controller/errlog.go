@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// errorLogInterval is the minimum time between two full error logs for the
+// same key.
+const errorLogInterval = time.Minute
+
+type errorLogState struct {
+	last       time.Time
+	suppressed int
+}
+
+// logSyncError logs a retried reconcile error of key in full at most once
+// per errorLogInterval. Errors in between are counted and only logged
+// tersely at V(2), so a persistently failing key does not flood the logs.
+// logger carries the key.
+func (c *Controller) logSyncError(logger logr.Logger, key string, err error) {
+	c.errLogMu.Lock()
+	st, ok := c.errLogs[key]
+	if !ok {
+		st = &errorLogState{}
+		c.errLogs[key] = st
+	}
+	if !st.last.IsZero() && time.Since(st.last) < errorLogInterval {
+		st.suppressed++
+		n := st.suppressed
+		c.errLogMu.Unlock()
+		logger.V(2).Info("Error syncing again", "sinceLastLogged", n)
+		return
+	}
+	suppressed := st.suppressed
+	st.last, st.suppressed = time.Now(), 0
+	c.errLogMu.Unlock()
+
+	if suppressed > 0 {
+		logger.Error(err, "Error syncing", "suppressed", suppressed)
+		return
+	}
+	logger.Error(err, "Error syncing")
+}
+
+// forgetSyncError drops the error log state of key.
+func (c *Controller) forgetSyncError(key string) {
+	c.errLogMu.Lock()
+	defer c.errLogMu.Unlock()
+	delete(c.errLogs, key)
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// cleanupFinalizer holds an exposed Deployment's deletion until the
+// controller has removed what it created for it.
+const cleanupFinalizer = annotationPrefix + "cleanup"
+
+func hasFinalizer(wl *workload) bool {
+	for _, f := range wl.Finalizers {
+		if f == cleanupFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureFinalizer adds the cleanup finalizer to wl if it is enabled and
+// missing. StatefulSets never get one.
+func (c *Controller) ensureFinalizer(ctx context.Context, wl *workload) error {
+	if !c.opts.Finalizer || c.opts.OutputDir != "" || wl.kind != deploymentKind || hasFinalizer(wl) {
+		return nil
+	}
+
+	finalizers := append(append([]string(nil), wl.Finalizers...), cleanupFinalizer)
+	return c.patchFinalizers(ctx, wl, finalizers, "add")
+}
+
+// removeFinalizer drops the cleanup finalizer from wl, letting a pending
+// deletion complete. It is a no-op when the finalizer is absent, so it is
+// safe to call whether or not the finalizer is enabled.
+func (c *Controller) removeFinalizer(ctx context.Context, wl *workload) error {
+	if wl.kind != deploymentKind || !hasFinalizer(wl) {
+		return nil
+	}
+
+	finalizers := []string{}
+	for _, f := range wl.Finalizers {
+		if f != cleanupFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	return c.patchFinalizers(ctx, wl, finalizers, "remove")
+}
+
+// patchFinalizers sets the finalizers of wl with a merge patch touching
+// nothing else, so the write cannot be rejected over other fields, such as
+// annotations the validating webhook denies, and a Deployment being deleted
+// is never stuck on its finalizer. The patch carries the resourceVersion
+// the finalizers were read at, so a concurrent change to them conflicts
+// instead of being overwritten.
+func (c *Controller) patchFinalizers(ctx context.Context, wl *workload, finalizers []string, action string) error {
+	if c.opts.DryRun {
+		logDryRun(ctx, action+" finalizer on", "Deployment", wl.Namespace, wl.Name, wl.Finalizers, finalizers)
+		return nil
+	}
+
+	// Marshalling plain maps cannot fail.
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"finalizers":      finalizers,
+			"resourceVersion": wl.ResourceVersion,
+		},
+	})
+	_, err := c.clientset.AppsV1().Deployments(wl.Namespace).Patch(ctx, wl.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to %s finalizer on deployment %s/%s: %v", action, wl.Namespace, wl.Name, err)
+	}
+	klog.FromContext(ctx).Info("Updated cleanup finalizer", "action", action)
+	return nil
+}
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// finalizers returns the finalizers of the Deployment default/name.
+func (f *fixture) finalizers(name string) []string {
+	f.t.Helper()
+	deploy, err := f.client.AppsV1().Deployments("default").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		f.t.Fatalf("getting deployment: %v", err)
+	}
+	return deploy.Finalizers
+}
+
+// onlyPatchesDeployments fails the test if the controller wrote a
+// Deployment other than through a patch.
+func (f *fixture) onlyPatchesDeployments() {
+	f.t.Helper()
+	for _, action := range f.client.Actions() {
+		if action.GetResource().Resource == "deployments" && action.GetVerb() == "update" {
+			f.t.Errorf("deployment was updated instead of patched: %v", action)
+		}
+	}
+}
+
+func TestFinalizerAddedAndRemoved(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Finalizers = []string{"example.com/other"}
+	f := newFixture(t, Options{Finalizer: true}, deploy)
+	f.mustSync("default/web")
+
+	if got, want := f.finalizers("web"), []string{"example.com/other", cleanupFinalizer}; !slices.Equal(got, want) {
+		t.Fatalf("finalizers = %v, want %v", got, want)
+	}
+	if f.service("default", "web-expose") == nil {
+		t.Fatal("service was not created")
+	}
+
+	deleting, err := f.client.AppsV1().Deployments("default").Get(context.Background(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting deployment: %v", err)
+	}
+	deleting.DeletionTimestamp = &metav1.Time{}
+	if _, err := f.client.AppsV1().Deployments("default").Update(context.Background(), deleting, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("marking deployment deleted: %v", err)
+	}
+	f.client.ClearActions()
+	f.mustSync("default/web")
+
+	if f.service("default", "web-expose") != nil {
+		t.Error("service of the deleted deployment was not removed")
+	}
+	if got, want := f.finalizers("web"), []string{"example.com/other"}; !slices.Equal(got, want) {
+		t.Errorf("finalizers = %v, want %v", got, want)
+	}
+	f.onlyPatchesDeployments()
+}
+
+func TestFinalizerPatchOnlyTouchesFinalizers(t *testing.T) {
+	f := newFixture(t, Options{Finalizer: true}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+
+	var patches []string
+	for _, action := range f.client.Actions() {
+		if patch, ok := action.(k8stesting.PatchAction); ok && strings.Contains(string(patch.GetPatch()), "finalizers") {
+			patches = append(patches, string(patch.GetPatch()))
+		}
+	}
+	want := `{"metadata":{"finalizers":["` + cleanupFinalizer + `"],"resourceVersion":""}}`
+	if len(patches) != 1 || patches[0] != want {
+		t.Errorf("finalizer patches = %q, want %q", patches, want)
+	}
+}
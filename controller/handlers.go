@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// isResync reports whether an update is a periodic informer resync, which
+// re-delivers the cached object itself as both the old and the new object.
+func isResync(oldObj, newObj interface{}) bool {
+	return oldObj == newObj
+}
+
+// unchanged reports whether an update is a duplicate delivery of an object
+// version already seen, such as the objects replayed after a relist: it
+// carries the same ResourceVersion but is not a resync. Resyncs are let
+// through, since they exist to correct drift no event reported.
+func unchanged(oldObj, newObj interface{}) bool {
+	if isResync(oldObj, newObj) {
+		return false
+	}
+	oldMeta, err := meta.Accessor(oldObj)
+	if err != nil {
+		return false
+	}
+	newMeta, err := meta.Accessor(newObj)
+	if err != nil {
+		return false
+	}
+	return oldMeta.GetResourceVersion() == newMeta.GetResourceVersion()
+}
+
+// deploymentHandlers enqueues the key of every added, updated or deleted
+// Deployment.
+func (c *Controller) deploymentHandlers() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			key, err := cache.MetaNamespaceKeyFunc(obj)
+			if err != nil {
+				klog.ErrorS(err, "Error creating key")
+				return
+			}
+			klog.InfoS("Add event", "key", key)
+			c.EnqueueKey(key)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if unchanged(oldObj, newObj) {
+				return
+			}
+			oldDeploy, oldOK := oldObj.(*appsv1.Deployment)
+			newDeploy, newOK := newObj.(*appsv1.Deployment)
+			if oldOK && newOK && !isResync(oldObj, newObj) && onlyStatusChanged(oldDeploy, newDeploy) {
+				return
+			}
+			key, err := cache.MetaNamespaceKeyFunc(newObj)
+			if err != nil {
+				klog.ErrorS(err, "Error creating key")
+				return
+			}
+			klog.InfoS("Update event", "key", key)
+			c.EnqueueKey(key)
+		},
+		DeleteFunc: func(obj interface{}) {
+			key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+			if err != nil {
+				klog.ErrorS(err, "Error creating key")
+				return
+			}
+			klog.InfoS("Delete event", "key", key)
+			c.EnqueueKey(key)
+		},
+	}
+}
+
+// namespaceHandlers re-enqueues the Deployments of a namespace whose opt-in
+// annotation may have changed.
+func (c *Controller) namespaceHandlers() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if ns, ok := obj.(*v1.Namespace); ok {
+				c.EnqueueNamespace(ns.Name)
+			}
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			if ns, ok := newObj.(*v1.Namespace); ok {
+				klog.InfoS("Update event for namespace", "namespace", ns.Name)
+				c.EnqueueNamespace(ns.Name)
+			}
+		},
+	}
+}
+
+// serviceHandlers re-enqueues the workload behind a managed Service that
+// was edited or deleted, so drift is repaired without waiting for the next
+// Deployment change.
+func (c *Controller) serviceHandlers() cache.ResourceEventHandler {
+	enqueue := func(obj interface{}, event string) {
+		// A delete missed during a relist arrives wrapped in a tombstone.
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		svc, ok := obj.(*v1.Service)
+		if !ok || !c.isManagedService(svc) {
+			return
+		}
+		key, ok := c.workloadKeyForService(svc)
+		if !ok {
+			return
+		}
+		klog.InfoS(event+" event for service", "service", klog.KObj(svc), "key", key)
+		c.EnqueueKey(key)
+	}
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if !unchanged(oldObj, newObj) {
+				enqueue(newObj, "Update")
+			}
+		},
+		DeleteFunc: func(obj interface{}) { enqueue(obj, "Delete") },
+	}
+}
+
+// workloadKeyForService maps a Service back to the queue key of the
+// workload it was generated for, through its controller reference or, for
+// Services without one, through the naming convention. A name match only
+// counts while the Deployment exists, so Services retained after their
+// Deployment was deleted are not mistaken for leftovers and cleaned up.
+func (c *Controller) workloadKeyForService(svc *v1.Service) (string, bool) {
+	if key, ok := svc.Annotations[hubWorkloadAnnotation]; ok && svc.Namespace == c.opts.ServiceNamespace {
+		return key, true
+	}
+	if namespace, name, ok := resolveOwningDeployment(svc); ok {
+		return namespace + "/" + name, true
+	}
+	if ref := metav1.GetControllerOf(svc); ref != nil {
+		if ref.Kind != statefulSetKind || c.statefulSetLister == nil {
+			return "", false
+		}
+		return workloadKey(ref.Kind, svc.Namespace+"/"+ref.Name), true
+	}
+	for _, suffix := range []string{c.opts.ServiceSuffix, internalSuffix} {
+		name, ok := strings.CutSuffix(svc.Name, suffix)
+		if !ok || name == "" {
+			continue
+		}
+		if _, err := c.deployLister.Deployments(svc.Namespace).Get(name); err == nil {
+			return svc.Namespace + "/" + name, true
+		}
+	}
+	return "", false
+}
+
+// resolveOwningDeployment returns the Deployment named by the controller
+// reference of svc, whatever the Service is called.
+func resolveOwningDeployment(svc *v1.Service) (namespace, name string, ok bool) {
+	ref := metav1.GetControllerOf(svc)
+	if ref == nil || ref.Kind != deploymentKind {
+		return "", "", false
+	}
+	if gv, err := schema.ParseGroupVersion(ref.APIVersion); err != nil || gv.Group != appsv1.GroupName {
+		return "", "", false
+	}
+	return svc.Namespace, ref.Name, true
+}
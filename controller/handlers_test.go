@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestDeploymentUpdateEvents(t *testing.T) {
+	cached := newDeployment("web")
+	cached.ResourceVersion = "1"
+	replayed := cached.DeepCopy()
+	withStatus := cached.DeepCopy()
+	withStatus.ResourceVersion = "2"
+	withStatus.Annotations[statusAnnotation] = statusExposed
+	relabeled := cached.DeepCopy()
+	relabeled.ResourceVersion = "2"
+	relabeled.Labels = map[string]string{"tier": "web"}
+
+	tests := []struct {
+		name     string
+		old, new interface{}
+		enqueued bool
+	}{
+		{name: "resync", old: cached, new: cached, enqueued: true},
+		{name: "replayed after relist", old: cached, new: replayed},
+		{name: "status annotations only", old: cached, new: withStatus},
+		{name: "labels changed", old: cached, new: relabeled, enqueued: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t, Options{})
+			f.c.deploymentHandlers().OnUpdate(tt.old, tt.new)
+			if got := f.c.queue.Len() == 1; got != tt.enqueued {
+				t.Errorf("enqueued = %v, want %v", got, tt.enqueued)
+			}
+		})
+	}
+}
+
+func TestServiceEventsEnqueueWorkload(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+	svc := f.service("default", "web-expose")
+	drifted := svc.DeepCopy()
+	drifted.ResourceVersion = svc.ResourceVersion + "1"
+	drifted.Spec.Selector = nil
+	user := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web-expose", Namespace: "default"}}
+
+	tests := []struct {
+		name  string
+		event func(cache.ResourceEventHandler)
+		want  string
+	}{
+		{name: "deleted", event: func(h cache.ResourceEventHandler) { h.OnDelete(svc) }, want: "default/web"},
+		{name: "edited", event: func(h cache.ResourceEventHandler) { h.OnUpdate(svc, drifted) }, want: "default/web"},
+		{name: "unmanaged deleted", event: func(h cache.ResourceEventHandler) { h.OnDelete(user) }},
+		{
+			name: "deleted during a relist",
+			event: func(h cache.ResourceEventHandler) {
+				h.OnDelete(cache.DeletedFinalStateUnknown{Key: "default/web-expose", Obj: svc})
+			},
+			want: "default/web",
+		},
+		{
+			name: "unmanaged deleted during a relist",
+			event: func(h cache.ResourceEventHandler) {
+				h.OnDelete(cache.DeletedFinalStateUnknown{Key: "default/web-expose", Obj: user})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f.c.queue = newTrackedQueue(workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()))
+			defer f.c.queue.ShutDown()
+			tt.event(f.c.serviceHandlers())
+
+			if tt.want == "" {
+				if n := f.c.queue.Len(); n != 0 {
+					t.Errorf("queue length = %d, want nothing enqueued", n)
+				}
+				return
+			}
+			if n := f.c.queue.Len(); n != 1 {
+				t.Fatalf("queue length = %d, want 1", n)
+			}
+			if key, _ := f.c.queue.Get(); key != tt.want {
+				t.Errorf("enqueued %v, want %s", key, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveOwningDeployment(t *testing.T) {
+	isController := true
+	controllerRef := func(apiVersion, kind string) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{APIVersion: apiVersion, Kind: kind, Name: "web", Controller: &isController}}
+	}
+	tests := []struct {
+		name   string
+		owners []metav1.OwnerReference
+		ok     bool
+	}{
+		{name: "deployment controller", owners: controllerRef("apps/v1", deploymentKind), ok: true},
+		{name: "no owner"},
+		{name: "statefulset controller", owners: controllerRef("apps/v1", statefulSetKind)},
+		{name: "deployment of another group", owners: controllerRef("example.com/v1", deploymentKind)},
+		{name: "deployment owner that is not the controller", owners: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: deploymentKind, Name: "web"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The name does not follow the -expose convention.
+			svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "shop", OwnerReferences: tt.owners}}
+			namespace, name, ok := resolveOwningDeployment(svc)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && (namespace != "shop" || name != "web") {
+				t.Errorf("owner = %s/%s, want shop/web", namespace, name)
+			}
+		})
+	}
+}
+
+func TestServiceEventsFollowOwnerReference(t *testing.T) {
+	f := newFixture(t, Options{ServiceSuffix: "-svc"}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+	svc := f.service("default", "web-svc")
+	if svc == nil {
+		t.Fatal("service was not created")
+	}
+	renamed := svc.DeepCopy()
+	renamed.Name = "frontend"
+
+	f.c.queue = newTrackedQueue(workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()))
+	defer f.c.queue.ShutDown()
+	f.c.serviceHandlers().OnDelete(renamed)
+	if n := f.c.queue.Len(); n != 1 {
+		t.Fatalf("queue length = %d, want 1", n)
+	}
+	if key, _ := f.c.queue.Get(); key != "default/web" {
+		t.Errorf("enqueued %v, want default/web", key)
+	}
+}
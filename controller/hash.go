@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// specHashAnnotation records the hash of the managed Service fields the
+// controller last applied, so operators can verify its state.
+const specHashAnnotation = annotationPrefix + "spec-hash"
+
+type hashedPort struct {
+	Name       string             `json:"name"`
+	Protocol   v1.Protocol        `json:"protocol"`
+	Port       int32              `json:"port"`
+	TargetPort intstr.IntOrString `json:"targetPort"`
+	// AppProtocol is omitted unless set so older hashes stay valid.
+	AppProtocol string `json:"appProtocol,omitempty"`
+}
+
+type hashedSpec struct {
+	Type     v1.ServiceType    `json:"type"`
+	Selector map[string]string `json:"selector"`
+	Ports    []hashedPort      `json:"ports"`
+	// SessionAffinity and AffinityTimeout are omitted at their defaults so
+	// hashes recorded before they were managed stay valid.
+	SessionAffinity v1.ServiceAffinity `json:"sessionAffinity,omitempty"`
+	AffinityTimeout int32              `json:"affinityTimeout,omitempty"`
+	// ExternalTrafficPolicy and InternalTrafficPolicy are only set when
+	// Local, for the same reason.
+	ExternalTrafficPolicy v1.ServiceExternalTrafficPolicy `json:"externalTrafficPolicy,omitempty"`
+	InternalTrafficPolicy v1.ServiceInternalTrafficPolicy `json:"internalTrafficPolicy,omitempty"`
+	// LoadBalancerClass and LoadBalancerSourceRanges are omitted unless set.
+	LoadBalancerClass        string   `json:"loadBalancerClass,omitempty"`
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty"`
+	// PublishNotReadyAddresses is omitted unless set.
+	PublishNotReadyAddresses bool `json:"publishNotReadyAddresses,omitempty"`
+}
+
+// specHash returns a SHA-256 over the Service fields the controller manages.
+// Ports are sorted and API defaults normalized so the hash of a live Service
+// matches the hash of the desired one it was created from. Allocated
+// NodePorts and annotations, including the hash itself, are not hashed.
+func specHash(svc *v1.Service) string {
+	spec := hashedSpec{
+		Type:     svc.Spec.Type,
+		Selector: svc.Spec.Selector,
+	}
+	if spec.Type == "" {
+		spec.Type = v1.ServiceTypeClusterIP
+	}
+	if spec.Type != v1.ServiceTypeClusterIP && svc.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyLocal {
+		spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyLocal
+	}
+	if svc.Spec.LoadBalancerClass != nil {
+		spec.LoadBalancerClass = *svc.Spec.LoadBalancerClass
+	}
+	spec.LoadBalancerSourceRanges = svc.Spec.LoadBalancerSourceRanges
+	spec.PublishNotReadyAddresses = svc.Spec.PublishNotReadyAddresses
+	if p := svc.Spec.InternalTrafficPolicy; p != nil && *p == v1.ServiceInternalTrafficPolicyLocal {
+		spec.InternalTrafficPolicy = v1.ServiceInternalTrafficPolicyLocal
+	}
+	if svc.Spec.SessionAffinity == v1.ServiceAffinityClientIP {
+		spec.SessionAffinity = v1.ServiceAffinityClientIP
+		spec.AffinityTimeout = v1.DefaultClientIPServiceAffinitySeconds
+		if cfg := svc.Spec.SessionAffinityConfig; cfg != nil && cfg.ClientIP != nil && cfg.ClientIP.TimeoutSeconds != nil {
+			spec.AffinityTimeout = *cfg.ClientIP.TimeoutSeconds
+		}
+	}
+	for _, p := range svc.Spec.Ports {
+		hp := hashedPort{
+			Name:       p.Name,
+			Protocol:   keyOf(p).protocol,
+			Port:       p.Port,
+			TargetPort: p.TargetPort,
+		}
+		if p.AppProtocol != nil {
+			hp.AppProtocol = *p.AppProtocol
+		}
+		spec.Ports = append(spec.Ports, hp)
+	}
+	sort.Slice(spec.Ports, func(i, j int) bool {
+		if spec.Ports[i].Port != spec.Ports[j].Port {
+			return spec.Ports[i].Port < spec.Ports[j].Port
+		}
+		return spec.Ports[i].Protocol < spec.Ports[j].Protocol
+	})
+
+	// Marshalling plain structs and string maps cannot fail.
+	data, _ := json.Marshal(spec)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
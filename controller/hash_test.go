@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestSpecHash(t *testing.T) {
+	desired := &v1.Service{Spec: v1.ServiceSpec{
+		Type:     v1.ServiceTypeNodePort,
+		Selector: map[string]string{"app": "web"},
+		Ports: []v1.ServicePort{
+			{Name: "http", Port: 8080, TargetPort: intstr.FromInt32(8080)},
+			{Name: "metrics", Port: 9090, TargetPort: intstr.FromString("metrics"), Protocol: v1.ProtocolTCP},
+		},
+	}}
+	want := specHash(desired)
+
+	// The Service as the API server returns it: defaults applied, NodePorts
+	// allocated and the ports in another order.
+	live := desired.DeepCopy()
+	live.Spec.ClusterIP = "10.0.0.1"
+	live.Spec.SessionAffinity = v1.ServiceAffinityNone
+	live.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyCluster
+	live.Spec.Ports = []v1.ServicePort{desired.Spec.Ports[1], desired.Spec.Ports[0]}
+	live.Spec.Ports[0].NodePort = 31090
+	live.Spec.Ports[1].Protocol = v1.ProtocolTCP
+	live.Spec.Ports[1].NodePort = 31080
+	if got := specHash(live); got != want {
+		t.Errorf("hash of the defaulted service = %s, want %s", got, want)
+	}
+
+	changed := desired.DeepCopy()
+	changed.Spec.Selector = map[string]string{"app": "api"}
+	if specHash(changed) == want {
+		t.Error("hash did not change with the selector")
+	}
+}
+
+func TestSpecHashAnnotation(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+
+	svc := f.service("default", "web-expose")
+	if got := svc.Annotations[specHashAnnotation]; got == "" || got != specHash(svc) {
+		t.Errorf("spec hash = %q, want the hash of the created service %q", got, specHash(svc))
+	}
+}
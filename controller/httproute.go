@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// gatewayAnnotation names the Gateway ("name" or "namespace/name") the
+	// HTTPRoute attaches to.
+	gatewayAnnotation = annotationPrefix + "gateway"
+	// hostAnnotation is the hostname routed to the Service.
+	hostAnnotation = annotationPrefix + "host"
+)
+
+// HTTPRouteGVR identifies Gateway API HTTPRoutes.
+var HTTPRouteGVR = schema.GroupVersionResource{
+	Group:    "gateway.networking.k8s.io",
+	Version:  "v1",
+	Resource: "httproutes",
+}
+
+// HTTPRouteAPIAvailable reports whether the Gateway API HTTPRoute CRD is
+// served by the cluster.
+func HTTPRouteAPIAvailable(client discovery.DiscoveryInterface) bool {
+	resources, err := client.ServerResourcesForGroupVersion(HTTPRouteGVR.GroupVersion().String())
+	if err != nil {
+		return false
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == HTTPRouteGVR.Resource {
+			return true
+		}
+	}
+	return false
+}
+
+// EnableHTTPRoutes turns on HTTPRoute reconciliation. It must be called
+// before Run.
+func (c *Controller) EnableHTTPRoutes(client dynamic.Interface, lister cache.GenericLister) {
+	c.dynamicClient = client
+	c.routeLister = lister
+}
+
+// desiredHTTPRoute builds the HTTPRoute routing to svc, or returns nil when
+// the Deployment does not request one.
+func (c *Controller) desiredHTTPRoute(cfg *ExposeConfig, svc *v1.Service) *unstructured.Unstructured {
+	gateway, host := cfg.Gateway, cfg.Host
+	if gateway == "" || host == "" || len(svc.Spec.Ports) == 0 {
+		return nil
+	}
+
+	parentRef := map[string]interface{}{"name": gateway}
+	if ns, name, ok := strings.Cut(gateway, "/"); ok {
+		parentRef = map[string]interface{}{"namespace": ns, "name": name}
+	}
+
+	route := &unstructured.Unstructured{}
+	route.SetAPIVersion(HTTPRouteGVR.GroupVersion().String())
+	route.SetKind("HTTPRoute")
+	route.SetName(svc.Name)
+	route.SetNamespace(svc.Namespace)
+	route.SetLabels(map[string]string{controllerLabel: c.opts.ControllerName})
+	route.Object["spec"] = map[string]interface{}{
+		"parentRefs": []interface{}{parentRef},
+		"hostnames":  []interface{}{host},
+		"rules": []interface{}{
+			map[string]interface{}{
+				"backendRefs": []interface{}{
+					map[string]interface{}{
+						"name": svc.Name,
+						"port": int64(svc.Spec.Ports[0].Port),
+					},
+				},
+			},
+		},
+	}
+	return route
+}
+
+func (c *Controller) reconcileHTTPRoute(ctx context.Context, cfg *ExposeConfig, svc *v1.Service) error {
+	if c.routeLister == nil && c.opts.OutputDir == "" {
+		return nil
+	}
+
+	desired := c.desiredHTTPRoute(cfg, svc)
+	if desired == nil {
+		return c.removeHTTPRoute(ctx, svc.Namespace, svc.Name)
+	}
+
+	if c.opts.OutputDir != "" {
+		data, err := yaml.Marshal(desired.Object)
+		if err != nil {
+			return fmt.Errorf("failed to render httproute %s/%s: %v", svc.Namespace, svc.Name, err)
+		}
+		return c.writeManifest(ctx, c.routeFilePath(svc.Namespace, svc.Name), data)
+	}
+
+	obj, err := c.routeLister.ByNamespace(svc.Namespace).Get(svc.Name)
+	if errors.IsNotFound(err) {
+		klog.FromContext(ctx).Info("HTTPRoute missing, creating", "httproute", svc.Name)
+		if c.opts.DryRun {
+			logDryRun(ctx, "create", "HTTPRoute", svc.Namespace, svc.Name, map[string]interface{}{}, desired.Object)
+			return nil
+		}
+		_, err := c.dynamicClient.Resource(HTTPRouteGVR).Namespace(svc.Namespace).Create(
+			ctx,
+			desired,
+			metav1.CreateOptions{},
+		)
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create httproute %s/%s: %v", svc.Namespace, svc.Name, err)
+		}
+		klog.FromContext(ctx).Info("HTTPRoute created", "httproute", svc.Name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get httproute %s/%s: %v", svc.Namespace, svc.Name, err)
+	}
+
+	current, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("unexpected httproute type %T", obj)
+	}
+	if current.GetLabels()[controllerLabel] != c.opts.ControllerName {
+		klog.FromContext(ctx).Info("HTTPRoute is not managed by this controller, leaving it alone", "httproute", svc.Name)
+		return nil
+	}
+	if reflect.DeepEqual(ownedRouteSpec(current), ownedRouteSpec(desired)) {
+		return nil
+	}
+
+	updated := current.DeepCopy()
+	updated.Object["spec"] = desired.Object["spec"]
+	if c.opts.DryRun {
+		logDryRun(ctx, "update", "HTTPRoute", svc.Namespace, svc.Name, current.Object["spec"], updated.Object["spec"])
+		return nil
+	}
+	_, err = c.dynamicClient.Resource(HTTPRouteGVR).Namespace(svc.Namespace).Update(
+		ctx,
+		updated,
+		metav1.UpdateOptions{},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update httproute %s/%s: %v", svc.Namespace, svc.Name, err)
+	}
+
+	klog.FromContext(ctx).Info("HTTPRoute updated", "httproute", svc.Name)
+	return nil
+}
+
+// ownedRouteSpec projects the spec of route onto the fields desiredHTTPRoute
+// sets. The defaults the API server adds, such as the group and kind of
+// parentRefs, the kind and weight of backendRefs and the matches of rules,
+// are dropped, so a defaulted live route compares equal to its desired one.
+func ownedRouteSpec(route *unstructured.Unstructured) map[string]interface{} {
+	parents, _, _ := unstructured.NestedSlice(route.Object, "spec", "parentRefs")
+	hostnames, _, _ := unstructured.NestedSlice(route.Object, "spec", "hostnames")
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+
+	var ownedRules []interface{}
+	for _, r := range rules {
+		rule, _ := r.(map[string]interface{})
+		backends, _ := rule["backendRefs"].([]interface{})
+		ownedRules = append(ownedRules, map[string]interface{}{"backendRefs": projectFields(backends, "name", "port")})
+	}
+	return map[string]interface{}{
+		"parentRefs": projectFields(parents, "namespace", "name"),
+		"hostnames":  hostnames,
+		"rules":      ownedRules,
+	}
+}
+
+// projectFields returns a copy of items, a list of objects, keeping only the
+// given fields of each.
+func projectFields(items []interface{}, fields ...string) []interface{} {
+	var out []interface{}
+	for _, item := range items {
+		obj, _ := item.(map[string]interface{})
+		projected := map[string]interface{}{}
+		for _, field := range fields {
+			if v, ok := obj[field]; ok {
+				projected[field] = v
+			}
+		}
+		out = append(out, projected)
+	}
+	return out
+}
+
+func (c *Controller) removeHTTPRoute(ctx context.Context, namespace, name string) error {
+	if c.opts.OutputDir != "" {
+		return c.deleteManifest(ctx, c.routeFilePath(namespace, name))
+	}
+	if c.routeLister == nil {
+		return nil
+	}
+
+	obj, err := c.routeLister.ByNamespace(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get httproute %s/%s: %v", namespace, name, err)
+	}
+	route, ok := obj.(*unstructured.Unstructured)
+	if !ok || route.GetLabels()[controllerLabel] != c.opts.ControllerName {
+		return nil
+	}
+	if c.opts.DryRun {
+		logDryRun(ctx, "delete", "HTTPRoute", namespace, name, route.Object, map[string]interface{}{})
+		return nil
+	}
+
+	err = c.dynamicClient.Resource(HTTPRouteGVR).Namespace(namespace).Delete(
+		ctx,
+		name,
+		metav1.DeleteOptions{},
+	)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete httproute %s/%s: %v", namespace, name, err)
+	}
+
+	klog.FromContext(ctx).Info("HTTPRoute deleted", "httproute", name)
+	return nil
+}
+
+func (c *Controller) routeFilePath(namespace, name string) string {
+	return filepath.Join(c.opts.OutputDir, fmt.Sprintf("%s-%s-httproute.yaml", namespace, name))
+}
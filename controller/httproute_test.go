@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// routeFixture returns a controller reconciling HTTPRoutes against a fake
+// dynamic client whose routes are also loaded into the route lister.
+func routeFixture(t *testing.T, routes ...*unstructured.Unstructured) (*Controller, *dynamicfake.FakeDynamicClient) {
+	t.Helper()
+	var objects []runtime.Object
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, route := range routes {
+		objects = append(objects, route)
+		if err := indexer.Add(route); err != nil {
+			t.Fatalf("loading route: %v", err)
+		}
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{HTTPRouteGVR: "HTTPRouteList"}, objects...)
+
+	f := newFixture(t, Options{})
+	f.c.EnableHTTPRoutes(client, cache.NewGenericLister(indexer, HTTPRouteGVR.GroupResource()))
+	return f.c, client
+}
+
+func routeService() *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-expose", Namespace: "default"},
+		Spec:       v1.ServiceSpec{Ports: []v1.ServicePort{{Name: "http", Port: 80}}},
+	}
+}
+
+// defaulted returns route as the API server stores it, with the defaults
+// the Gateway API CRD applies.
+func defaulted(t *testing.T, route *unstructured.Unstructured) *unstructured.Unstructured {
+	t.Helper()
+	out := route.DeepCopy()
+	spec := out.Object["spec"].(map[string]interface{})
+	for _, p := range spec["parentRefs"].([]interface{}) {
+		parent := p.(map[string]interface{})
+		parent["group"] = "gateway.networking.k8s.io"
+		parent["kind"] = "Gateway"
+	}
+	for _, r := range spec["rules"].([]interface{}) {
+		rule := r.(map[string]interface{})
+		rule["matches"] = []interface{}{
+			map[string]interface{}{"path": map[string]interface{}{"type": "PathPrefix", "value": "/"}},
+		}
+		for _, b := range rule["backendRefs"].([]interface{}) {
+			backend := b.(map[string]interface{})
+			backend["group"] = ""
+			backend["kind"] = "Service"
+			backend["weight"] = int64(1)
+		}
+	}
+	return out
+}
+
+func TestReconcileHTTPRoute(t *testing.T) {
+	cfg := &ExposeConfig{Gateway: "infra/gw", Host: "web.example.com"}
+	svc := routeService()
+	wanted := (&Controller{opts: Options{ControllerName: DefaultControllerName}}).desiredHTTPRoute(cfg, svc)
+
+	otherHost := defaulted(t, wanted)
+	otherHost.Object["spec"].(map[string]interface{})["hostnames"] = []interface{}{"old.example.com"}
+
+	tests := []struct {
+		name     string
+		existing []*unstructured.Unstructured
+		cfg      *ExposeConfig
+		want     []string
+	}{
+		{name: "missing route is created", cfg: cfg, want: []string{"create"}},
+		{name: "defaulted route is unchanged", existing: []*unstructured.Unstructured{defaulted(t, wanted)}, cfg: cfg},
+		{name: "drifted host is updated", existing: []*unstructured.Unstructured{otherHost}, cfg: cfg, want: []string{"update"}},
+		{name: "route no longer wanted is deleted", existing: []*unstructured.Unstructured{defaulted(t, wanted)}, cfg: &ExposeConfig{}, want: []string{"delete"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, client := routeFixture(t, tt.existing...)
+			if err := c.reconcileHTTPRoute(context.Background(), tt.cfg, svc); err != nil {
+				t.Fatalf("reconcileHTTPRoute: %v", err)
+			}
+
+			var got []string
+			for _, action := range client.Actions() {
+				got = append(got, action.GetVerb())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("actions = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("actions = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileHTTPRouteLeavesUnmanagedRoute(t *testing.T) {
+	cfg := &ExposeConfig{Gateway: "gw", Host: "web.example.com"}
+	svc := routeService()
+	route := (&Controller{opts: Options{ControllerName: DefaultControllerName}}).desiredHTTPRoute(cfg, svc)
+	route.SetLabels(nil)
+	route.Object["spec"].(map[string]interface{})["hostnames"] = []interface{}{"someone-else.example.com"}
+
+	c, client := routeFixture(t, route)
+	if err := c.reconcileHTTPRoute(context.Background(), cfg, svc); err != nil {
+		t.Fatalf("reconcileHTTPRoute: %v", err)
+	}
+	if err := c.removeHTTPRoute(context.Background(), "default", "web-expose"); err != nil {
+		t.Fatalf("removeHTTPRoute: %v", err)
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("unmanaged route was touched: %v", actions)
+	}
+}
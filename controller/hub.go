@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// hubWorkloadAnnotation records, on a Service in the service namespace, the
+// queue key of the workload it resolves to.
+const hubWorkloadAnnotation = annotationPrefix + "workload"
+
+// usesHub reports whether workloads of namespace get a Service in the
+// service namespace.
+func (c *Controller) usesHub(namespace string) bool {
+	return c.opts.ServiceNamespace != "" && c.opts.ServiceNamespace != namespace
+}
+
+// hubServiceName names a workload's Service in the service namespace. The
+// workload's namespace is part of the name, since workloads of every
+// namespace share the service namespace.
+func (c *Controller) hubServiceName(namespace, name string) string {
+	return c.exposeName(name + "-" + namespace)
+}
+
+// desiredHubService builds the ExternalName Service in the service namespace
+// that resolves to target, the workload's own Service. A selector there
+// would pick Pods of the service namespace rather than the workload's, so it
+// has none. It has no owner reference either, since owners cannot live in
+// another namespace.
+func (c *Controller) desiredHubService(key string, wl *workload, cfg *ExposeConfig, target *v1.Service) *v1.Service {
+	ports := make([]v1.ServicePort, len(target.Spec.Ports))
+	for i, p := range target.Spec.Ports {
+		p.NodePort = 0
+		ports[i] = p
+	}
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        c.hubServiceName(wl.Namespace, wl.Name),
+			Namespace:   c.opts.ServiceNamespace,
+			Labels:      c.serviceLabels(wl, cfg),
+			Annotations: map[string]string{hubWorkloadAnnotation: key},
+		},
+		Spec: v1.ServiceSpec{
+			Type:         v1.ServiceTypeExternalName,
+			ExternalName: fmt.Sprintf("%s.%s.svc", target.Name, target.Namespace),
+			Ports:        ports,
+		},
+	}
+	recordManagedLabels(svc)
+	svc.Annotations[specHashAnnotation] = specHash(svc)
+	return svc
+}
+
+// reconcileHubService creates or updates the workload's Service in the
+// service namespace, unless the name is taken by another workload's.
+func (c *Controller) reconcileHubService(ctx context.Context, key string, wl *workload, cfg *ExposeConfig, target *v1.Service) error {
+	if !c.usesHub(wl.Namespace) {
+		return nil
+	}
+	desired := c.desiredHubService(key, wl, cfg, target)
+	if other, ok := c.hubServiceWorkload(desired.Namespace, desired.Name); ok && other != key {
+		klog.FromContext(ctx).Info("Service in the service namespace belongs to another workload, leaving it alone", "service", desired.Name, "workload", other)
+		c.event(wl, v1.EventTypeWarning, "ServiceNameCollision", "Service %s/%s already belongs to %s", desired.Namespace, desired.Name, other)
+		return nil
+	}
+	return c.reconcileService(ctx, key, wl, desired)
+}
+
+// removeHubService deletes the workload's Service in the service namespace,
+// if it has one there.
+func (c *Controller) removeHubService(ctx context.Context, wl *workload, kind, namespace, name string) error {
+	if !c.usesHub(namespace) {
+		return nil
+	}
+	svcName := c.hubServiceName(namespace, name)
+	if other, ok := c.hubServiceWorkload(c.opts.ServiceNamespace, svcName); ok && other != workloadKey(kind, namespace+"/"+name) {
+		return nil
+	}
+	return c.removeManagedService(ctx, wl, kind, c.opts.ServiceNamespace, name, svcName)
+}
+
+// hubServiceWorkload returns the workload key recorded on the Service in the
+// service namespace, if the Service exists and records one.
+func (c *Controller) hubServiceWorkload(namespace, svcName string) (string, bool) {
+	svc, err := c.serviceLister.Services(namespace).Get(svcName)
+	if errors.IsNotFound(err) || err != nil {
+		return "", false
+	}
+	key, ok := svc.Annotations[hubWorkloadAnnotation]
+	return key, ok
+}
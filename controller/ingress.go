@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// ingressHostAnnotation is the hostname an Ingress named after the Service
+// routes to it.
+const ingressHostAnnotation = annotationPrefix + "ingress-host"
+
+// desiredIngress builds the Ingress routing "/" on the requested host to
+// svc's HTTP port, or returns nil when the Deployment does not request one.
+// The port named "http" is preferred, falling back to the first port.
+func (c *Controller) desiredIngress(cfg *ExposeConfig, svc *v1.Service) *networkingv1.Ingress {
+	if cfg.IngressHost == "" || len(svc.Spec.Ports) == 0 {
+		return nil
+	}
+
+	port := svc.Spec.Ports[0]
+	for _, p := range svc.Spec.Ports {
+		if p.Name == "http" {
+			port = p
+			break
+		}
+	}
+
+	pathType := networkingv1.PathTypePrefix
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svc.Name,
+			Namespace: svc.Namespace,
+			Labels:    map[string]string{controllerLabel: c.opts.ControllerName},
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: cfg.IngressHost,
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{{
+							Path:     "/",
+							PathType: &pathType,
+							Backend: networkingv1.IngressBackend{
+								Service: &networkingv1.IngressServiceBackend{
+									Name: svc.Name,
+									Port: networkingv1.ServiceBackendPort{Number: port.Port},
+								},
+							},
+						}},
+					},
+				},
+			}},
+		},
+	}
+}
+
+func (c *Controller) reconcileIngress(ctx context.Context, cfg *ExposeConfig, svc *v1.Service) error {
+	desired := c.desiredIngress(cfg, svc)
+	if desired == nil {
+		return c.removeIngress(ctx, svc.Namespace, svc.Name)
+	}
+
+	if c.opts.OutputDir != "" {
+		out := desired.DeepCopy()
+		out.APIVersion = "networking.k8s.io/v1"
+		out.Kind = "Ingress"
+		data, err := yaml.Marshal(out)
+		if err != nil {
+			return fmt.Errorf("failed to render ingress %s/%s: %v", svc.Namespace, svc.Name, err)
+		}
+		return c.writeManifest(ctx, c.ingressFilePath(svc.Namespace, svc.Name), data)
+	}
+
+	current, err := c.ingressLister.Ingresses(svc.Namespace).Get(svc.Name)
+	if errors.IsNotFound(err) {
+		klog.FromContext(ctx).Info("Ingress missing, creating", "ingress", svc.Name)
+		if c.opts.DryRun {
+			logDryRun(ctx, "create", "Ingress", svc.Namespace, svc.Name, networkingv1.IngressSpec{}, desired.Spec)
+			return nil
+		}
+		_, err := c.clientset.NetworkingV1().Ingresses(svc.Namespace).Create(
+			ctx,
+			desired,
+			metav1.CreateOptions{},
+		)
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create ingress %s/%s: %v", svc.Namespace, svc.Name, err)
+		}
+		klog.FromContext(ctx).Info("Ingress created", "ingress", svc.Name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get ingress %s/%s: %v", svc.Namespace, svc.Name, err)
+	}
+
+	if current.Labels[controllerLabel] != c.opts.ControllerName {
+		klog.FromContext(ctx).Info("Ingress is not managed by this controller, leaving it alone", "ingress", svc.Name)
+		return nil
+	}
+	if equality.Semantic.DeepEqual(current.Spec.Rules, desired.Spec.Rules) {
+		return nil
+	}
+
+	updated := current.DeepCopy()
+	updated.Spec.Rules = desired.Spec.Rules
+	if c.opts.DryRun {
+		logDryRun(ctx, "update", "Ingress", svc.Namespace, svc.Name, current.Spec, updated.Spec)
+		return nil
+	}
+	_, err = c.clientset.NetworkingV1().Ingresses(svc.Namespace).Update(
+		ctx,
+		updated,
+		metav1.UpdateOptions{},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update ingress %s/%s: %v", svc.Namespace, svc.Name, err)
+	}
+
+	klog.FromContext(ctx).Info("Ingress updated", "ingress", svc.Name)
+	return nil
+}
+
+func (c *Controller) removeIngress(ctx context.Context, namespace, name string) error {
+	if c.opts.OutputDir != "" {
+		return c.deleteManifest(ctx, c.ingressFilePath(namespace, name))
+	}
+
+	current, err := c.ingressLister.Ingresses(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get ingress %s/%s: %v", namespace, name, err)
+	}
+	if current.Labels[controllerLabel] != c.opts.ControllerName {
+		return nil
+	}
+	if c.opts.DryRun {
+		logDryRun(ctx, "delete", "Ingress", namespace, name, current.Spec, networkingv1.IngressSpec{})
+		return nil
+	}
+
+	err = c.clientset.NetworkingV1().Ingresses(namespace).Delete(
+		ctx,
+		name,
+		metav1.DeleteOptions{},
+	)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete ingress %s/%s: %v", namespace, name, err)
+	}
+
+	klog.FromContext(ctx).Info("Ingress deleted", "ingress", name)
+	return nil
+}
+
+func (c *Controller) ingressFilePath(namespace, name string) string {
+	return filepath.Join(c.opts.OutputDir, fmt.Sprintf("%s-%s-ingress.yaml", namespace, name))
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ingress returns the Ingress from the clientset, or nil if it does not
+// exist.
+func (f *fixture) ingress(namespace, name string) *networkingv1.Ingress {
+	f.t.Helper()
+	ing, err := f.client.NetworkingV1().Ingresses(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		f.t.Fatalf("getting ingress %s/%s: %v", namespace, name, err)
+	}
+	return ing
+}
+
+func TestIngressLifecycle(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{Name: "metrics", ContainerPort: 9090}, v1.ContainerPort{Name: "http", ContainerPort: 8080})
+	deploy.Annotations[ingressHostAnnotation] = "web.example.com"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	ing := f.ingress("default", "web-expose")
+	if ing == nil {
+		t.Fatal("ingress was not created")
+	}
+	rule := ing.Spec.Rules[0]
+	backend := rule.HTTP.Paths[0].Backend.Service
+	if rule.Host != "web.example.com" || backend.Name != "web-expose" || backend.Port.Number != 8080 {
+		t.Errorf("ingress routes %s to %s:%d, want web.example.com to web-expose:8080", rule.Host, backend.Name, backend.Port.Number)
+	}
+
+	deploy = f.getDeployment("web")
+	deploy.Annotations[ingressHostAnnotation] = "www.example.com"
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if got := f.ingress("default", "web-expose").Spec.Rules[0].Host; got != "www.example.com" {
+		t.Errorf("host after changing the annotation = %s, want www.example.com", got)
+	}
+
+	deploy = f.getDeployment("web")
+	delete(deploy.Annotations, ingressHostAnnotation)
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if f.ingress("default", "web-expose") != nil {
+		t.Error("ingress outlived the ingress-host annotation")
+	}
+	if f.service("default", "web-expose") == nil {
+		t.Error("service was removed with the ingress")
+	}
+}
+
+func TestIngressRemovedWithDeployment(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Annotations[ingressHostAnnotation] = "web.example.com"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	f.deleteDeployment("default", "web")
+	f.mustSync("default/web")
+	if f.ingress("default", "web-expose") != nil {
+		t.Error("ingress outlived its deployment")
+	}
+}
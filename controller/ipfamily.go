@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ipFamiliesDrifted reports whether svc differs from the IP families and
+// policy desired requests. Services without either are left to API
+// defaults. The primary family of an existing Service is immutable, so a
+// request to change it is not treated as drift; the returned warning says
+// so instead.
+func ipFamiliesDrifted(svc, desired *v1.Service) (bool, string) {
+	if desired.Spec.IPFamilyPolicy == nil {
+		return false, ""
+	}
+	if primaryFamilyChanged(svc, desired) {
+		return false, fmt.Sprintf("primary IP family is %s and cannot change to %s, recreate the Service to apply it",
+			svc.Spec.IPFamilies[0], desired.Spec.IPFamilies[0])
+	}
+	return !reflect.DeepEqual(svc.Spec.IPFamilies, ipFamiliesFor(svc, desired)) ||
+		!reflect.DeepEqual(svc.Spec.IPFamilyPolicy, desired.Spec.IPFamilyPolicy), ""
+}
+
+// applyIPFamilies copies the requested IP families and policy from desired
+// onto svc, keeping the current ones when the primary family would change.
+// Downgrading to SingleStack also drops the secondary cluster IP.
+func applyIPFamilies(svc, desired *v1.Service) {
+	if desired.Spec.IPFamilyPolicy == nil || primaryFamilyChanged(svc, desired) {
+		return
+	}
+	svc.Spec.IPFamilies = ipFamiliesFor(svc, desired)
+	svc.Spec.IPFamilyPolicy = desired.Spec.IPFamilyPolicy
+	if n := len(svc.Spec.IPFamilies); n > 0 && len(svc.Spec.ClusterIPs) > n {
+		svc.Spec.ClusterIPs = svc.Spec.ClusterIPs[:n]
+	}
+}
+
+// ipFamiliesFor returns the IP families svc should end up with: the
+// requested ones, or the current ones when only a policy is requested. A
+// family the API server added for a dual-stack policy is kept, and
+// SingleStack keeps only the primary family.
+func ipFamiliesFor(svc, desired *v1.Service) []v1.IPFamily {
+	families := desired.Spec.IPFamilies
+	if len(families) == 0 {
+		families = svc.Spec.IPFamilies
+	}
+	if *desired.Spec.IPFamilyPolicy == v1.IPFamilyPolicySingleStack {
+		if len(families) > 1 {
+			return families[:1]
+		}
+		return families
+	}
+	if len(families) == 1 && len(svc.Spec.IPFamilies) == 2 && svc.Spec.IPFamilies[0] == families[0] {
+		return svc.Spec.IPFamilies
+	}
+	return families
+}
+
+func primaryFamilyChanged(svc, desired *v1.Service) bool {
+	return len(desired.Spec.IPFamilies) > 0 && len(svc.Spec.IPFamilies) > 0 &&
+		svc.Spec.IPFamilies[0] != desired.Spec.IPFamilies[0]
+}
@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIPFamiliesOrder(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Annotations[ipFamiliesAnnotation] = "IPv6,IPv4"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	svc := f.service("default", "web-expose")
+	if want := []v1.IPFamily{v1.IPv6Protocol, v1.IPv4Protocol}; !slices.Equal(svc.Spec.IPFamilies, want) {
+		t.Errorf("ip families = %v, want %v", svc.Spec.IPFamilies, want)
+	}
+	if p := svc.Spec.IPFamilyPolicy; p == nil || *p != v1.IPFamilyPolicyRequireDualStack {
+		t.Errorf("ip family policy = %v, want RequireDualStack", p)
+	}
+
+	// The primary family of an existing Service cannot change, so the
+	// controller leaves it rather than failing every update.
+	deploy = f.getDeployment("web")
+	deploy.Annotations[ipFamiliesAnnotation] = "IPv4,IPv6"
+	f.updateDeployment(deploy)
+	f.client.ClearActions()
+	f.mustSync("default/web")
+	if verbs := f.serviceActions(); len(verbs) != 0 {
+		t.Errorf("service actions after swapping the primary family = %v, want none", verbs)
+	}
+	if got := f.service("default", "web-expose").Spec.IPFamilies; got[0] != v1.IPv6Protocol {
+		t.Errorf("ip families = %v, want IPv6 kept primary", got)
+	}
+}
+
+func TestIPFamiliesUnsetLeftToDefaults(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+
+	// The API server fills the families in on creation.
+	svc := f.service("default", "web-expose")
+	policy := v1.IPFamilyPolicySingleStack
+	svc.Spec.IPFamilies, svc.Spec.IPFamilyPolicy = []v1.IPFamily{v1.IPv4Protocol}, &policy
+	if _, err := f.client.CoreV1().Services("default").Update(context.Background(), svc, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating service: %v", err)
+	}
+	f.client.ClearActions()
+	f.mustSync("default/web")
+	if verbs := f.serviceActions(); len(verbs) != 0 {
+		t.Errorf("service actions = %v, want defaulted ip families left alone", verbs)
+	}
+}
+
+func TestApplyIPFamilies(t *testing.T) {
+	service := func(policy v1.IPFamilyPolicy, families []v1.IPFamily, clusterIPs ...string) *v1.Service {
+		svc := &v1.Service{Spec: v1.ServiceSpec{IPFamilies: families, ClusterIPs: clusterIPs}}
+		if policy != "" {
+			svc.Spec.IPFamilyPolicy = &policy
+		}
+		return svc
+	}
+	ipv4, dual := []v1.IPFamily{v1.IPv4Protocol}, []v1.IPFamily{v1.IPv4Protocol, v1.IPv6Protocol}
+
+	tests := []struct {
+		name           string
+		current        *v1.Service
+		desired        *v1.Service
+		wantPolicy     v1.IPFamilyPolicy
+		wantFamilies   []v1.IPFamily
+		wantClusterIPs []string
+		wantDrifted    bool
+		wantWarning    bool
+	}{
+		{
+			name:    "nothing requested",
+			current: service(v1.IPFamilyPolicySingleStack, ipv4, "10.0.0.1"), desired: service("", nil),
+			wantPolicy: v1.IPFamilyPolicySingleStack, wantFamilies: ipv4, wantClusterIPs: []string{"10.0.0.1"},
+		},
+		{
+			name:    "prefer dual stack",
+			current: service(v1.IPFamilyPolicySingleStack, ipv4, "10.0.0.1"), desired: service(v1.IPFamilyPolicyPreferDualStack, nil),
+			wantPolicy: v1.IPFamilyPolicyPreferDualStack, wantFamilies: ipv4, wantClusterIPs: []string{"10.0.0.1"}, wantDrifted: true,
+		},
+		{
+			name:    "secondary family added by the api server",
+			current: service(v1.IPFamilyPolicyPreferDualStack, dual, "10.0.0.1", "fd00::1"), desired: service(v1.IPFamilyPolicyPreferDualStack, ipv4),
+			wantPolicy: v1.IPFamilyPolicyPreferDualStack, wantFamilies: dual, wantClusterIPs: []string{"10.0.0.1", "fd00::1"},
+		},
+		{
+			name:    "single stack downgrade",
+			current: service(v1.IPFamilyPolicyRequireDualStack, dual, "10.0.0.1", "fd00::1"), desired: service(v1.IPFamilyPolicySingleStack, nil),
+			wantPolicy: v1.IPFamilyPolicySingleStack, wantFamilies: ipv4, wantClusterIPs: []string{"10.0.0.1"}, wantDrifted: true,
+		},
+		{
+			name:    "primary family change",
+			current: service(v1.IPFamilyPolicySingleStack, []v1.IPFamily{v1.IPv6Protocol}, "fd00::1"), desired: service(v1.IPFamilyPolicyRequireDualStack, dual),
+			wantPolicy: v1.IPFamilyPolicySingleStack, wantFamilies: []v1.IPFamily{v1.IPv6Protocol}, wantClusterIPs: []string{"fd00::1"}, wantWarning: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drifted, warning := ipFamiliesDrifted(tt.current, tt.desired)
+			if drifted != tt.wantDrifted {
+				t.Errorf("drifted = %v, want %v", drifted, tt.wantDrifted)
+			}
+			if (warning != "") != tt.wantWarning {
+				t.Errorf("warning = %q, want one: %v", warning, tt.wantWarning)
+			}
+
+			svc := tt.current.DeepCopy()
+			applyIPFamilies(svc, tt.desired)
+			if p := svc.Spec.IPFamilyPolicy; p == nil || *p != tt.wantPolicy {
+				t.Errorf("ip family policy = %v, want %s", p, tt.wantPolicy)
+			}
+			if !slices.Equal(svc.Spec.IPFamilies, tt.wantFamilies) {
+				t.Errorf("ip families = %v, want %v", svc.Spec.IPFamilies, tt.wantFamilies)
+			}
+			if !slices.Equal(svc.Spec.ClusterIPs, tt.wantClusterIPs) {
+				t.Errorf("cluster IPs = %v, want %v", svc.Spec.ClusterIPs, tt.wantClusterIPs)
+			}
+		})
+	}
+}
+
+func TestIPFamilyPolicyChangeUpdatesService(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+
+	deploy := f.getDeployment("web")
+	deploy.Annotations[ipFamilyPolicyAnnotation] = "PreferDualStack"
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if p := f.service("default", "web-expose").Spec.IPFamilyPolicy; p == nil || *p != v1.IPFamilyPolicyPreferDualStack {
+		t.Errorf("ip family policy after setting the annotation = %v, want PreferDualStack", p)
+	}
+}
@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/klog/v2"
+)
+
+// logCapture collects the lines written through the logger it returns.
+type logCapture struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *logCapture) context() context.Context {
+	logger := funcr.New(func(prefix, args string) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.lines = append(l.lines, args)
+	}, funcr.Options{Verbosity: 4})
+	return klog.NewContext(context.Background(), logger)
+}
+
+// find returns the first line logging msg, or "" if there is none.
+func (l *logCapture) find(msg string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, `"msg"="`+msg+`"`) {
+			return line
+		}
+	}
+	return ""
+}
+
+// count returns the number of lines logging msg.
+func (l *logCapture) count(msg string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, line := range l.lines {
+		if strings.Contains(line, `"msg"="`+msg+`"`) {
+			n++
+		}
+	}
+	return n
+}
+
+func assertFields(t *testing.T, line string, fields ...string) {
+	t.Helper()
+	for _, field := range fields {
+		if !strings.Contains(line, field) {
+			t.Errorf("log line %s lacks %s", line, field)
+		}
+	}
+}
+
+func TestSyncLogsWorkloadFields(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.refresh()
+
+	var logs logCapture
+	if err := f.c.syncHandler(logs.context(), "default/web"); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	line := logs.find("Service missing, creating")
+	if line == "" {
+		t.Fatalf("no service creation logged in %v", logs.lines)
+	}
+	assertFields(t, line, `"kind"="Deployment"`, `"namespace"="default"`, `"name"="web"`, `"service"="web-expose"`)
+}
+
+func TestProcessItemLogsKey(t *testing.T) {
+	f := newFixture(t, Options{})
+	f.immediateRetries()
+	f.c.queue.Add("a/b/c")
+
+	var logs logCapture
+	f.c.processItem(logs.context())
+
+	line := logs.find("Error syncing")
+	if line == "" {
+		t.Fatalf("no sync error logged in %v", logs.lines)
+	}
+	assertFields(t, line, `"key"="a/b/c"`, `"error"=`)
+}
+
+func TestRepeatedSyncErrorsLoggedOnce(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.immediateRetries()
+	f.refresh()
+	failing := true
+	f.client.PrependReactor("create", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failing {
+			return true, nil, fmt.Errorf("exceeded quota")
+		}
+		return false, nil, nil
+	})
+
+	var logs logCapture
+	f.c.queue.Add("default/web")
+	for range 3 {
+		f.c.processItem(logs.context())
+	}
+	if n := logs.count("Error syncing"); n != 1 {
+		t.Errorf("full error logs = %d, want 1", n)
+	}
+	if n := logs.count("Error syncing again"); n != 2 {
+		t.Errorf("terse error logs = %d, want 2", n)
+	}
+	if n := f.c.queue.NumRequeues("default/web"); n != 3 {
+		t.Errorf("requeues = %d, want every error requeued", n)
+	}
+
+	// A success forgets the key, so its next error is logged in full.
+	failing = false
+	f.c.processItem(logs.context())
+	if len(f.c.errLogs) != 0 {
+		t.Errorf("error log state after a success = %v, want none", f.c.errLogs)
+	}
+	failing = true
+	f.c.queue.Add("default/web")
+	f.c.processItem(logs.context())
+	if n := logs.count("Error syncing"); n != 2 {
+		t.Errorf("full error logs after the key was forgotten = %d, want 2", n)
+	}
+}
@@ -0,0 +1,224 @@
+package controller
+
+import (
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// managedByLabel is the well-known label naming the managing tool. Its
+	// value can be overridden per Deployment, so it is informational only.
+	managedByLabel = "app.kubernetes.io/managed-by"
+	// instanceLabel is the well-known label naming the instance; it carries
+	// the controller name so Services of several controllers are told apart.
+	instanceLabel = "app.kubernetes.io/instance"
+	// controllerLabel marks Services created by this controller and holds
+	// its name. Ownership detection relies on it rather than on
+	// managedByLabel.
+	controllerLabel = annotationPrefix + "controller"
+
+	// DefaultControllerName names the controller unless configured
+	// otherwise.
+	DefaultControllerName = "expose-controller"
+
+	// managedLabelsAnnotation lists the label keys the controller set on the
+	// Service, so labels it stops setting can be pruned while labels added
+	// by other tools are left intact.
+	managedLabelsAnnotation = annotationPrefix + "managed-labels"
+	// copiedAnnotationsAnnotation lists the annotation keys copied from the
+	// Deployment, and the scrape annotations, so they can be pruned once no
+	// longer wanted without touching annotations set by others.
+	copiedAnnotationsAnnotation = annotationPrefix + "copied-annotations"
+)
+
+// serviceLabels derives the labels the controller sets on the Service: the
+// Deployment labels matching a copy prefix, then its own labels.
+func (c *Controller) serviceLabels(wl *workload, cfg *ExposeConfig) map[string]string {
+	managedBy := c.opts.ControllerName
+	if cfg.ManagedBy != "" {
+		managedBy = cfg.ManagedBy
+	}
+	out := c.copiedKeys(wl.Labels)
+	out[managedByLabel] = managedBy
+	out[instanceLabel] = c.opts.ControllerName
+	out[controllerLabel] = c.opts.ControllerName
+	return out
+}
+
+// copiedKeys returns the entries of src whose key matches a copy prefix.
+// Keys under the controller's own prefix are never copied, so Deployment
+// configuration cannot overwrite what the controller records on the
+// Service.
+func (c *Controller) copiedKeys(src map[string]string) map[string]string {
+	out := map[string]string{}
+	for k, v := range src {
+		if strings.HasPrefix(k, annotationPrefix) {
+			continue
+		}
+		for _, prefix := range c.opts.CopyPrefixes {
+			if strings.HasPrefix(k, prefix) {
+				out[k] = v
+				break
+			}
+		}
+	}
+	return out
+}
+
+// recordManagedLabels stores the keys of the desired labels in the
+// managed-labels annotation of desired.
+func recordManagedLabels(desired *v1.Service) {
+	keys := make([]string, 0, len(desired.Labels))
+	for k := range desired.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if desired.Annotations == nil {
+		desired.Annotations = map[string]string{}
+	}
+	desired.Annotations[managedLabelsAnnotation] = strings.Join(keys, ",")
+}
+
+// isManagedService reports whether svc was created by this controller.
+func (c *Controller) isManagedService(svc *v1.Service) bool {
+	return svc.Labels[controllerLabel] == c.opts.ControllerName
+}
+
+// ownsService reports whether the controller may modify svc on behalf of
+// wl: it carries the controller label with this controller's name, or no
+// controller label and a controller reference to wl. wl may be nil once
+// the workload has been deleted.
+func (c *Controller) ownsService(svc *v1.Service, wl *workload) bool {
+	if name, ok := svc.Labels[controllerLabel]; ok {
+		return name == c.opts.ControllerName
+	}
+	ref := workloadControllerRef(svc)
+	return ref != nil && wl != nil && ref.UID == wl.UID
+}
+
+// managedLabelKeys lists the labels of svc owned by the controller: the
+// ones it wants now plus the ones it recorded setting before. Services
+// created before labels were recorded fall back to the fixed labels.
+func managedLabelKeys(svc, desired *v1.Service) []string {
+	seen := map[string]bool{}
+	var keys []string
+	add := func(k string) {
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+
+	for k := range desired.Labels {
+		add(k)
+	}
+	if recorded, ok := svc.Annotations[managedLabelsAnnotation]; ok {
+		for _, k := range splitList(recorded) {
+			add(k)
+		}
+	} else {
+		add(managedByLabel)
+		add(controllerLabel)
+	}
+	return keys
+}
+
+// managedAnnotationKeys lists the Service annotations owned by the
+// controller: its fixed annotations plus the ones copied from the
+// Deployment, now or before. They are set from the desired Service and
+// pruned when no longer desired; any other annotation on the Service is
+// left alone.
+func (c *Controller) managedAnnotationKeys(svc, desired *v1.Service) []string {
+	keys := []string{specHashAnnotation, managedLabelsAnnotation, copiedAnnotationsAnnotation, hubWorkloadAnnotation, deleteAfterAnnotation, retainedAnnotation, c.opts.DNSAnnotationKey, c.opts.WeightAnnotationKey}
+	keys = append(keys, splitList(desired.Annotations[copiedAnnotationsAnnotation])...)
+	return append(keys, splitList(svc.Annotations[copiedAnnotationsAnnotation])...)
+}
+
+// metadataDrifted reports whether any managed label or annotation on svc
+// differs from desired.
+func (c *Controller) metadataDrifted(svc, desired *v1.Service) bool {
+	return keysDrifted(svc.Labels, desired.Labels, managedLabelKeys(svc, desired)) ||
+		keysDrifted(svc.Annotations, desired.Annotations, c.managedAnnotationKeys(svc, desired))
+}
+
+// applyMetadata copies the managed labels and annotations from desired onto
+// svc, removing the ones that are no longer desired.
+func (c *Controller) applyMetadata(svc, desired *v1.Service) {
+	svc.Labels = applyKeys(svc.Labels, desired.Labels, managedLabelKeys(svc, desired))
+	svc.Annotations = applyKeys(svc.Annotations, desired.Annotations, c.managedAnnotationKeys(svc, desired))
+}
+
+func keysDrifted(got, want map[string]string, keys []string) bool {
+	for _, k := range keys {
+		g, gOK := got[k]
+		w, wOK := want[k]
+		if gOK != wOK || g != w {
+			return true
+		}
+	}
+	return false
+}
+
+func applyKeys(dst, want map[string]string, keys []string) map[string]string {
+	for _, k := range keys {
+		if v, ok := want[k]; ok {
+			if dst == nil {
+				dst = map[string]string{}
+			}
+			dst[k] = v
+			continue
+		}
+		delete(dst, k)
+	}
+	return dst
+}
+
+// workloadControllerRef returns the controlling Deployment or StatefulSet
+// owner reference of svc, if any.
+func workloadControllerRef(svc *v1.Service) *metav1.OwnerReference {
+	ref := metav1.GetControllerOf(svc)
+	if ref == nil || !isWorkloadKind(ref.Kind) {
+		return nil
+	}
+	return ref
+}
+
+// ownedByOtherWorkload reports whether svc is controlled by a workload
+// other than the kind's object named name. A reference to a previous object
+// of the same kind and name does not count, so a recreated workload adopts
+// its Service.
+func ownedByOtherWorkload(svc *v1.Service, kind, name string) bool {
+	ref := workloadControllerRef(svc)
+	return ref != nil && (ref.Kind != kind || ref.Name != name)
+}
+
+// ownerDrifted reports whether the controlling workload reference of svc
+// differs from the one desired, including desired having none because the
+// Service is retained on delete.
+func ownerDrifted(svc, desired *v1.Service) bool {
+	got, want := workloadControllerRef(svc), workloadControllerRef(desired)
+	if got == nil || want == nil {
+		return (got == nil) != (want == nil)
+	}
+	return got.UID != want.UID
+}
+
+// applyOwner replaces the controlling workload reference of svc with the
+// desired one, keeping any other owner references.
+func applyOwner(svc, desired *v1.Service) {
+	var refs []metav1.OwnerReference
+	for _, ref := range svc.OwnerReferences {
+		if isWorkloadKind(ref.Kind) && ref.Controller != nil && *ref.Controller {
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	if want := workloadControllerRef(desired); want != nil {
+		refs = append(refs, *want)
+	}
+	svc.OwnerReferences = refs
+}
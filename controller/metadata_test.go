@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDNSHostnameAnnotation(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		key  string
+	}{
+		{name: "default key", key: DefaultDNSAnnotationKey},
+		{name: "configured key", opts: Options{DNSAnnotationKey: "example.com/hostname"}, key: "example.com/hostname"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+			deploy.Annotations[dnsHostnameAnnotation] = "web.example.com"
+			f := newFixture(t, tt.opts, deploy)
+			f.mustSync("default/web")
+
+			if got := f.service("default", "web-expose").Annotations[tt.key]; got != "web.example.com" {
+				t.Errorf("annotation %s = %q, want web.example.com", tt.key, got)
+			}
+
+			deploy = f.getDeployment("web")
+			delete(deploy.Annotations, dnsHostnameAnnotation)
+			f.updateDeployment(deploy)
+			f.mustSync("default/web")
+			if got, ok := f.service("default", "web-expose").Annotations[tt.key]; ok {
+				t.Errorf("annotation %s = %q after the hostname was removed, want none", tt.key, got)
+			}
+		})
+	}
+}
+
+func TestManagedByOverride(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Annotations[managedByOverrideAnnotation] = "platform-team"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	svc := f.service("default", "web-expose")
+	if got := svc.Labels[managedByLabel]; got != "platform-team" {
+		t.Errorf("managed-by = %q, want platform-team", got)
+	}
+	// Ownership rests on the controller label, which the override leaves.
+	if !f.c.isManagedService(svc) {
+		t.Error("service with an overridden managed-by label is not managed")
+	}
+
+	deploy = f.getDeployment("web")
+	delete(deploy.Annotations, managedByOverrideAnnotation)
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if got := f.service("default", "web-expose").Labels[managedByLabel]; got != DefaultControllerName {
+		t.Errorf("managed-by after removing the override = %q, want %s", got, DefaultControllerName)
+	}
+}
+
+func TestWeightAnnotation(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   Options
+		key    string
+		weight string
+		want   string
+	}{
+		{name: "default key", key: DefaultWeightAnnotationKey, weight: "10", want: "10"},
+		{name: "configured key", opts: Options{WeightAnnotationKey: "example.com/weight"}, key: "example.com/weight", weight: "10", want: "10"},
+		{name: "invalid weight", key: DefaultWeightAnnotationKey, weight: "-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+			deploy.Annotations[weightAnnotation] = tt.weight
+			f := newFixture(t, tt.opts, deploy)
+			f.mustSync("default/web")
+
+			if got := f.service("default", "web-expose").Annotations[tt.key]; got != tt.want {
+				t.Errorf("annotation %s = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForeignServiceLabelsPreserved(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+
+	svc := f.service("default", "web-expose")
+	svc.Labels["team"] = "payments"
+	svc.Annotations["example.com/owner"] = "payments"
+	if _, err := f.client.CoreV1().Services("default").Update(context.Background(), svc, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating service: %v", err)
+	}
+
+	// A change of the Deployment makes the controller update the Service.
+	deploy := f.getDeployment("web")
+	deploy.Annotations[managedByOverrideAnnotation] = "platform-team"
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+
+	svc = f.service("default", "web-expose")
+	if got := svc.Labels[managedByLabel]; got != "platform-team" {
+		t.Fatalf("managed-by = %q, want the service updated to platform-team", got)
+	}
+	if got := svc.Labels["team"]; got != "payments" {
+		t.Errorf("label team = %q, want it kept", got)
+	}
+	if got := svc.Annotations["example.com/owner"]; got != "payments" {
+		t.Errorf("annotation example.com/owner = %q, want it kept", got)
+	}
+}
+
+func TestCopyPrefixes(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Labels = map[string]string{"cost-center": "42", "team": "payments", annotationPrefix + "internal": "x"}
+	deploy.Annotations["mesh.example.com/inject"] = "true"
+	deploy.Annotations["notes"] = "not copied"
+	f := newFixture(t, Options{CopyPrefixes: []string{"cost-", "mesh.example.com/", annotationPrefix}}, deploy)
+	f.mustSync("default/web")
+
+	svc := f.service("default", "web-expose")
+	if got := svc.Labels["cost-center"]; got != "42" {
+		t.Errorf("label cost-center = %q, want 42", got)
+	}
+	if got := svc.Labels[managedByLabel]; got != DefaultControllerName {
+		t.Errorf("managed-by = %q, want %s", got, DefaultControllerName)
+	}
+	if got := svc.Annotations["mesh.example.com/inject"]; got != "true" {
+		t.Errorf("annotation mesh.example.com/inject = %q, want true", got)
+	}
+	for _, k := range []string{"team", annotationPrefix + "internal"} {
+		if _, ok := svc.Labels[k]; ok {
+			t.Errorf("label %s was copied", k)
+		}
+	}
+	if _, ok := svc.Annotations["notes"]; ok {
+		t.Error("annotation notes was copied")
+	}
+
+	// Drift of a copied label is repaired, and a label no longer on the
+	// Deployment is pruned.
+	svc.Labels["cost-center"] = "7"
+	if _, err := f.client.CoreV1().Services("default").Update(context.Background(), svc, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating service: %v", err)
+	}
+	f.mustSync("default/web")
+	if got := f.service("default", "web-expose").Labels["cost-center"]; got != "42" {
+		t.Errorf("label cost-center after drift = %q, want 42", got)
+	}
+
+	deploy = f.getDeployment("web")
+	delete(deploy.Labels, "cost-center")
+	delete(deploy.Annotations, "mesh.example.com/inject")
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	svc = f.service("default", "web-expose")
+	if _, ok := svc.Labels["cost-center"]; ok {
+		t.Error("label cost-center outlived the deployment's")
+	}
+	if _, ok := svc.Annotations["mesh.example.com/inject"]; ok {
+		t.Error("annotation mesh.example.com/inject outlived the deployment's")
+	}
+}
+
+func TestControllersIgnoreEachOthersServices(t *testing.T) {
+	b := newFixture(t, Options{ControllerName: "team-b"}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	b.mustSync("default/web")
+	svc := b.service("default", "web-expose")
+	if svc == nil {
+		t.Fatal("service was not created")
+	}
+	for _, label := range []string{managedByLabel, instanceLabel, controllerLabel} {
+		if got := svc.Labels[label]; got != "team-b" {
+			t.Errorf("label %s = %q, want team-b", label, got)
+		}
+	}
+
+	// Controller A sees the same cluster, with the Deployment since changed.
+	deploy := b.getDeployment("web")
+	deploy.Spec.Template.Spec.Containers[0].Ports[0].ContainerPort = 9090
+	a := newFixture(t, Options{ControllerName: "team-a"}, deploy, svc)
+	a.mustSync("default/web")
+	if verbs := a.serviceActions(); len(verbs) != 0 {
+		t.Errorf("service actions of controller a = %v, want none", verbs)
+	}
+	if !hasEvent(a.events(), v1.EventTypeWarning, "ServiceConflict") {
+		t.Error("no ServiceConflict event")
+	}
+
+	a.deleteDeployment("default", "web")
+	a.mustSync("default/web")
+	if a.service("default", "web-expose") == nil {
+		t.Error("controller a deleted the service of controller b")
+	}
+}
+
+func TestScrapeAnnotations(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{Name: "http", ContainerPort: 8080}, v1.ContainerPort{Name: "metrics", ContainerPort: 9100})
+	deploy.Annotations[scrapeAnnotation] = "true"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	want := map[string]string{
+		prometheusScrapeAnnotation: "true",
+		prometheusPortAnnotation:   "9100",
+		prometheusPathAnnotation:   defaultScrapePath,
+	}
+	annotations := f.service("default", "web-expose").Annotations
+	for k, v := range want {
+		if got := annotations[k]; got != v {
+			t.Errorf("annotation %s = %q, want %q", k, got, v)
+		}
+	}
+
+	deploy = f.getDeployment("web")
+	deploy.Annotations[scrapePathAnnotation] = "/stats"
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if got := f.service("default", "web-expose").Annotations[prometheusPathAnnotation]; got != "/stats" {
+		t.Errorf("scrape path after changing the annotation = %q, want /stats", got)
+	}
+
+	deploy = f.getDeployment("web")
+	delete(deploy.Annotations, scrapeAnnotation)
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	annotations = f.service("default", "web-expose").Annotations
+	for k := range want {
+		if _, ok := annotations[k]; ok {
+			t.Errorf("annotation %s kept after opting out of scraping", k)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Registry holds the controller's metrics. It is served by main.
+var Registry = prometheus.NewRegistry()
+
+var nonRetryableErrorsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "expose_nonretryable_errors_total",
+		Help: "Reconcile errors that were dropped instead of retried, by namespace and reason.",
+	},
+	[]string{"namespace", "reason"},
+)
+
+var keyRetries = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "expose_key_retries",
+		Help: "Requeues of workload keys that are retrying above the configured threshold, by workload kind.",
+	},
+	[]string{"kind", "namespace", "name"},
+)
+
+var stuckKeys = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "expose_stuck_keys",
+		Help: "Deployment keys requeued more often than the configured threshold.",
+	},
+)
+
+var requeueTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "expose_requeue_total",
+		Help: "Deployment keys requeued with backoff after a failed reconcile, by namespace.",
+	},
+	[]string{"namespace"},
+)
+
+var reconcileTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "expose_reconcile_total",
+		Help: "Reconciles of Deployment keys, by namespace and result.",
+	},
+	[]string{"namespace", "result"},
+)
+
+var reconcileDuration = prometheus.NewHistogram(
+	prometheus.HistogramOpts{
+		Name:    "expose_reconcile_duration_seconds",
+		Help:    "Time spent reconciling a Deployment key.",
+		Buckets: prometheus.DefBuckets,
+	},
+)
+
+var queueDepth = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "expose_queue_depth",
+		Help: "Deployment keys waiting in the workqueue.",
+	},
+)
+
+func init() {
+	Registry.MustRegister(nonRetryableErrorsTotal, keyRetries, stuckKeys, requeueTotal, reconcileTotal, reconcileDuration, queueDepth)
+}
+
+// recordReconcile counts a finished reconcile of key by namespace and
+// result. The namespace label adds a series per namespace holding exposed
+// Deployments, which stays bounded by the cluster's namespaces.
+func recordReconcile(key string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	reconcileTotal.WithLabelValues(keyNamespace(key), result).Inc()
+}
+
+// recordNonRetryable counts a reconcile error of key that is not retried.
+func recordNonRetryable(key, reason string) {
+	nonRetryableErrorsTotal.WithLabelValues(keyNamespace(key), reason).Inc()
+}
+
+// keyNamespace returns the namespace part of a queue key.
+func keyNamespace(key string) string {
+	namespace, _, _ := splitQueueKey(key)
+	return namespace
+}
+
+// recordRequeue counts a backoff requeue of key.
+func recordRequeue(key string) {
+	requeueTotal.WithLabelValues(keyNamespace(key)).Inc()
+}
+
+// recordRetries exports the key's requeue count while it is above the
+// retry threshold and drops the series otherwise. The number of such keys
+// is exported in expose_stuck_keys.
+func (c *Controller) recordRetries(key string) {
+	kind, objKey := splitWorkloadKey(key)
+	namespace, name, err := cache.SplitMetaNamespaceKey(objKey)
+	if err != nil {
+		return
+	}
+
+	retries := c.queue.NumRequeues(key)
+	stuck := retries > c.opts.RetryMetricThreshold
+
+	c.stuckMu.Lock()
+	if stuck {
+		c.stuck[key] = true
+	} else {
+		delete(c.stuck, key)
+	}
+	stuckKeys.Set(float64(len(c.stuck)))
+	c.stuckMu.Unlock()
+
+	if !stuck {
+		keyRetries.DeleteLabelValues(kind, namespace, name)
+		return
+	}
+	keyRetries.WithLabelValues(kind, namespace, name).Set(float64(retries))
+}
@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestKeyRetriesByKind(t *testing.T) {
+	keyRetries.Reset()
+	t.Cleanup(keyRetries.Reset)
+	f := newFixture(t, Options{WatchStatefulSets: true})
+	f.immediateRetries()
+
+	deployKey, stsKey := "default/web", workloadKey(statefulSetKind, "default/web")
+	for range 2 {
+		f.c.queue.AddRateLimited(deployKey)
+		f.c.recordRetries(deployKey)
+	}
+	f.c.queue.AddRateLimited(stsKey)
+	f.c.recordRetries(stsKey)
+
+	if got := testutil.ToFloat64(keyRetries.WithLabelValues(deploymentKind, "default", "web")); got != 2 {
+		t.Errorf("deployment retries = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(keyRetries.WithLabelValues(statefulSetKind, "default", "web")); got != 1 {
+		t.Errorf("statefulset retries = %v, want 1", got)
+	}
+
+	f.c.forget(deployKey)
+	if n := testutil.CollectAndCount(keyRetries); n != 1 {
+		t.Errorf("series after the deployment succeeded = %d, want only the statefulset's", n)
+	}
+	if n := len(f.c.stuck); n != 1 {
+		t.Errorf("stuck keys = %d, want 1", n)
+	}
+}
+
+func TestKeyRetriesThreshold(t *testing.T) {
+	keyRetries.Reset()
+	t.Cleanup(keyRetries.Reset)
+	f := newFixture(t, Options{RetryMetricThreshold: 1}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.immediateRetries()
+	f.refresh()
+	failing := true
+	f.client.PrependReactor("create", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failing {
+			return true, nil, fmt.Errorf("create failed")
+		}
+		return false, nil, nil
+	})
+
+	retries := func() float64 {
+		if testutil.CollectAndCount(keyRetries) == 0 {
+			return 0
+		}
+		return testutil.ToFloat64(keyRetries.WithLabelValues(deploymentKind, "default", "web"))
+	}
+	f.c.queue.Add("default/web")
+	f.c.processItem(context.Background())
+	if got := retries(); got != 0 {
+		t.Errorf("retries at the threshold = %v, want the key not exported", got)
+	}
+	f.c.processItem(context.Background())
+	if got := retries(); got != 2 {
+		t.Errorf("retries above the threshold = %v, want 2", got)
+	}
+
+	failing = false
+	f.c.processItem(context.Background())
+	if n := testutil.CollectAndCount(keyRetries); n != 0 {
+		t.Errorf("series after a successful reconcile = %d, want 0", n)
+	}
+}
+
+func TestReconcileMetrics(t *testing.T) {
+	reconcileTotal.Reset()
+	t.Cleanup(reconcileTotal.Reset)
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.immediateRetries()
+	f.refresh()
+	durations := histogramCount(t)
+
+	f.c.queue.Add("default/web")
+	f.c.processItem(context.Background())
+	// A key that cannot be split fails its reconcile.
+	f.c.queue.Add("a/b/c")
+	f.c.processItem(context.Background())
+
+	if got := testutil.ToFloat64(reconcileTotal.WithLabelValues("default", "success")); got != 1 {
+		t.Errorf("successful reconciles = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(reconcileTotal.WithLabelValues("", "error")); got != 1 {
+		t.Errorf("failed reconciles = %v, want 1", got)
+	}
+	if got := histogramCount(t) - durations; got != 2 {
+		t.Errorf("observed reconcile durations = %d, want 2", got)
+	}
+	if n, err := testutil.GatherAndCount(Registry, "expose_reconcile_total", "expose_queue_depth"); err != nil || n != 3 {
+		t.Errorf("scraped series = %d, %v, want 3", n, err)
+	}
+}
+
+// histogramCount scrapes the number of reconcile durations observed.
+func histogramCount(t *testing.T) uint64 {
+	t.Helper()
+	families, err := Registry.Gather()
+	if err != nil {
+		t.Fatalf("gathering metrics: %v", err)
+	}
+	for _, mf := range families {
+		if mf.GetName() == "expose_reconcile_duration_seconds" {
+			return mf.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	return 0
+}
+
+func TestReconcileMetricsByNamespace(t *testing.T) {
+	reconcileTotal.Reset()
+	requeueTotal.Reset()
+	t.Cleanup(reconcileTotal.Reset)
+	t.Cleanup(requeueTotal.Reset)
+	teamA := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	teamA.Namespace = "team-a"
+	teamB := teamA.DeepCopy()
+	teamB.Namespace = "team-b"
+	f := newFixture(t, Options{}, teamA, teamB)
+	f.immediateRetries()
+	f.refresh()
+	f.client.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "team-b" {
+			return true, nil, fmt.Errorf("create failed")
+		}
+		return false, nil, nil
+	})
+
+	for _, key := range []string{"team-a/web", "team-b/web"} {
+		f.c.queue.Add(key)
+		f.c.processItem(context.Background())
+	}
+
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{name: "team-a successes", got: testutil.ToFloat64(reconcileTotal.WithLabelValues("team-a", "success")), want: 1},
+		{name: "team-b errors", got: testutil.ToFloat64(reconcileTotal.WithLabelValues("team-b", "error")), want: 1},
+		{name: "team-b requeues", got: testutil.ToFloat64(requeueTotal.WithLabelValues("team-b")), want: 1},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if n := testutil.CollectAndCount(reconcileTotal); n != 2 {
+		t.Errorf("reconcile series = %d, want one per namespace", n)
+	}
+	if n := testutil.CollectAndCount(requeueTotal); n != 1 {
+		t.Errorf("requeue series = %d, want only team-b's", n)
+	}
+}
+
+func TestStuckKeysGauge(t *testing.T) {
+	keyRetries.Reset()
+	requeueTotal.Reset()
+	stuckKeys.Set(0)
+	t.Cleanup(func() {
+		keyRetries.Reset()
+		requeueTotal.Reset()
+		stuckKeys.Set(0)
+	})
+	f := newFixture(t, Options{RetryMetricThreshold: 2}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.immediateRetries()
+	f.refresh()
+	failing := true
+	f.client.PrependReactor("create", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failing {
+			return true, nil, fmt.Errorf("create failed")
+		}
+		return false, nil, nil
+	})
+
+	f.c.queue.Add("default/web")
+	for i := 1; i <= 4; i++ {
+		f.c.processItem(context.Background())
+		want := 0.0
+		if i > 2 {
+			want = 1
+		}
+		if got := testutil.ToFloat64(stuckKeys); got != want {
+			t.Errorf("stuck keys after %d failures = %v, want %v", i, got, want)
+		}
+	}
+	if got := testutil.ToFloat64(requeueTotal.WithLabelValues("default")); got != 4 {
+		t.Errorf("requeues = %v, want 4", got)
+	}
+
+	failing = false
+	f.c.processItem(context.Background())
+	if got := testutil.ToFloat64(stuckKeys); got != 0 {
+		t.Errorf("stuck keys after a successful reconcile = %v, want 0", got)
+	}
+}
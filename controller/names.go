@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// nameHashLength is the number of hex characters of the Deployment name's
+// hash kept in a truncated Service name.
+const nameHashLength = 8
+
+// DefaultServiceSuffix is appended to a Deployment's name to name its
+// Service.
+const DefaultServiceSuffix = exposeSuffix
+
+// digitPrefix is put in front of a Deployment name starting with a digit,
+// since a DNS-1035 label must start with a letter.
+const digitPrefix = "x"
+
+// serviceName joins a Deployment name and a suffix into a Service name.
+// Deployment names are DNS-1123 subdomains, so the result is not always a
+// valid DNS-1035 label: such names have their dots replaced, get a letter in
+// front of a leading digit and are truncated to fit, and a hash of the
+// Deployment name is appended so distinct Deployments keep distinct
+// Services.
+func serviceName(deployName, suffix string) string {
+	name := deployName + suffix
+	if len(validation.IsDNS1035Label(name)) == 0 {
+		return name
+	}
+
+	base := strings.ReplaceAll(deployName, ".", "-")
+	if base != "" && base[0] >= '0' && base[0] <= '9' {
+		base = digitPrefix + base
+	}
+	hash := nameHash(deployName)
+	if keep := validation.DNS1035LabelMaxLength - len(suffix) - len(hash) - 1; len(base) > keep {
+		base = base[:keep]
+	}
+	return strings.TrimRight(base, "-") + "-" + hash + suffix
+}
+
+// nameHash returns the hash of a Deployment name kept in a shortened
+// Service name.
+func nameHash(deployName string) string {
+	sum := sha256.Sum256([]byte(deployName))
+	return hex.EncodeToString(sum[:])[:nameHashLength]
+}
+
+// ValidateServiceSuffix checks that a suffix leaves room for a truncated
+// Deployment name and its hash, cannot collide with the "-internal"
+// Service, and ends a name in a valid DNS-1035 label.
+func ValidateServiceSuffix(suffix string) error {
+	if suffix == internalSuffix {
+		return fmt.Errorf("service suffix %q is reserved for the internal Service", suffix)
+	}
+	if limit := validation.DNS1035LabelMaxLength - nameHashLength - 2; len(suffix) > limit {
+		return fmt.Errorf("service suffix %q is longer than %d characters", suffix, limit)
+	}
+	if errs := validation.IsDNS1035Label(digitPrefix + suffix); len(errs) > 0 {
+		return fmt.Errorf("service suffix %q does not end a valid DNS-1035 label: %s", suffix, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// exposeName is the name of the Service generated for a Deployment.
+func (c *Controller) exposeName(deployName string) string {
+	return serviceName(deployName, c.opts.ServiceSuffix)
+}
+
+// internalName is the name of the ClusterIP Service a Deployment gets in
+// dual mode.
+func (c *Controller) internalName(deployName string) string {
+	return serviceName(deployName, internalSuffix)
+}
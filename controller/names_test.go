@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestServiceName(t *testing.T) {
+	long := strings.Repeat("a", 60)
+	tests := []struct {
+		name   string
+		deploy string
+		suffix string
+		want   string
+	}{
+		{name: "short name is joined", deploy: "web", suffix: "-expose", want: "web-expose"},
+		{name: "custom suffix", deploy: "web", suffix: "svc", want: "websvc"},
+		{name: "long name is truncated and hashed", deploy: long, suffix: "-expose", want: strings.Repeat("a", 47) + "-" + nameHash(long) + "-expose"},
+		{name: "name with dots", deploy: "web.v2", suffix: "-expose", want: "web-v2-" + nameHash("web.v2") + "-expose"},
+		{name: "name starting with a digit", deploy: "1web", suffix: "-expose", want: "x1web-" + nameHash("1web") + "-expose"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := serviceName(tt.deploy, tt.suffix)
+			if got != tt.want {
+				t.Errorf("serviceName(%q, %q) = %q, want %q", tt.deploy, tt.suffix, got, tt.want)
+			}
+			if errs := validation.IsDNS1035Label(got); len(errs) > 0 {
+				t.Errorf("serviceName(%q, %q) = %q is not a DNS-1035 label: %v", tt.deploy, tt.suffix, got, errs)
+			}
+		})
+	}
+}
+
+func TestServiceNameKeepsTruncatedNamesDistinct(t *testing.T) {
+	a := serviceName(strings.Repeat("a", 60)+"-one", DefaultServiceSuffix)
+	b := serviceName(strings.Repeat("a", 60)+"-two", DefaultServiceSuffix)
+	if a == b {
+		t.Errorf("distinct deployments map to the same service name %q", a)
+	}
+	if dotted, dashed := serviceName("web.v2", DefaultServiceSuffix), serviceName("web-v2", DefaultServiceSuffix); dotted == dashed {
+		t.Errorf("web.v2 and web-v2 map to the same service name %q", dotted)
+	}
+}
+
+func TestValidateServiceSuffix(t *testing.T) {
+	tests := []struct {
+		suffix  string
+		wantErr bool
+	}{
+		{suffix: "-expose"},
+		{suffix: "svc"},
+		{suffix: "-v2"},
+		{suffix: internalSuffix, wantErr: true},
+		{suffix: ".expose", wantErr: true},
+		{suffix: "-Expose", wantErr: true},
+		{suffix: "-expose-", wantErr: true},
+		{suffix: "-" + strings.Repeat("a", 60), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.suffix, func(t *testing.T) {
+			if err := ValidateServiceSuffix(tt.suffix); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateServiceSuffix(%q) error = %v, want error %v", tt.suffix, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// namespaceOptedIn reports whether Deployments in namespace may be exposed.
+// Every namespace is opted in unless namespace opt-in is enabled.
+func (c *Controller) namespaceOptedIn(namespace string) (bool, error) {
+	if c.namespaceLister == nil {
+		return true, nil
+	}
+
+	ns, err := c.namespaceLister.Get(namespace)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get namespace %s: %v", namespace, err)
+	}
+	return ns.Annotations[enabledAnnotation] == "true", nil
+}
+
+// EnqueueNamespace enqueues every Deployment in namespace, e.g. after the
+// namespace's opt-in annotation changed.
+func (c *Controller) EnqueueNamespace(namespace string) {
+	c.enqueueWorkloads(namespace)
+}
+
+// EnqueueAll enqueues every Deployment, and StatefulSet if watched, the
+// controller sees.
+func (c *Controller) EnqueueAll() {
+	c.enqueueWorkloads(metav1.NamespaceAll)
+}
+
+func (c *Controller) enqueueWorkloads(namespace string) {
+	c.enqueueDeployments(namespace)
+	if c.statefulSetLister != nil {
+		c.enqueueStatefulSets(namespace)
+	}
+}
+
+func (c *Controller) enqueueStatefulSets(namespace string) {
+	sets, err := c.statefulSetLister.StatefulSets(namespace).List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "Error listing statefulsets", "namespace", namespace)
+		return
+	}
+	for _, sts := range sets {
+		key, err := cache.MetaNamespaceKeyFunc(sts)
+		if err != nil {
+			klog.ErrorS(err, "Error creating key")
+			continue
+		}
+		c.EnqueueKey(workloadKey(statefulSetKind, key))
+	}
+}
+
+func (c *Controller) enqueueDeployments(namespace string) {
+	deploys, err := c.deployLister.Deployments(namespace).List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "Error listing deployments", "namespace", namespace)
+		return
+	}
+	for _, wl := range deploys {
+		key, err := cache.MetaNamespaceKeyFunc(wl)
+		if err != nil {
+			klog.ErrorS(err, "Error creating key")
+			continue
+		}
+		c.EnqueueKey(key)
+	}
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNamespaceOptIn(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        bool
+	}{
+		{name: "opted in", annotations: map[string]string{enabledAnnotation: "true"}, want: true},
+		{name: "not annotated"},
+		{name: "opted out", annotations: map[string]string{enabledAnnotation: "false"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Annotations: tt.annotations}}
+			f := newFixture(t, Options{NamespaceOptIn: true}, ns, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+			f.mustSync("default/web")
+
+			if got := f.service("default", "web-expose") != nil; got != tt.want {
+				t.Errorf("service exists = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamespaceOptOutRemovesService(t *testing.T) {
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Annotations: map[string]string{enabledAnnotation: "true"}}}
+	f := newFixture(t, Options{NamespaceOptIn: true}, ns, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") == nil {
+		t.Fatal("service was not created in an opted-in namespace")
+	}
+
+	ns.Annotations = nil
+	if _, err := f.client.CoreV1().Namespaces().Update(context.Background(), ns, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating namespace: %v", err)
+	}
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") != nil {
+		t.Error("service outlived the namespace opt-in")
+	}
+}
+
+func TestExcludeNamespaces(t *testing.T) {
+	system := newDeployment("dns", v1.ContainerPort{ContainerPort: 53})
+	system.Namespace = "kube-system"
+	f := newFixture(t, Options{ExcludeNamespaces: map[string]bool{"kube-system": true}}, system, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+
+	f.mustSync("kube-system/dns")
+	f.mustSync("default/web")
+	if f.service("kube-system", "dns-expose") != nil {
+		t.Error("deployment in an excluded namespace was exposed")
+	}
+	if f.service("default", "web-expose") == nil {
+		t.Error("deployment in an allowed namespace was not exposed")
+	}
+}
+
+func TestExcludedNamespaceRemovesService(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	f.c.opts.ExcludeNamespaces = map[string]bool{"default": true}
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") != nil {
+		t.Error("service outlived its namespace being excluded")
+	}
+}
@@ -0,0 +1,202 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// deleteAfterAnnotation records on the Service of a deleted Deployment when
+// the orphan delete delay expires, so a restarted controller or a new
+// leader keeps the original deadline. A reconcile of the Deployment, once
+// it reappears, prunes it.
+const deleteAfterAnnotation = annotationPrefix + "delete-after"
+
+// deferOrphanCleanup reports whether cleanup of a deleted Deployment's
+// Service svcName must wait for the orphan delete delay. The first call for
+// a key records the deadline on the Service and requeues the key for when
+// it expires; the Deployment reappearing in the meantime cancels the
+// pending deletion. There is nothing to wait for without a managed Service.
+func (c *Controller) deferOrphanCleanup(ctx context.Context, key, namespace, svcName string) (bool, error) {
+	if c.opts.OrphanDeleteDelay <= 0 {
+		return false, nil
+	}
+
+	deadline, err := c.orphanDeadline(ctx, key, namespace, svcName)
+	if err != nil || deadline.IsZero() {
+		return false, err
+	}
+	if remaining := time.Until(deadline); remaining > 0 {
+		c.queue.AddAfter(key, remaining)
+		return true, nil
+	}
+
+	c.orphanMu.Lock()
+	delete(c.orphanDeadlines, key)
+	c.orphanMu.Unlock()
+	return false, nil
+}
+
+// orphanDeadline returns when the Service of a deleted Deployment may be
+// removed: the deadline remembered for key or recorded on the Service, or
+// else a new one, which it records on both. It returns the zero time when
+// the controller has no Service to remove.
+func (c *Controller) orphanDeadline(ctx context.Context, key, namespace, svcName string) (time.Time, error) {
+	c.orphanMu.Lock()
+	deadline, ok := c.orphanDeadlines[key]
+	c.orphanMu.Unlock()
+	if ok {
+		return deadline, nil
+	}
+
+	svc, err := c.serviceLister.Services(namespace).Get(svcName)
+	if errors.IsNotFound(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get service %s/%s: %v", namespace, svcName, err)
+	}
+	if !c.ownsService(svc, nil) {
+		return time.Time{}, nil
+	}
+
+	if recorded, err := time.Parse(time.RFC3339, svc.Annotations[deleteAfterAnnotation]); err == nil {
+		deadline = recorded
+	} else {
+		deadline = time.Now().Add(c.opts.OrphanDeleteDelay).Truncate(time.Second)
+		if err := c.recordDeleteAfter(ctx, svc, deadline); err != nil {
+			return time.Time{}, err
+		}
+		klog.FromContext(ctx).Info("Deployment deleted, deferring service cleanup", "service", svcName, "deleteAfter", deadline)
+	}
+
+	c.orphanMu.Lock()
+	c.orphanDeadlines[key] = deadline
+	c.orphanMu.Unlock()
+	return deadline, nil
+}
+
+// recordDeleteAfter stores deadline in the delete-after annotation of svc.
+func (c *Controller) recordDeleteAfter(ctx context.Context, svc *v1.Service, deadline time.Time) error {
+	value := deadline.UTC().Format(time.RFC3339)
+	if c.opts.OutputDir != "" {
+		return nil
+	}
+	if c.opts.DryRun {
+		logDryRun(ctx, "annotate", "Service", svc.Namespace, svc.Name, svc.Annotations[deleteAfterAnnotation], value)
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{deleteAfterAnnotation: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.clientset.CoreV1().Services(svc.Namespace).Patch(ctx, svc.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to record deletion deadline on service %s/%s: %v", svc.Namespace, svc.Name, err)
+	}
+	return nil
+}
+
+// cancelOrphanCleanup drops a pending deletion of the Service svcName of a
+// Deployment that exists again. The deadline recorded on the Service is
+// pruned by the reconcile that follows.
+func (c *Controller) cancelOrphanCleanup(ctx context.Context, key, namespace, svcName string) {
+	c.orphanMu.Lock()
+	_, pending := c.orphanDeadlines[key]
+	delete(c.orphanDeadlines, key)
+	c.orphanMu.Unlock()
+
+	if svc, err := c.serviceLister.Services(namespace).Get(svcName); err == nil {
+		if _, recorded := svc.Annotations[deleteAfterAnnotation]; recorded {
+			pending = true
+		}
+	}
+	if pending {
+		klog.FromContext(ctx).Info("Deployment reappeared, cancelling pending service deletion", "service", svcName)
+	}
+}
+
+// retainedAnnotation marks the Service of a Deployment asking to retain it
+// on delete. The Deployment's own annotation can no longer be read once it
+// is gone, so the decision is kept on the Service, where it also survives a
+// controller restart.
+const retainedAnnotation = annotationPrefix + "retained"
+
+// retainedService reports whether the Service svcName of a deleted
+// Deployment is marked to be retained.
+func (c *Controller) retainedService(namespace, svcName string) bool {
+	svc, err := c.serviceLister.Services(namespace).Get(svcName)
+	return err == nil && svc.Annotations[retainedAnnotation] == "true"
+}
+
+// retainServices keeps the Services of a deleted Deployment, dropping only
+// their owner references to it so they are cleanly orphaned. The Ingress
+// and HTTPRoute are still removed.
+func (c *Controller) retainServices(ctx context.Context, kind, namespace, name string) error {
+	svcNames := []string{c.exposeName(name), c.internalName(name)}
+	if kind == statefulSetKind {
+		perPod, err := c.perPodServiceNames(namespace, name)
+		if err != nil {
+			return err
+		}
+		svcNames = append(svcNames, perPod...)
+	}
+	for _, svcName := range svcNames {
+		if err := c.orphanService(ctx, namespace, svcName, kind, name); err != nil {
+			return err
+		}
+	}
+	if err := c.removeIngress(ctx, namespace, c.exposeName(name)); err != nil {
+		return err
+	}
+	return c.removeHTTPRoute(ctx, namespace, c.exposeName(name))
+}
+
+func (c *Controller) orphanService(ctx context.Context, namespace, svcName, ownerKind, ownerName string) error {
+	if c.opts.OutputDir != "" {
+		return nil
+	}
+
+	svc, err := c.serviceLister.Services(namespace).Get(svcName)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get service %s/%s: %v", namespace, svcName, err)
+	}
+
+	var refs []metav1.OwnerReference
+	for _, ref := range svc.OwnerReferences {
+		if ref.Kind == ownerKind && ref.Name == ownerName {
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	if len(refs) != len(svc.OwnerReferences) {
+		updated := svc.DeepCopy()
+		updated.OwnerReferences = refs
+		if c.opts.DryRun {
+			logDryRun(ctx, "orphan", "Service", namespace, svcName, *svc, *updated)
+			return nil
+		}
+		_, err := c.clientset.CoreV1().Services(namespace).Update(ctx, updated, metav1.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to orphan service %s/%s: %v", namespace, svcName, err)
+		}
+	}
+
+	klog.FromContext(ctx).Info("Service retained after deployment deletion", "service", svcName)
+	return nil
+}
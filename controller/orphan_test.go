@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The fake clientset has no garbage collector, so these tests assert the
+// Service carries no owner reference to the deleted Deployment: with one,
+// garbage collection would remove it regardless of the delay.
+
+func TestRecreatedWithinDelayKeepsService(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	f := newFixture(t, Options{OrphanDeleteDelay: time.Hour}, deploy)
+	f.mustSync("default/web")
+
+	f.deleteDeployment("default", "web")
+	f.mustSync("default/web")
+
+	svc := f.service("default", "web-expose")
+	if svc == nil {
+		t.Fatal("service was deleted within the delay")
+	}
+	if len(svc.OwnerReferences) != 0 {
+		t.Fatalf("service has owner references %v, garbage collection would remove it", svc.OwnerReferences)
+	}
+	if _, ok := svc.Annotations[deleteAfterAnnotation]; !ok {
+		t.Fatalf("service has no %s annotation", deleteAfterAnnotation)
+	}
+
+	recreated := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	recreated.UID = types.UID("uid-web-2")
+	if _, err := f.client.AppsV1().Deployments("default").Create(context.Background(), recreated, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("recreating deployment: %v", err)
+	}
+	f.mustSync("default/web")
+
+	svc = f.service("default", "web-expose")
+	if svc == nil {
+		t.Fatal("service of the recreated deployment is gone")
+	}
+	if _, ok := svc.Annotations[deleteAfterAnnotation]; ok {
+		t.Errorf("%s annotation was not pruned after the deployment reappeared", deleteAfterAnnotation)
+	}
+}
+
+func TestOrphanDeadlineSurvivesRestart(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	f := newFixture(t, Options{OrphanDeleteDelay: time.Hour}, deploy)
+	f.mustSync("default/web")
+	f.deleteDeployment("default", "web")
+	f.mustSync("default/web")
+
+	recorded := f.service("default", "web-expose").Annotations[deleteAfterAnnotation]
+
+	// A restarted controller only has the deadline recorded on the Service.
+	f.c.orphanDeadlines = map[string]time.Time{}
+	f.mustSync("default/web")
+	svc := f.service("default", "web-expose")
+	if svc == nil {
+		t.Fatal("service was deleted before the recorded deadline")
+	}
+	if got := svc.Annotations[deleteAfterAnnotation]; got != recorded {
+		t.Errorf("deadline restarted: %s, want %s", got, recorded)
+	}
+
+	// Once the recorded deadline has passed, the Service is removed.
+	f.c.orphanDeadlines = map[string]time.Time{}
+	svc.Annotations[deleteAfterAnnotation] = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	if _, err := f.client.CoreV1().Services("default").Update(context.Background(), svc, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating service: %v", err)
+	}
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") != nil {
+		t.Error("service outlived its recorded deadline")
+	}
+}
+
+func TestNoDelayDeletesService(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	f.deleteDeployment("default", "web")
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") != nil {
+		t.Error("service of a deleted deployment was kept without a delay")
+	}
+}
+
+func TestRetainedServiceSurvivesDeletion(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Annotations[retainOnDeleteAnnotation] = "true"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	svc := f.service("default", "web-expose")
+	if svc == nil {
+		t.Fatal("service was not created")
+	}
+	if svc.Annotations[retainedAnnotation] != "true" {
+		t.Fatalf("service is not marked %s", retainedAnnotation)
+	}
+
+	// A fresh controller has only the Service to go by.
+	f.deleteDeployment("default", "web")
+	restarted := newFixture(t, Options{}, svc)
+	restarted.mustSync("default/web")
+
+	svc = restarted.service("default", "web-expose")
+	if svc == nil {
+		t.Fatal("retained service was deleted")
+	}
+	if len(svc.OwnerReferences) != 0 {
+		t.Errorf("retained service has owner references %v", svc.OwnerReferences)
+	}
+}
+
+func TestRetentionIsPrunedWhenAnnotationRemoved(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Annotations[retainOnDeleteAnnotation] = "true"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	delete(deploy.Annotations, retainOnDeleteAnnotation)
+	if _, err := f.client.AppsV1().Deployments("default").Update(context.Background(), deploy, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating deployment: %v", err)
+	}
+	f.mustSync("default/web")
+
+	svc := f.service("default", "web-expose")
+	if _, ok := svc.Annotations[retainedAnnotation]; ok {
+		t.Errorf("%s annotation kept after retain-on-delete was removed", retainedAnnotation)
+	}
+	if len(svc.OwnerReferences) != 1 {
+		t.Errorf("owner references = %v, want the deployment's", svc.OwnerReferences)
+	}
+
+	f.deleteDeployment("default", "web")
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") != nil {
+		t.Error("service no longer retained was kept")
+	}
+}
+
+func TestOrphanOnDeleteKeepsService(t *testing.T) {
+	f := newFixture(t, Options{OrphanOnDelete: true}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+	before := f.service("default", "web-expose")
+	if before == nil {
+		t.Fatal("service was not created")
+	}
+
+	f.deleteDeployment("default", "web")
+	f.client.ClearActions()
+	f.mustSync("default/web")
+	if slices.Contains(f.serviceActions(), "delete") {
+		t.Error("service of a deleted deployment was deleted with OrphanOnDelete")
+	}
+	after := f.service("default", "web-expose")
+	if after == nil {
+		t.Fatal("service of a deleted deployment is gone")
+	}
+	if !equality.Semantic.DeepEqual(after.Spec, before.Spec) {
+		t.Errorf("spec of the orphaned service changed from %+v to %+v", before.Spec, after.Spec)
+	}
+	if len(after.OwnerReferences) != 0 {
+		t.Errorf("orphaned service has owner references %v", after.OwnerReferences)
+	}
+}
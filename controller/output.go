@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// serviceFilePath returns the file a Service is rendered to in output-dir mode.
+func (c *Controller) serviceFilePath(namespace, svcName string) string {
+	return filepath.Join(c.opts.OutputDir, fmt.Sprintf("%s-%s.yaml", namespace, svcName))
+}
+
+// writeService renders the Service as YAML into the output directory so it
+// can be committed and applied by a GitOps tool.
+func (c *Controller) writeService(ctx context.Context, svc *v1.Service) error {
+	out := svc.DeepCopy()
+	out.APIVersion = "v1"
+	out.Kind = "Service"
+	// Cluster-specific fields have no meaning in a manifest.
+	out.ResourceVersion = ""
+	out.UID = ""
+	out.CreationTimestamp = metav1.Time{}
+	out.ManagedFields = nil
+	out.OwnerReferences = nil
+	out.Status = v1.ServiceStatus{}
+
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return fmt.Errorf("failed to render service %s/%s: %v", svc.Namespace, svc.Name, err)
+	}
+
+	return c.writeManifest(ctx, c.serviceFilePath(svc.Namespace, svc.Name), data)
+}
+
+// deleteServiceFile removes a previously rendered Service manifest.
+func (c *Controller) deleteServiceFile(ctx context.Context, namespace, svcName string) error {
+	return c.deleteManifest(ctx, c.serviceFilePath(namespace, svcName))
+}
+
+func (c *Controller) writeManifest(ctx context.Context, path string, data []byte) error {
+	if err := os.MkdirAll(c.opts.OutputDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create output dir %s: %v", c.opts.OutputDir, err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+
+	klog.FromContext(ctx).Info("Manifest written", "path", path)
+	return nil
+}
+
+func (c *Controller) deleteManifest(ctx context.Context, path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %v", path, err)
+	}
+
+	klog.FromContext(ctx).Info("Manifest removed (if existed)", "path", path)
+	return nil
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestOutputDirWritesAndRemovesService(t *testing.T) {
+	dir := t.TempDir()
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	f := newFixture(t, Options{OutputDir: dir}, deploy)
+	f.mustSync("default/web")
+
+	path := filepath.Join(dir, "default-web-expose.yaml")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading rendered service: %v", err)
+	}
+	var svc v1.Service
+	if err := yaml.Unmarshal(data, &svc); err != nil {
+		t.Fatalf("parsing rendered service: %v", err)
+	}
+	if svc.APIVersion != "v1" || svc.Kind != "Service" || svc.Namespace != "default" || svc.Name != "web-expose" {
+		t.Errorf("rendered %s %s %s/%s, want v1 Service default/web-expose", svc.APIVersion, svc.Kind, svc.Namespace, svc.Name)
+	}
+	if len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Port != 8080 {
+		t.Errorf("rendered ports = %+v, want 8080", svc.Spec.Ports)
+	}
+	if len(svc.OwnerReferences) != 0 {
+		t.Errorf("rendered owner references %v", svc.OwnerReferences)
+	}
+	if f.service("default", "web-expose") != nil {
+		t.Error("service was applied to the cluster in output-dir mode")
+	}
+
+	delete(deploy.Annotations, enabledAnnotation)
+	if _, err := f.client.AppsV1().Deployments("default").Update(context.Background(), deploy, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating deployment: %v", err)
+	}
+	f.mustSync("default/web")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("rendered service of a deployment no longer exposed was not removed: %v", err)
+	}
+}
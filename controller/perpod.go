@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// perPodLabel marks a per-pod Service with the name of its StatefulSet, so
+// the Services of replicas that were scaled away can be found.
+const perPodLabel = annotationPrefix + "per-pod-of"
+
+// podOrdinals returns the ordinals of the replicas sts wants, starting at
+// its start ordinal.
+func podOrdinals(sts *appsv1.StatefulSet) []int32 {
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	var start int32
+	if sts.Spec.Ordinals != nil {
+		start = sts.Spec.Ordinals.Start
+	}
+	ordinals := make([]int32, 0, replicas)
+	for i := int32(0); i < replicas; i++ {
+		ordinals = append(ordinals, start+i)
+	}
+	return ordinals
+}
+
+// reconcilePerPodServices gives every replica of the StatefulSet wl a
+// Service named after its Pod, and removes the Services of replicas that
+// were scaled away.
+func (c *Controller) reconcilePerPodServices(ctx context.Context, key string, wl *workload, cfg *ExposeConfig, selector map[string]string, ports []v1.ServicePort) error {
+	sts, err := c.statefulSetLister.StatefulSets(wl.Namespace).Get(wl.Name)
+	if err != nil {
+		return fmt.Errorf("failed to get statefulset %s/%s: %v", wl.Namespace, wl.Name, err)
+	}
+
+	keep := map[string]bool{}
+	for _, ordinal := range podOrdinals(sts) {
+		desired := c.desiredPodService(wl, cfg, fmt.Sprintf("%s-%d", sts.Name, ordinal), selector, ports)
+		keep[desired.Name] = true
+		if err := c.reconcileService(ctx, key, wl, desired); err != nil {
+			return err
+		}
+	}
+	return c.removePerPodServices(ctx, wl, statefulSetKind, wl.Namespace, wl.Name, keep)
+}
+
+// desiredPodService builds the Service of the Pod podName: the workload's
+// Service narrowed to the Pod through the label the StatefulSet controller
+// sets on it. A pinned NodePort is not applied, since every replica's
+// Service would claim it.
+func (c *Controller) desiredPodService(wl *workload, cfg *ExposeConfig, podName string, selector map[string]string, ports []v1.ServicePort) *v1.Service {
+	podSelector := make(map[string]string, len(selector)+1)
+	for k, v := range selector {
+		podSelector[k] = v
+	}
+	podSelector[appsv1.StatefulSetPodNameLabel] = podName
+
+	podCfg := *cfg
+	podCfg.NodePort = 0
+	svc := c.desiredService(wl, &podCfg, serviceName(podName, c.opts.ServiceSuffix), cfg.ServiceType, podSelector, ports)
+	svc.Labels[perPodLabel] = wl.Name
+	recordManagedLabels(svc)
+	return svc
+}
+
+// perPodServiceNames returns the names of the per-pod Services of the
+// StatefulSet name.
+func (c *Controller) perPodServiceNames(namespace, name string) ([]string, error) {
+	services, err := c.serviceLister.Services(namespace).List(labels.SelectorFromSet(labels.Set{perPodLabel: name}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list per-pod services of statefulset %s/%s: %v", namespace, name, err)
+	}
+	names := make([]string, 0, len(services))
+	for _, svc := range services {
+		names = append(names, svc.Name)
+	}
+	return names, nil
+}
+
+// removePerPodServices removes the per-pod Services of the kind's object
+// name that are not in keep. Deployments have none.
+func (c *Controller) removePerPodServices(ctx context.Context, wl *workload, kind, namespace, name string, keep map[string]bool) error {
+	if kind != statefulSetKind {
+		return nil
+	}
+	names, err := c.perPodServiceNames(namespace, name)
+	if err != nil {
+		return err
+	}
+	for _, svcName := range names {
+		if keep[svcName] {
+			continue
+		}
+		if err := c.removeManagedService(ctx, wl, kind, namespace, name, svcName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
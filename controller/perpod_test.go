@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func perPodStatefulSet(replicas int32) *appsv1.StatefulSet {
+	sts := newStatefulSet("db", v1.ContainerPort{ContainerPort: 5432})
+	sts.Annotations[perPodAnnotation] = "true"
+	sts.Spec.Replicas = &replicas
+	return sts
+}
+
+// perPodServices returns the sorted names of the per-pod Services of the
+// StatefulSet db.
+func (f *fixture) perPodServices() []string {
+	f.t.Helper()
+	services, err := f.client.CoreV1().Services("default").List(context.Background(), metav1.ListOptions{
+		LabelSelector: perPodLabel + "=db",
+	})
+	if err != nil {
+		f.t.Fatalf("listing services: %v", err)
+	}
+	var names []string
+	for _, svc := range services.Items {
+		names = append(names, svc.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (f *fixture) updateStatefulSet(sts *appsv1.StatefulSet) {
+	f.t.Helper()
+	if _, err := f.client.AppsV1().StatefulSets(sts.Namespace).Update(context.Background(), sts, metav1.UpdateOptions{}); err != nil {
+		f.t.Fatalf("updating statefulset: %v", err)
+	}
+}
+
+func TestPerPodServices(t *testing.T) {
+	sts := perPodStatefulSet(2)
+	f := newFixture(t, Options{WatchStatefulSets: true}, sts)
+	key := workloadKey(statefulSetKind, "default/db")
+	f.mustSync(key)
+
+	if got, want := f.perPodServices(), []string{"db-0-expose", "db-1-expose"}; !slices.Equal(got, want) {
+		t.Fatalf("per-pod services = %v, want %v", got, want)
+	}
+	svc := f.service("default", "db-1-expose")
+	if got := svc.Spec.Selector[appsv1.StatefulSetPodNameLabel]; got != "db-1" {
+		t.Errorf("selector %s = %q, want db-1", appsv1.StatefulSetPodNameLabel, got)
+	}
+	if got := svc.Spec.Selector["app"]; got != "db" {
+		t.Errorf("selector app = %q, want db", got)
+	}
+	if ref := metav1.GetControllerOf(svc); ref == nil || ref.Kind != statefulSetKind || ref.Name != "db" {
+		t.Errorf("controller reference = %+v, want StatefulSet db", ref)
+	}
+	if f.service("default", "db-expose") == nil {
+		t.Error("the statefulset's own service was not created")
+	}
+}
+
+func TestPerPodServicesFollowScaling(t *testing.T) {
+	sts := perPodStatefulSet(1)
+	f := newFixture(t, Options{WatchStatefulSets: true}, sts)
+	key := workloadKey(statefulSetKind, "default/db")
+	f.mustSync(key)
+
+	steps := []struct {
+		name     string
+		replicas int32
+		want     []string
+	}{
+		{name: "scale up", replicas: 3, want: []string{"db-0-expose", "db-1-expose", "db-2-expose"}},
+		{name: "scale down", replicas: 1, want: []string{"db-0-expose"}},
+		{name: "scale to zero", replicas: 0},
+	}
+	for _, step := range steps {
+		sts.Spec.Replicas = &step.replicas
+		f.updateStatefulSet(sts)
+		f.mustSync(key)
+		if got := f.perPodServices(); !slices.Equal(got, step.want) {
+			t.Errorf("%s: per-pod services = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestPerPodServicesStartOrdinal(t *testing.T) {
+	sts := perPodStatefulSet(2)
+	sts.Spec.Ordinals = &appsv1.StatefulSetOrdinals{Start: 5}
+	f := newFixture(t, Options{WatchStatefulSets: true}, sts)
+	f.mustSync(workloadKey(statefulSetKind, "default/db"))
+
+	if got, want := f.perPodServices(), []string{"db-5-expose", "db-6-expose"}; !slices.Equal(got, want) {
+		t.Errorf("per-pod services = %v, want %v", got, want)
+	}
+}
+
+func TestPerPodServicesRemoved(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(f *fixture, sts *appsv1.StatefulSet)
+	}{
+		{
+			name: "annotation removed",
+			change: func(f *fixture, sts *appsv1.StatefulSet) {
+				delete(sts.Annotations, perPodAnnotation)
+				f.updateStatefulSet(sts)
+			},
+		},
+		{
+			name: "statefulset deleted",
+			change: func(f *fixture, sts *appsv1.StatefulSet) {
+				if err := f.client.AppsV1().StatefulSets("default").Delete(context.Background(), "db", metav1.DeleteOptions{}); err != nil {
+					f.t.Fatalf("deleting statefulset: %v", err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sts := perPodStatefulSet(2)
+			f := newFixture(t, Options{WatchStatefulSets: true}, sts)
+			key := workloadKey(statefulSetKind, "default/db")
+			f.mustSync(key)
+
+			tt.change(f, sts)
+			f.mustSync(key)
+			if got := f.perPodServices(); len(got) != 0 {
+				t.Errorf("per-pod services %v were not removed", got)
+			}
+		})
+	}
+}
+
+func TestPerPodIgnoredForDeployments(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Annotations[perPodAnnotation] = "true"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	services, err := f.client.CoreV1().Services("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("listing services: %v", err)
+	}
+	if len(services.Items) != 1 || services.Items[0].Name != "web-expose" {
+		t.Errorf("services = %v, want only web-expose", services.Items)
+	}
+}
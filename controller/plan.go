@@ -0,0 +1,61 @@
+package controller
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// serviceAction is the change needed to converge a Service.
+type serviceAction string
+
+const (
+	// serviceNoop leaves the Service as it is.
+	serviceNoop serviceAction = "noop"
+	// serviceCreate creates the desired Service.
+	serviceCreate serviceAction = "create"
+	// serviceUpdate updates the existing Service to the desired one.
+	serviceUpdate serviceAction = "update"
+	// serviceDelete deletes a Service that is no longer desired.
+	serviceDelete serviceAction = "delete"
+	// serviceConflict leaves a Service the controller does not own alone.
+	serviceConflict serviceAction = "conflict"
+	// serviceCollision leaves a Service generated for another Deployment
+	// whose name maps to the same Service name alone.
+	serviceCollision serviceAction = "collision"
+)
+
+// planService decides how to converge current, the Service in the cluster or
+// nil if there is none, to desired, the Service the controller wants or nil
+// if it wants none. It makes no API calls and does not log, so callers
+// apply the decision and report the warning, if any, about a requested
+// change that cannot be applied.
+func (c *Controller) planService(wl *workload, current, desired *v1.Service) (serviceAction, string) {
+	switch {
+	case current == nil && desired == nil:
+		return serviceNoop, ""
+	case current == nil:
+		return serviceCreate, ""
+	case !c.ownsService(current, wl):
+		if desired == nil {
+			// Not ours and not wanted: nothing to report.
+			return serviceNoop, ""
+		}
+		return serviceConflict, ""
+	case wl != nil && ownedByOtherWorkload(current, wl.kind, wl.Name):
+		if desired == nil {
+			return serviceNoop, ""
+		}
+		return serviceCollision, ""
+	case desired == nil:
+		return serviceDelete, ""
+	}
+
+	familiesDrifted, warning := ipFamiliesDrifted(current, desired)
+	if specHash(current) != desired.Annotations[specHashAnnotation] ||
+		familiesDrifted ||
+		pinnedNodePortsDrifted(current.Spec.Ports, desired.Spec.Ports) ||
+		ownerDrifted(current, desired) ||
+		c.metadataDrifted(current, desired) {
+		return serviceUpdate, warning
+	}
+	return serviceNoop, warning
+}
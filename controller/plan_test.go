@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestPlanService(t *testing.T) {
+	c := newFixture(t, Options{}).c
+	deploy := deploymentWorkload(newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	ports := []v1.ServicePort{{Name: "port-8080", Port: 8080, Protocol: v1.ProtocolTCP}}
+	desiredFor := func(cfg *ExposeConfig) *v1.Service {
+		return c.desiredService(deploy, cfg, "web-expose", v1.ServiceTypeNodePort, serviceSelector(deploy), ports)
+	}
+	desired := desiredFor(&ExposeConfig{})
+
+	// modified returns a copy of desired, as if it had been created and
+	// then changed by mutate.
+	modified := func(mutate func(*v1.Service)) *v1.Service {
+		svc := desired.DeepCopy()
+		mutate(svc)
+		return svc
+	}
+	otherWorkload := func(svc *v1.Service) {
+		svc.OwnerReferences[0].Name, svc.OwnerReferences[0].UID = "api", types.UID("uid-api")
+	}
+	singleStack := v1.IPFamilyPolicySingleStack
+	ipv6 := desiredFor(&ExposeConfig{IPFamilies: []v1.IPFamily{v1.IPv6Protocol}, IPFamilyPolicy: &singleStack})
+
+	tests := []struct {
+		name        string
+		deploy      *workload
+		current     *v1.Service
+		desired     *v1.Service
+		want        serviceAction
+		wantWarning string
+	}{
+		{name: "neither exists", deploy: deploy, want: serviceNoop},
+		{name: "missing service", deploy: deploy, desired: desired, want: serviceCreate},
+		{name: "unchanged service", deploy: deploy, current: desired.DeepCopy(), desired: desired, want: serviceNoop},
+		{
+			name: "unmanaged service", deploy: deploy, desired: desired, want: serviceConflict,
+			current: &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web-expose", Namespace: "default"}},
+		},
+		{
+			name: "unmanaged service not wanted", deploy: deploy, want: serviceNoop,
+			current: &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web-expose", Namespace: "default"}},
+		},
+		{name: "another workload's service", deploy: deploy, current: modified(otherWorkload), desired: desired, want: serviceCollision},
+		{name: "another workload's service not wanted", deploy: deploy, current: modified(otherWorkload), want: serviceNoop},
+		{name: "service no longer wanted", deploy: deploy, current: desired.DeepCopy(), want: serviceDelete},
+		{name: "service of a deleted deployment", current: desired.DeepCopy(), want: serviceDelete},
+		{
+			name: "spec drifted", deploy: deploy, desired: desired, want: serviceUpdate,
+			current: modified(func(svc *v1.Service) { svc.Spec.Type = v1.ServiceTypeClusterIP }),
+		},
+		{
+			name: "pinned node port drifted", deploy: deploy, want: serviceUpdate,
+			current: modified(func(svc *v1.Service) { svc.Spec.Ports[0].NodePort = 30001 }),
+			desired: desiredFor(&ExposeConfig{NodePort: 30000}),
+		},
+		{
+			name: "owner drifted", deploy: deploy, desired: desired, want: serviceUpdate,
+			current: modified(func(svc *v1.Service) { svc.OwnerReferences[0].UID = types.UID("uid-old") }),
+		},
+		{
+			name: "managed label drifted", deploy: deploy, desired: desired, want: serviceUpdate,
+			current: modified(func(svc *v1.Service) { delete(svc.Labels, managedByLabel) }),
+		},
+		{
+			name: "ip family policy drifted", deploy: deploy, desired: ipv6, want: serviceUpdate,
+			current: modified(func(svc *v1.Service) {
+				svc.Annotations[specHashAnnotation] = ipv6.Annotations[specHashAnnotation]
+				svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv6Protocol, v1.IPv4Protocol}
+			}),
+		},
+		{
+			name: "primary ip family change", deploy: deploy, desired: ipv6, want: serviceNoop,
+			current: modified(func(svc *v1.Service) {
+				svc.Annotations[specHashAnnotation] = ipv6.Annotations[specHashAnnotation]
+				svc.Spec.IPFamilies = []v1.IPFamily{v1.IPv4Protocol}
+				svc.Spec.IPFamilyPolicy = &singleStack
+			}),
+			wantWarning: "cannot change to IPv6",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, warning := c.planService(tt.deploy, tt.current, tt.desired)
+			if got != tt.want {
+				t.Errorf("action = %s, want %s", got, tt.want)
+			}
+			if tt.wantWarning == "" && warning != "" {
+				t.Errorf("unexpected warning %q", warning)
+			}
+			if !strings.Contains(warning, tt.wantWarning) {
+				t.Errorf("warning = %q, want it to contain %q", warning, tt.wantWarning)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+)
+
+// fallbackPort is exposed when no container declares a port.
+const fallbackPort = 80
+
+// AmbiguousPortPolicy decides what happens when a Deployment runs several
+// containers and none of them declares a port, so there is no good reason
+// to pick the fallback port.
+type AmbiguousPortPolicy string
+
+const (
+	// AmbiguousPortSkip creates no Service and records a Warning event.
+	AmbiguousPortSkip AmbiguousPortPolicy = "skip"
+	// AmbiguousPortDefault exposes the fallback port anyway.
+	AmbiguousPortDefault AmbiguousPortPolicy = "default"
+	// AmbiguousPortError fails the reconcile without retrying it.
+	AmbiguousPortError AmbiguousPortPolicy = "error"
+)
+
+// ParseAmbiguousPortPolicy validates a policy name.
+func ParseAmbiguousPortPolicy(s string) (AmbiguousPortPolicy, error) {
+	switch p := AmbiguousPortPolicy(s); p {
+	case AmbiguousPortSkip, AmbiguousPortDefault, AmbiguousPortError:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid ambiguous port policy %q, must be one of skip, default, error", s)
+}
+
+type portKey struct {
+	port     int32
+	protocol v1.Protocol
+}
+
+func keyOf(p v1.ServicePort) portKey {
+	protocol := p.Protocol
+	if protocol == "" {
+		protocol = v1.ProtocolTCP
+	}
+	return portKey{port: p.Port, protocol: protocol}
+}
+
+// servicePorts derives one ServicePort per distinct container port across
+// all containers of the Deployment, sorted so the result is stable between
+// reconciles. Ports excluded by number or by name are skipped. It falls back
+// to the default ports, port 80 unless configured, when no container
+// declares a port, unless the Deployment opts out of the fallback or the
+// ambiguous port policy rejects it for a multi-container Deployment; it
+// returns nil when there is nothing to expose. With PreferProbePort, a
+// container's readiness probe port replaces its declared ports. Container
+// ports with a unique name are targeted by name rather than by number. Ports
+// set through the port annotations replace all of this. Every port then gets
+// an application protocol, see withAppProtocol.
+func (c *Controller) servicePorts(ctx context.Context, wl *workload, cfg *ExposeConfig) ([]v1.ServicePort, error) {
+	ports, err := c.exposedPorts(ctx, wl, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return withAppProtocol(ports, cfg.AppProtocol), nil
+}
+
+// appProtocols maps well-known port names, or their prefix before a "-",
+// to the application protocol they imply.
+var appProtocols = map[string]string{
+	"grpc":  "grpc",
+	"http":  "http",
+	"https": "https",
+	"http2": "kubernetes.io/h2c",
+	"h2c":   "kubernetes.io/h2c",
+	"ws":    "kubernetes.io/ws",
+	"wss":   "kubernetes.io/wss",
+}
+
+// withAppProtocol returns ports with AppProtocol set to explicit when it is
+// not empty, or else inferred from the names of TCP ports, e.g. "grpc" or
+// "grpc-api" to grpc. Ports with an unknown name are left without one.
+func withAppProtocol(ports []v1.ServicePort, explicit string) []v1.ServicePort {
+	out := make([]v1.ServicePort, len(ports))
+	for i, p := range ports {
+		p.AppProtocol = nil
+		if explicit != "" {
+			p.AppProtocol = &explicit
+		} else if keyOf(p).protocol == v1.ProtocolTCP {
+			prefix, _, _ := strings.Cut(p.Name, "-")
+			if proto, ok := appProtocols[prefix]; ok {
+				p.AppProtocol = &proto
+			}
+		}
+		out[i] = p
+	}
+	return out
+}
+
+// exposedPorts derives the Service ports before application protocols are
+// applied.
+func (c *Controller) exposedPorts(ctx context.Context, wl *workload, cfg *ExposeConfig) ([]v1.ServicePort, error) {
+	if len(cfg.Ports) > 0 {
+		return cfg.Ports, nil
+	}
+	if cfg.PortOverride != nil {
+		return []v1.ServicePort{c.overridePort(wl, cfg)}, nil
+	}
+
+	ports, declared := c.containerPorts(wl, cfg)
+	if len(ports) > 0 {
+		return ports, nil
+	}
+	if declared {
+		return nil, nil
+	}
+	if cfg.NoFallbackPort {
+		c.event(wl, v1.EventTypeWarning, "NoPorts", "Not exposing %s: no container declares a port and %s is set", strings.ToLower(wl.kind), noFallbackPortAnnotation)
+		return nil, nil
+	}
+	if len(wl.template.Spec.Containers) > 1 {
+		switch c.opts.AmbiguousPortPolicy {
+		case AmbiguousPortError:
+			return nil, &nonRetryableError{
+				reason: "ambiguous_ports",
+				err:    fmt.Errorf("%s %s/%s has several containers and none declares a port", strings.ToLower(wl.kind), wl.Namespace, wl.Name),
+			}
+		case AmbiguousPortDefault:
+		default:
+			klog.FromContext(ctx).Info("Several containers and none declares a port, skipping fallback port")
+			c.event(wl, v1.EventTypeWarning, "AmbiguousPorts", "Not exposing %s: several containers and none declares a port", strings.ToLower(wl.kind))
+			return nil, nil
+		}
+	}
+	if len(c.opts.DefaultPorts) > 0 {
+		return c.opts.DefaultPorts, nil
+	}
+	return []v1.ServicePort{{
+		Name:       "http",
+		Protocol:   v1.ProtocolTCP,
+		Port:       fallbackPort,
+		TargetPort: intstr.FromInt32(fallbackPort),
+	}}, nil
+}
+
+// ParseDefaultPorts parses a comma-separated list of port numbers into the
+// ServicePorts exposed when no container declares a port.
+func ParseDefaultPorts(s string) ([]v1.ServicePort, error) {
+	var ports []v1.ServicePort
+	seen := map[int64]bool{}
+	for _, entry := range splitList(s) {
+		n, err := strconv.ParseInt(entry, 10, 32)
+		if err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("invalid default port %q, expected a port between 1 and 65535", entry)
+		}
+		if seen[n] {
+			return nil, fmt.Errorf("duplicate default port %d", n)
+		}
+		seen[n] = true
+		ports = append(ports, v1.ServicePort{
+			Name:       fmt.Sprintf("port-%d", n),
+			Protocol:   v1.ProtocolTCP,
+			Port:       int32(n),
+			TargetPort: intstr.FromInt32(int32(n)),
+		})
+	}
+	return ports, nil
+}
+
+// overridePort completes the port set through the port annotations. The
+// Service port defaults to 80 and the target port to the first port derived
+// from the containers, or to the Service port when they declare none.
+func (c *Controller) overridePort(wl *workload, cfg *ExposeConfig) v1.ServicePort {
+	port := *cfg.PortOverride
+	if port.Port == 0 {
+		port.Port = fallbackPort
+	}
+	if port.TargetPort == (intstr.IntOrString{}) {
+		port.TargetPort = intstr.FromInt32(port.Port)
+		if derived, _ := c.containerPorts(wl, cfg); len(derived) > 0 {
+			port.TargetPort = derived[0].TargetPort
+		}
+	}
+	port.Name = fmt.Sprintf("port-%d", port.Port)
+	return port
+}
+
+// containerPorts returns the sorted, deduplicated ports declared by the
+// Deployment's containers that are not excluded, and whether any port was
+// declared at all.
+func (c *Controller) containerPorts(wl *workload, cfg *ExposeConfig) ([]v1.ServicePort, bool) {
+	seen := map[portKey]bool{}
+	names := map[string]bool{}
+	declared := false
+	var ports []v1.ServicePort
+
+	// A named target port resolves to the first container declaring the
+	// name, so names declared more than once are targeted by number.
+	nameCount := map[string]int{}
+	for _, container := range wl.template.Spec.Containers {
+		for _, cp := range container.Ports {
+			if cp.Name != "" {
+				nameCount[cp.Name]++
+			}
+		}
+	}
+
+	for _, container := range wl.template.Spec.Containers {
+		candidates := container.Ports
+		if c.opts.PreferProbePort {
+			if cp, ok := probePort(container); ok {
+				candidates = []v1.ContainerPort{cp}
+			}
+		}
+		for _, cp := range candidates {
+			declared = true
+			if cfg.ExcludePorts[cp.ContainerPort] || (cp.Name != "" && cfg.ExcludePortNames[cp.Name]) {
+				continue
+			}
+			protocol := cp.Protocol
+			if protocol == "" {
+				protocol = v1.ProtocolTCP
+			}
+			key := portKey{port: cp.ContainerPort, protocol: protocol}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			name := cp.Name
+			if name == "" {
+				name = fmt.Sprintf("port-%d", cp.ContainerPort)
+			}
+			name = uniquePortName(names, name, protocol)
+			names[name] = true
+
+			target := intstr.FromInt32(cp.ContainerPort)
+			if cp.Name != "" && nameCount[cp.Name] == 1 {
+				target = intstr.FromString(cp.Name)
+			}
+			ports = append(ports, v1.ServicePort{
+				Name:       name,
+				Protocol:   protocol,
+				Port:       cp.ContainerPort,
+				TargetPort: target,
+			})
+		}
+	}
+
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].Port != ports[j].Port {
+			return ports[i].Port < ports[j].Port
+		}
+		return ports[i].Protocol < ports[j].Protocol
+	})
+	return ports, declared
+}
+
+// uniquePortName returns name, or when a port in names already has it, name
+// qualified with the protocol and, if that is taken too, a counter, since
+// the API server rejects a Service whose port names repeat.
+func uniquePortName(names map[string]bool, name string, protocol v1.Protocol) string {
+	if !names[name] {
+		return name
+	}
+	base := fmt.Sprintf("%s-%s", name, strings.ToLower(string(protocol)))
+	unique := base
+	for i := 2; names[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", base, i)
+	}
+	return unique
+}
+
+// probePort resolves the port of the container's HTTP or TCP readiness
+// probe. Named probe ports are resolved against the container's ports.
+func probePort(container v1.Container) (v1.ContainerPort, bool) {
+	probe := container.ReadinessProbe
+	if probe == nil {
+		return v1.ContainerPort{}, false
+	}
+
+	var port intstr.IntOrString
+	switch {
+	case probe.HTTPGet != nil:
+		port = probe.HTTPGet.Port
+	case probe.TCPSocket != nil:
+		port = probe.TCPSocket.Port
+	default:
+		return v1.ContainerPort{}, false
+	}
+
+	for _, cp := range container.Ports {
+		if (port.Type == intstr.String && cp.Name == port.StrVal) ||
+			(port.Type == intstr.Int && cp.ContainerPort == port.IntVal) {
+			return cp, true
+		}
+	}
+	if port.Type == intstr.Int && port.IntVal > 0 {
+		return v1.ContainerPort{ContainerPort: port.IntVal, Protocol: v1.ProtocolTCP}, true
+	}
+	return v1.ContainerPort{}, false
+}
+
+// preserveNodePorts returns desired with the NodePorts already allocated in
+// current carried over, so an update does not force reallocation. Ports
+// that pin a NodePort keep their own value.
+func preserveNodePorts(current, desired []v1.ServicePort) []v1.ServicePort {
+	allocated := make(map[portKey]int32, len(current))
+	for _, p := range current {
+		allocated[keyOf(p)] = p.NodePort
+	}
+
+	out := make([]v1.ServicePort, len(desired))
+	for i, p := range desired {
+		if p.NodePort == 0 {
+			p.NodePort = allocated[keyOf(p)]
+		}
+		out[i] = p
+	}
+	return out
+}
+
+// pinnedNodePortsDrifted reports whether a NodePort pinned in desired
+// differs from the one allocated in current. Unpinned NodePorts are not
+// compared, since the API server allocates them.
+func pinnedNodePortsDrifted(current, desired []v1.ServicePort) bool {
+	allocated := make(map[portKey]int32, len(current))
+	for _, p := range current {
+		allocated[keyOf(p)] = p.NodePort
+	}
+	for _, p := range desired {
+		if p.NodePort != 0 && allocated[keyOf(p)] != p.NodePort {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,669 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// portNumbers returns the Service port numbers of ports.
+func portNumbers(ports []v1.ServicePort) []int32 {
+	var out []int32
+	for _, p := range ports {
+		out = append(out, p.Port)
+	}
+	return out
+}
+
+func TestParseDefaultPorts(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []int32
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "8080", want: []int32{8080}},
+		{in: "80, 443 ,8080", want: []int32{80, 443, 8080}},
+		{in: "80,,443", want: []int32{80, 443}},
+		{in: "1,65535", want: []int32{1, 65535}},
+		{in: "0", wantErr: true},
+		{in: "65536", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "http", wantErr: true},
+		{in: "80,80", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			ports, err := ParseDefaultPorts(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDefaultPorts(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			}
+			if got := portNumbers(ports); !slices.Equal(got, tt.want) {
+				t.Errorf("ParseDefaultPorts(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+			for _, p := range ports {
+				if p.Protocol != v1.ProtocolTCP || p.TargetPort.IntVal != p.Port {
+					t.Errorf("port %+v does not target itself over TCP", p)
+				}
+			}
+		})
+	}
+}
+
+func TestFallbackPorts(t *testing.T) {
+	single := newDeployment("web")
+	multi := newDeployment("web")
+	multi.Spec.Template.Spec.Containers = append(multi.Spec.Template.Spec.Containers, v1.Container{Name: "sidecar", Image: "sidecar"})
+	defaults, err := ParseDefaultPorts("8080,9090")
+	if err != nil {
+		t.Fatalf("ParseDefaultPorts: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		opts      Options
+		deploy    *appsv1.Deployment
+		cfg       ExposeConfig
+		want      []int32
+		wantFatal bool
+	}{
+		{name: "port 80 without declared ports", deploy: single, want: []int32{fallbackPort}},
+		{name: "configured default ports", opts: Options{DefaultPorts: defaults}, deploy: single, want: []int32{8080, 9090}},
+		{name: "fallback opted out", deploy: single, cfg: ExposeConfig{NoFallbackPort: true}},
+		{name: "declared port wins", deploy: newDeployment("web", v1.ContainerPort{ContainerPort: 3000}), want: []int32{3000}},
+		{name: "ambiguous containers are skipped", deploy: multi},
+		{name: "ambiguous containers get the default", opts: Options{AmbiguousPortPolicy: AmbiguousPortDefault}, deploy: multi, want: []int32{fallbackPort}},
+		{name: "ambiguous containers fail", opts: Options{AmbiguousPortPolicy: AmbiguousPortError}, deploy: multi, wantFatal: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFixture(t, tt.opts).c
+			ports, err := c.servicePorts(context.Background(), deploymentWorkload(tt.deploy), &tt.cfg)
+			var fatal *nonRetryableError
+			if tt.wantFatal {
+				if !errors.As(err, &fatal) {
+					t.Fatalf("error = %v, want a non-retryable error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("servicePorts: %v", err)
+			}
+			if got := portNumbers(ports); !slices.Equal(got, tt.want) {
+				t.Errorf("ports = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSingleContainerPort(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+
+	svc := f.service("default", "web-expose")
+	if svc == nil {
+		t.Fatal("service was not created")
+	}
+	if len(svc.Spec.Ports) != 1 {
+		t.Fatalf("ports = %+v, want one", svc.Spec.Ports)
+	}
+	p := svc.Spec.Ports[0]
+	if p.Port != 8080 || p.TargetPort.IntVal != 8080 || p.Name != "port-8080" {
+		t.Errorf("port = %+v, want port-8080 from 8080 to 8080", p)
+	}
+}
+
+func TestPortsAcrossContainers(t *testing.T) {
+	deploy := newDeployment("web",
+		v1.ContainerPort{Name: "http", ContainerPort: 8080},
+		v1.ContainerPort{ContainerPort: 3000},
+	)
+	deploy.Spec.Template.Spec.Containers = append(deploy.Spec.Template.Spec.Containers, v1.Container{
+		Name:  "metrics",
+		Image: "metrics",
+		Ports: []v1.ContainerPort{
+			{Name: "metrics", ContainerPort: 9090},
+			// Declared again by the sidecar; exposed once.
+			{ContainerPort: 3000},
+		},
+	})
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	svc := f.service("default", "web-expose")
+	if svc == nil {
+		t.Fatal("service was not created")
+	}
+	want := []struct {
+		name   string
+		port   int32
+		target string
+	}{
+		{name: "port-3000", port: 3000, target: "3000"},
+		{name: "http", port: 8080, target: "http"},
+		{name: "metrics", port: 9090, target: "metrics"},
+	}
+	if len(svc.Spec.Ports) != len(want) {
+		t.Fatalf("ports = %+v, want %d", svc.Spec.Ports, len(want))
+	}
+	for i, w := range want {
+		p := svc.Spec.Ports[i]
+		if p.Name != w.name || p.Port != w.port || p.TargetPort.String() != w.target {
+			t.Errorf("port %d = %s %d -> %s, want %s %d -> %s", i, p.Name, p.Port, p.TargetPort.String(), w.name, w.port, w.target)
+		}
+	}
+}
+
+func TestPortNameSharedByContainers(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{Name: "http", ContainerPort: 8080})
+	for i, port := range []int32{8081, 8082} {
+		deploy.Spec.Template.Spec.Containers = append(deploy.Spec.Template.Spec.Containers, v1.Container{
+			Name:  fmt.Sprintf("sidecar-%d", i),
+			Image: "sidecar",
+			Ports: []v1.ContainerPort{{Name: "http", ContainerPort: port}},
+		})
+	}
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	svc := f.service("default", "web-expose")
+	if svc == nil {
+		t.Fatal("service was not created")
+	}
+	want := []string{"http:8080->8080", "http-tcp:8081->8081", "http-tcp-2:8082->8082"}
+	var got []string
+	for _, p := range svc.Spec.Ports {
+		got = append(got, fmt.Sprintf("%s:%d->%s", p.Name, p.Port, p.TargetPort.String()))
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ports = %v, want %v", got, want)
+	}
+}
+
+func TestPortProtocols(t *testing.T) {
+	tests := []struct {
+		name  string
+		ports []v1.ContainerPort
+		want  []v1.ServicePort
+	}{
+		{
+			name:  "udp",
+			ports: []v1.ContainerPort{{ContainerPort: 514, Protocol: v1.ProtocolUDP}},
+			want:  []v1.ServicePort{{Name: "port-514", Port: 514, Protocol: v1.ProtocolUDP}},
+		},
+		{
+			name:  "same port over tcp and udp",
+			ports: []v1.ContainerPort{{ContainerPort: 53, Protocol: v1.ProtocolTCP}, {ContainerPort: 53, Protocol: v1.ProtocolUDP}},
+			want: []v1.ServicePort{
+				{Name: "port-53", Port: 53, Protocol: v1.ProtocolTCP},
+				{Name: "port-53-udp", Port: 53, Protocol: v1.ProtocolUDP},
+			},
+		},
+		{
+			name:  "unset protocol is tcp",
+			ports: []v1.ContainerPort{{ContainerPort: 8080}},
+			want:  []v1.ServicePort{{Name: "port-8080", Port: 8080, Protocol: v1.ProtocolTCP}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t, Options{}, newDeployment("dns", tt.ports...))
+			f.mustSync("default/dns")
+
+			svc := f.service("default", "dns-expose")
+			if svc == nil {
+				t.Fatal("service was not created")
+			}
+			if len(svc.Spec.Ports) != len(tt.want) {
+				t.Fatalf("ports = %+v, want %+v", svc.Spec.Ports, tt.want)
+			}
+			for i, w := range tt.want {
+				p := svc.Spec.Ports[i]
+				if p.Name != w.Name || p.Port != w.Port || p.Protocol != w.Protocol {
+					t.Errorf("port %d = %s %d/%s, want %s %d/%s", i, p.Name, p.Port, p.Protocol, w.Name, w.Port, w.Protocol)
+				}
+			}
+		})
+	}
+}
+
+func TestProtocolChangeUpdatesService(t *testing.T) {
+	deploy := newDeployment("dns", v1.ContainerPort{ContainerPort: 53})
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/dns")
+
+	deploy.Spec.Template.Spec.Containers[0].Ports[0].Protocol = v1.ProtocolUDP
+	if _, err := f.client.AppsV1().Deployments("default").Update(context.Background(), deploy, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating deployment: %v", err)
+	}
+	f.mustSync("default/dns")
+
+	svc := f.service("default", "dns-expose")
+	if len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Protocol != v1.ProtocolUDP {
+		t.Errorf("ports = %+v, want 53/UDP", svc.Spec.Ports)
+	}
+}
+
+// allocateNodePorts sets NodePorts on the Service as the API server would
+// on creation.
+func (f *fixture) allocateNodePorts(namespace, name string, nodePorts ...int32) {
+	f.t.Helper()
+	svc := f.service(namespace, name)
+	for i := range svc.Spec.Ports {
+		svc.Spec.Ports[i].NodePort = nodePorts[i]
+	}
+	if _, err := f.client.CoreV1().Services(namespace).Update(context.Background(), svc, metav1.UpdateOptions{}); err != nil {
+		f.t.Fatalf("allocating node ports: %v", err)
+	}
+}
+
+func TestNodePortSurvivesReconcile(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+	f.allocateNodePorts("default", "web-expose", 31234)
+
+	f.client.ClearActions()
+	f.mustSync("default/web")
+	for _, action := range f.client.Actions() {
+		if action.GetResource().Resource == "services" && action.GetVerb() != "get" && action.GetVerb() != "list" {
+			t.Errorf("no-op reconcile changed the service: %s", action.GetVerb())
+		}
+	}
+
+	// A real change keeps the allocated NodePort of the unchanged port.
+	deploy.Spec.Template.Spec.Containers[0].Ports = append(deploy.Spec.Template.Spec.Containers[0].Ports, v1.ContainerPort{ContainerPort: 9090})
+	if _, err := f.client.AppsV1().Deployments("default").Update(context.Background(), deploy, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating deployment: %v", err)
+	}
+	f.mustSync("default/web")
+	svc := f.service("default", "web-expose")
+	if len(svc.Spec.Ports) != 2 {
+		t.Fatalf("ports = %+v, want two", svc.Spec.Ports)
+	}
+	if got := svc.Spec.Ports[0].NodePort; got != 31234 {
+		t.Errorf("node port of 8080 = %d, want 31234", got)
+	}
+}
+
+func TestPreserveNodePorts(t *testing.T) {
+	current := []v1.ServicePort{
+		{Name: "http", Port: 80, Protocol: v1.ProtocolTCP, NodePort: 30080},
+		{Name: "dns", Port: 53, Protocol: v1.ProtocolUDP, NodePort: 30053},
+	}
+	desired := []v1.ServicePort{
+		{Name: "http", Port: 80},
+		{Name: "dns-tcp", Port: 53, Protocol: v1.ProtocolTCP},
+		{Name: "dns", Port: 53, Protocol: v1.ProtocolUDP, NodePort: 30999},
+	}
+	got := preserveNodePorts(current, desired)
+	want := []int32{30080, 0, 30999}
+	for i, p := range got {
+		if p.NodePort != want[i] {
+			t.Errorf("node port of %s = %d, want %d", p.Name, p.NodePort, want[i])
+		}
+	}
+	if desired[0].NodePort != 0 {
+		t.Error("preserveNodePorts modified desired")
+	}
+}
+
+func TestContainerPortsAddedAndRemoved(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+
+	deploy := f.getDeployment("web")
+	deploy.Spec.Template.Spec.Containers = append(deploy.Spec.Template.Spec.Containers, v1.Container{
+		Name:  "sidecar",
+		Image: "sidecar",
+		Ports: []v1.ContainerPort{{ContainerPort: 9090}},
+	})
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if got := portNumbers(f.service("default", "web-expose").Spec.Ports); !slices.Equal(got, []int32{8080, 9090}) {
+		t.Fatalf("ports after adding a container = %v, want [8080 9090]", got)
+	}
+	f.allocateNodePorts("default", "web-expose", 31080, 31090)
+
+	deploy = f.getDeployment("web")
+	deploy.Spec.Template.Spec.Containers = deploy.Spec.Template.Spec.Containers[:1]
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	svc := f.service("default", "web-expose")
+	if got := portNumbers(svc.Spec.Ports); !slices.Equal(got, []int32{8080}) {
+		t.Fatalf("ports after removing the container = %v, want [8080]", got)
+	}
+	if got := svc.Spec.Ports[0].NodePort; got != 31080 {
+		t.Errorf("node port of 8080 = %d, want 31080", got)
+	}
+
+	f.client.ClearActions()
+	f.mustSync("default/web")
+	if verbs := f.serviceActions(); len(verbs) != 0 {
+		t.Errorf("service actions of a no-op sync = %v, want none", verbs)
+	}
+}
+
+func TestNoFallbackPort(t *testing.T) {
+	deploy := newDeployment("web")
+	deploy.Annotations[noFallbackPortAnnotation] = "true"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	if f.service("default", "web-expose") != nil {
+		t.Error("service was created without declared ports and the fallback disabled")
+	}
+	if events := f.events(); !hasEvent(events, v1.EventTypeWarning, "NoPorts") {
+		t.Errorf("events = %q, want a NoPorts warning", events)
+	}
+}
+
+func TestExcludePorts(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []int32
+	}{
+		{name: "by name", annotations: map[string]string{excludePortNamesAnnotation: "metrics,debug"}, want: []int32{3000, 8080}},
+		{name: "by number", annotations: map[string]string{excludePortsAnnotation: "3000"}, want: []int32{6060, 8080, 9090}},
+		{name: "by name and number", annotations: map[string]string{excludePortNamesAnnotation: "metrics", excludePortsAnnotation: "6060,3000"}, want: []int32{8080}},
+		{name: "every port", annotations: map[string]string{excludePortNamesAnnotation: "http,metrics,debug", excludePortsAnnotation: "3000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy := newDeployment("web",
+				v1.ContainerPort{Name: "http", ContainerPort: 8080},
+				v1.ContainerPort{Name: "metrics", ContainerPort: 9090},
+				v1.ContainerPort{Name: "debug", ContainerPort: 6060},
+				v1.ContainerPort{ContainerPort: 3000},
+			)
+			for k, v := range tt.annotations {
+				deploy.Annotations[k] = v
+			}
+			f := newFixture(t, Options{}, deploy)
+			f.mustSync("default/web")
+
+			svc := f.service("default", "web-expose")
+			if tt.want == nil {
+				if svc != nil {
+					t.Errorf("ports = %v, want no service", portNumbers(svc.Spec.Ports))
+				}
+				return
+			}
+			if svc == nil {
+				t.Fatal("service was not created")
+			}
+			if got := portNumbers(svc.Spec.Ports); !slices.Equal(got, tt.want) {
+				t.Errorf("ports = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmbiguousPortPolicy(t *testing.T) {
+	tests := []struct {
+		policy      AmbiguousPortPolicy
+		wantPorts   []int32
+		wantEvent   string
+		wantDropped bool
+	}{
+		{policy: AmbiguousPortSkip, wantEvent: "AmbiguousPorts"},
+		{policy: AmbiguousPortDefault, wantPorts: []int32{fallbackPort}},
+		{policy: AmbiguousPortError, wantEvent: "ReconcileFailed", wantDropped: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			nonRetryableErrorsTotal.Reset()
+			t.Cleanup(nonRetryableErrorsTotal.Reset)
+			deploy := newDeployment("web")
+			deploy.Spec.Template.Spec.Containers = append(deploy.Spec.Template.Spec.Containers, v1.Container{Name: "sidecar", Image: "sidecar"})
+			f := newFixture(t, Options{AmbiguousPortPolicy: tt.policy}, deploy)
+			f.immediateRetries()
+			f.refresh()
+
+			f.c.queue.Add("default/web")
+			f.c.processItem(context.Background())
+
+			svc := f.service("default", "web-expose")
+			if tt.wantPorts == nil && svc != nil {
+				t.Errorf("ports = %v, want no service", portNumbers(svc.Spec.Ports))
+			}
+			if tt.wantPorts != nil && (svc == nil || !slices.Equal(portNumbers(svc.Spec.Ports), tt.wantPorts)) {
+				t.Errorf("service = %+v, want ports %v", svc, tt.wantPorts)
+			}
+			if events := f.events(); tt.wantEvent != "" && !hasEvent(events, v1.EventTypeWarning, tt.wantEvent) {
+				t.Errorf("events = %q, want a %s warning", events, tt.wantEvent)
+			}
+			dropped := testutil.ToFloat64(nonRetryableErrorsTotal.WithLabelValues("default", "ambiguous_ports"))
+			if (dropped == 1) != tt.wantDropped {
+				t.Errorf("non-retryable errors = %v, want dropped %v", dropped, tt.wantDropped)
+			}
+			if n := f.c.queue.Len(); n != 0 {
+				t.Errorf("queue length = %d, want the key not requeued", n)
+			}
+		})
+	}
+}
+
+func TestPreferProbePort(t *testing.T) {
+	ports := []v1.ContainerPort{{Name: "http", ContainerPort: 8080}, {Name: "health", ContainerPort: 8081}}
+	tests := []struct {
+		name  string
+		opts  Options
+		probe *v1.Probe
+		want  []string
+	}{
+		{name: "disabled", probe: &v1.Probe{ProbeHandler: v1.ProbeHandler{HTTPGet: &v1.HTTPGetAction{Port: intstr.FromString("health")}}}, want: []string{"http:8080->http", "health:8081->health"}},
+		{name: "named http probe", opts: Options{PreferProbePort: true}, probe: &v1.Probe{ProbeHandler: v1.ProbeHandler{HTTPGet: &v1.HTTPGetAction{Port: intstr.FromString("health")}}}, want: []string{"health:8081->health"}},
+		{name: "numbered tcp probe", opts: Options{PreferProbePort: true}, probe: &v1.Probe{ProbeHandler: v1.ProbeHandler{TCPSocket: &v1.TCPSocketAction{Port: intstr.FromInt32(8080)}}}, want: []string{"http:8080->http"}},
+		{name: "undeclared probe port", opts: Options{PreferProbePort: true}, probe: &v1.Probe{ProbeHandler: v1.ProbeHandler{TCPSocket: &v1.TCPSocketAction{Port: intstr.FromInt32(9000)}}}, want: []string{"port-9000:9000->9000"}},
+		{name: "exec probe", opts: Options{PreferProbePort: true}, probe: &v1.Probe{ProbeHandler: v1.ProbeHandler{Exec: &v1.ExecAction{Command: []string{"true"}}}}, want: []string{"http:8080->http", "health:8081->health"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy := newDeployment("web", ports...)
+			deploy.Spec.Template.Spec.Containers[0].ReadinessProbe = tt.probe
+			f := newFixture(t, tt.opts, deploy)
+			f.mustSync("default/web")
+
+			var got []string
+			for _, p := range f.service("default", "web-expose").Spec.Ports {
+				got = append(got, fmt.Sprintf("%s:%d->%s", p.Name, p.Port, p.TargetPort.String()))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ports = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPortOverride(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        string
+	}{
+		{name: "tls sidecar", annotations: map[string]string{portAnnotation: "443", targetPortAnnotation: "8443"}, want: "port-443:443->8443"},
+		{name: "numeric target port", annotations: map[string]string{portAnnotation: "80", targetPortAnnotation: "9000"}, want: "port-80:80->9000"},
+		{name: "named target port", annotations: map[string]string{portAnnotation: "80", targetPortAnnotation: "sidecar"}, want: "port-80:80->sidecar"},
+		{name: "port alone targets the first container port", annotations: map[string]string{portAnnotation: "443"}, want: "port-443:443->http"},
+		{name: "out of range port", annotations: map[string]string{portAnnotation: "70000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy := newDeployment("web", v1.ContainerPort{Name: "http", ContainerPort: 8080}, v1.ContainerPort{Name: "sidecar", ContainerPort: 9000})
+			for k, v := range tt.annotations {
+				deploy.Annotations[k] = v
+			}
+			f := newFixture(t, Options{}, deploy)
+			f.mustSync("default/web")
+
+			svc := f.service("default", "web-expose")
+			if tt.want == "" {
+				if svc != nil {
+					t.Errorf("service was created with ports %v despite an invalid override", portNumbers(svc.Spec.Ports))
+				}
+				return
+			}
+			var got []string
+			for _, p := range svc.Spec.Ports {
+				got = append(got, fmt.Sprintf("%s:%d->%s", p.Name, p.Port, p.TargetPort.String()))
+			}
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("ports = %v, want [%s]", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPortList(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{Name: "http", ContainerPort: 8080}, v1.ContainerPort{Name: "grpc", ContainerPort: 9090})
+	deploy.Annotations[portsAnnotation] = "http:80->8080,bad:x->1,grpc:9090->grpc"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	svc := f.service("default", "web-expose")
+	if svc == nil {
+		t.Fatal("service was not created")
+	}
+	var got []string
+	for _, p := range svc.Spec.Ports {
+		got = append(got, fmt.Sprintf("%s:%d->%s", p.Name, p.Port, p.TargetPort.String()))
+	}
+	if want := []string{"http:80->8080", "grpc:9090->grpc"}; !slices.Equal(got, want) {
+		t.Errorf("ports = %v, want %v", got, want)
+	}
+	if !hasEvent(f.events(), v1.EventTypeWarning, "InvalidAnnotation") {
+		t.Error("no InvalidAnnotation event for the malformed entry")
+	}
+}
+
+func TestPortOverrideChangeUpdatesService(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8443})
+	deploy.Annotations[portAnnotation] = "443"
+	deploy.Annotations[targetPortAnnotation] = "8443"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	tests := []struct {
+		name       string
+		annotation string
+		value      string
+		want       string
+	}{
+		{name: "target port", annotation: targetPortAnnotation, value: "9443", want: "443->9443"},
+		{name: "port", annotation: portAnnotation, value: "8000", want: "8000->9443"},
+	}
+	for _, tt := range tests {
+		deploy := f.getDeployment("web")
+		deploy.Annotations[tt.annotation] = tt.value
+		f.updateDeployment(deploy)
+		f.mustSync("default/web")
+
+		p := f.service("default", "web-expose").Spec.Ports[0]
+		if got := fmt.Sprintf("%d->%s", p.Port, p.TargetPort.String()); got != tt.want {
+			t.Errorf("after changing the %s: port = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPinnedNodePort(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080}, v1.ContainerPort{ContainerPort: 9090})
+	deploy.Annotations[nodePortAnnotation] = "30080"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+	if got := f.service("default", "web-expose").Spec.Ports[0].NodePort; got != 30080 {
+		t.Fatalf("node port = %d, want the pinned 30080", got)
+	}
+
+	// The pin wins over the NodePort the Service currently has.
+	f.allocateNodePorts("default", "web-expose", 31234, 31090)
+	f.mustSync("default/web")
+	svc := f.service("default", "web-expose")
+	if got := svc.Spec.Ports[0].NodePort; got != 30080 {
+		t.Errorf("node port after drift = %d, want the pinned 30080", got)
+	}
+	if got := svc.Spec.Ports[1].NodePort; got != 31090 {
+		t.Errorf("node port of the unpinned port = %d, want the allocated 31090", got)
+	}
+
+	// Changing the pin moves the NodePort.
+	deploy = f.getDeployment("web")
+	deploy.Annotations[nodePortAnnotation] = "30081"
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if got := f.service("default", "web-expose").Spec.Ports[0].NodePort; got != 30081 {
+		t.Errorf("node port after changing the pin = %d, want 30081", got)
+	}
+}
+
+func TestPinnedNodePortIgnoredForClusterIP(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Annotations[nodePortAnnotation] = "30080"
+	deploy.Annotations[serviceTypeAnnotation] = "ClusterIP"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+	if got := f.service("default", "web-expose").Spec.Ports[0].NodePort; got != 0 {
+		t.Errorf("node port of a ClusterIP service = %d, want none", got)
+	}
+}
+
+func TestAppProtocol(t *testing.T) {
+	ports := []v1.ContainerPort{
+		{Name: "dns", ContainerPort: 53},
+		{Name: "grpc-udp", ContainerPort: 5000, Protocol: v1.ProtocolUDP},
+		{Name: "http-api", ContainerPort: 8080},
+		{Name: "grpc", ContainerPort: 9090},
+	}
+	tests := []struct {
+		name     string
+		explicit string
+		want     []string
+	}{
+		{name: "heuristic", want: []string{"", "", "http", "grpc"}},
+		{name: "explicit", explicit: "kubernetes.io/h2c", want: []string{"kubernetes.io/h2c", "kubernetes.io/h2c", "kubernetes.io/h2c", "kubernetes.io/h2c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy := newDeployment("web", ports...)
+			if tt.explicit != "" {
+				deploy.Annotations[appProtocolAnnotation] = tt.explicit
+			}
+			f := newFixture(t, Options{}, deploy)
+			f.mustSync("default/web")
+
+			var got []string
+			for _, p := range f.service("default", "web-expose").Spec.Ports {
+				var proto string
+				if p.AppProtocol != nil {
+					proto = *p.AppProtocol
+				}
+				got = append(got, proto)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("app protocols = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppProtocolChangeUpdatesService(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{Name: "grpc", ContainerPort: 9090}))
+	f.mustSync("default/web")
+
+	deploy := f.getDeployment("web")
+	deploy.Annotations[appProtocolAnnotation] = "kubernetes.io/h2c"
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if got := f.service("default", "web-expose").Spec.Ports[0].AppProtocol; got == nil || *got != "kubernetes.io/h2c" {
+		t.Errorf("app protocol after setting the annotation = %v, want kubernetes.io/h2c", got)
+	}
+}
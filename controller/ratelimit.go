@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// RateLimiterOptions tunes the queue's rate limiter. The defaults match
+// workqueue.DefaultControllerRateLimiter.
+type RateLimiterOptions struct {
+	// BaseDelay is the first per-key retry delay, doubled on every failure.
+	BaseDelay time.Duration
+	// MaxDelay caps the per-key retry delay.
+	MaxDelay time.Duration
+	// Jitter spreads each per-key delay by up to this fraction of it, so
+	// keys that failed together do not retry in lockstep.
+	Jitter float64
+	// QPS and Burst bound the overall rate of requeues.
+	QPS   float64
+	Burst int
+}
+
+// DefaultRateLimiterOptions are the options of DefaultControllerRateLimiter,
+// plus 10% jitter.
+var DefaultRateLimiterOptions = RateLimiterOptions{
+	BaseDelay: 5 * time.Millisecond,
+	MaxDelay:  1000 * time.Second,
+	Jitter:    0.1,
+	QPS:       10,
+	Burst:     100,
+}
+
+// NewRateLimiter builds a per-key exponential backoff with jitter combined
+// with an overall token bucket; the longer of the two delays applies.
+func NewRateLimiter(opts RateLimiterOptions) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		&jitterRateLimiter{
+			RateLimiter: workqueue.NewItemExponentialFailureRateLimiter(opts.BaseDelay, opts.MaxDelay),
+			jitter:      opts.Jitter,
+		},
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(opts.QPS), opts.Burst)},
+	)
+}
+
+// jitterRateLimiter adds random jitter to the delays of a rate limiter.
+type jitterRateLimiter struct {
+	workqueue.RateLimiter
+	jitter float64
+}
+
+func (r *jitterRateLimiter) When(item interface{}) time.Duration {
+	d := r.RateLimiter.When(item)
+	if r.jitter <= 0 {
+		return d
+	}
+	return wait.Jitter(d, r.jitter)
+}
@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestRateLimiterDelays(t *testing.T) {
+	limiter := NewRateLimiter(RateLimiterOptions{BaseDelay: 10 * time.Millisecond, MaxDelay: 40 * time.Millisecond, QPS: 1000, Burst: 1000})
+
+	for i, want := range []time.Duration{10, 20, 40, 40} {
+		if got := limiter.When("default/web"); got != want*time.Millisecond {
+			t.Errorf("delay of failure %d = %v, want %v", i+1, got, want*time.Millisecond)
+		}
+	}
+	limiter.Forget("default/web")
+	if got := limiter.When("default/web"); got != 10*time.Millisecond {
+		t.Errorf("delay after forgetting = %v, want the base delay", got)
+	}
+}
+
+func TestRateLimiterJitter(t *testing.T) {
+	limiter := NewRateLimiter(RateLimiterOptions{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Jitter: 0.5, QPS: 1000, Burst: 1000})
+	for i := range 20 {
+		key := string(rune('a' + i))
+		if got := limiter.When(key); got < 100*time.Millisecond || got > 150*time.Millisecond {
+			t.Errorf("jittered delay = %v, want 100ms to 150ms", got)
+		}
+	}
+}
+
+func TestRateLimiterBucket(t *testing.T) {
+	limiter := NewRateLimiter(RateLimiterOptions{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, QPS: 1, Burst: 1})
+	limiter.When("default/api")
+	if got := limiter.When("default/web"); got < 500*time.Millisecond {
+		t.Errorf("delay past the burst = %v, want the bucket to hold the key back", got)
+	}
+}
+
+func TestRateLimitedRequeueTiming(t *testing.T) {
+	queue := workqueue.NewRateLimitingQueue(NewRateLimiter(RateLimiterOptions{BaseDelay: 50 * time.Millisecond, MaxDelay: time.Second, QPS: 1000, Burst: 1000}))
+	defer queue.ShutDown()
+
+	start := time.Now()
+	queue.AddRateLimited("default/web")
+	key, _ := queue.Get()
+	queue.Done(key)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("requeued key was ready after %v, want the 50ms base delay", elapsed)
+	}
+}
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// readinessGateRecheck is how often a Deployment waiting on its readiness
+// gate is reconciled again.
+const readinessGateRecheck = 10 * time.Second
+
+// readinessGatePassed reports whether at least one Pod of wl has the
+// gate condition set to True.
+func (c *Controller) readinessGatePassed(wl *workload, gate string) (bool, error) {
+	selector, err := metav1.LabelSelectorAsSelector(wl.selector)
+	if err != nil {
+		return false, fmt.Errorf("invalid selector on %s %s/%s: %v", strings.ToLower(wl.kind), wl.Namespace, wl.Name, err)
+	}
+
+	pods, err := c.podLister.Pods(wl.Namespace).List(selector)
+	if err != nil {
+		return false, fmt.Errorf("failed to list pods of %s %s/%s: %v", strings.ToLower(wl.kind), wl.Namespace, wl.Name, err)
+	}
+
+	for _, pod := range pods {
+		for _, cond := range pod.Status.Conditions {
+			if string(cond.Type) == gate && cond.Status == v1.ConditionTrue {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// waitingOnReadinessGate reports whether creating the Service must wait for
+// gate. Only creation is deferred: an existing Service is kept up to date
+// even if the gate later turns false.
+func (c *Controller) waitingOnReadinessGate(ctx context.Context, wl *workload, gate, svcName string) (bool, error) {
+	if c.podLister == nil {
+		klog.FromContext(ctx).Info("Readiness gates are not enabled, ignoring annotation", "annotation", readinessGateAnnotation)
+		return false, nil
+	}
+
+	_, err := c.serviceLister.Services(wl.Namespace).Get(svcName)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to get service %s/%s: %v", wl.Namespace, svcName, err)
+	}
+
+	passed, err := c.readinessGatePassed(wl, gate)
+	if err != nil {
+		return false, err
+	}
+	return !passed, nil
+}
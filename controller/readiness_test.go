@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReadinessGate(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Annotations[readinessGateAnnotation] = "db-ready"
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default", Labels: map[string]string{"app": "web"}},
+		Status: v1.PodStatus{Conditions: []v1.PodCondition{
+			{Type: v1.PodReady, Status: v1.ConditionTrue},
+			{Type: "db-ready", Status: v1.ConditionFalse},
+		}},
+	}
+	f := newFixture(t, Options{ReadinessGates: true}, deploy, pod)
+
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") != nil {
+		t.Fatal("service was created before the readiness gate passed")
+	}
+
+	pod.Status.Conditions[1].Status = v1.ConditionTrue
+	if _, err := f.client.CoreV1().Pods("default").UpdateStatus(context.Background(), pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating pod: %v", err)
+	}
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") == nil {
+		t.Fatal("service was not created once the readiness gate passed")
+	}
+
+	// Only creation waits on the gate.
+	pod.Status.Conditions[1].Status = v1.ConditionFalse
+	if _, err := f.client.CoreV1().Pods("default").UpdateStatus(context.Background(), pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("updating pod: %v", err)
+	}
+	f.mustSync("default/web")
+	if f.service("default", "web-expose") == nil {
+		t.Error("service was removed when the readiness gate turned false")
+	}
+}
+
+func TestReadinessGateIgnoredWhenDisabled(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	deploy.Annotations[readinessGateAnnotation] = "db-ready"
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	if f.service("default", "web-expose") == nil {
+		t.Error("service was not created with readiness gates disabled")
+	}
+}
@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// ImmutableChangePolicy decides what happens when the API server rejects a
+// Service update as invalid, typically because it changes an immutable
+// field.
+type ImmutableChangePolicy string
+
+const (
+	// ImmutableChangeUpdate reports the failed update and retries it.
+	ImmutableChangeUpdate ImmutableChangePolicy = "update"
+	// ImmutableChangeRecreate deletes the Service and creates it again.
+	ImmutableChangeRecreate ImmutableChangePolicy = "recreate"
+)
+
+// ParseImmutableChangePolicy validates a policy name.
+func ParseImmutableChangePolicy(s string) (ImmutableChangePolicy, error) {
+	switch p := ImmutableChangePolicy(s); p {
+	case ImmutableChangeUpdate, ImmutableChangeRecreate:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid immutable change policy %q, must be one of update, recreate", s)
+}
+
+// recreateService replaces a Service whose update was rejected with a fresh
+// one built from desired. The create does not adopt an existing Service, so
+// one still being deleted fails the reconcile and is retried.
+func (c *Controller) recreateService(ctx context.Context, wl *workload, desired *v1.Service, namespace, svcName string) (err error) {
+	ctx, span := tracer.Start(ctx, "RecreateService")
+	defer func() { endSpan(span, err) }()
+
+	services := c.clientset.CoreV1().Services(namespace)
+	if err := services.Delete(ctx, svcName, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete service %s/%s for recreation: %v", namespace, svcName, err)
+	}
+	if _, err := services.Create(ctx, desired, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to recreate service %s/%s: %v", namespace, svcName, err)
+	}
+
+	klog.FromContext(ctx).Info("Service recreated", "service", svcName)
+	c.event(wl, v1.EventTypeNormal, "ServiceRecreated", "Recreated service %s after an immutable field changed", svcName)
+	return nil
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+)
+
+// plannedChange is what a dry run found reconciling a workload would do to
+// one of its Services.
+type plannedChange struct {
+	service string
+	action  serviceAction
+	// diff is from the current to the desired Service, empty unless the
+	// Service would change.
+	diff string
+}
+
+// pending reports whether applying the change would modify the cluster.
+func (p plannedChange) pending() bool {
+	return p.action == serviceCreate || p.action == serviceUpdate || p.action == serviceDelete
+}
+
+func (p plannedChange) String() string {
+	switch p.action {
+	case serviceCreate:
+		return fmt.Sprintf("Service %s would be created", p.service)
+	case serviceUpdate:
+		return fmt.Sprintf("Service %s would be updated", p.service)
+	case serviceDelete:
+		return fmt.Sprintf("Service %s would be deleted", p.service)
+	case serviceConflict:
+		return fmt.Sprintf("Service %s is not managed by this controller and is left alone", p.service)
+	case serviceCollision:
+		return fmt.Sprintf("Service %s belongs to another workload and is left alone", p.service)
+	default:
+		return fmt.Sprintf("Service %s is unchanged", p.service)
+	}
+}
+
+// reportVisited starts the report of key afresh as a reconcile of it
+// begins, so the report lists key even if it has no Service and a key
+// reconciled again does not accumulate changes.
+func (c *Controller) reportVisited(key string) {
+	if !c.reporting.Load() {
+		return
+	}
+	c.reportMu.Lock()
+	defer c.reportMu.Unlock()
+	c.report[key] = nil
+}
+
+// recordPlan notes, while RunOnce collects a dry-run report, the action
+// planned for the Service of key: current is the Service in the cluster,
+// nil if there is none, and desired the Service the controller wants, nil
+// if it wants none.
+func (c *Controller) recordPlan(key string, current, desired *v1.Service, action serviceAction) {
+	if !c.reporting.Load() {
+		return
+	}
+	change := plannedChange{action: action}
+	switch action {
+	case serviceCreate:
+		change.diff = cmp.Diff(v1.Service{}, *desired)
+	case serviceUpdate:
+		change.diff = cmp.Diff(*current, *c.mergeService(current, desired))
+	case serviceDelete:
+		change.diff = cmp.Diff(*current, v1.Service{})
+	}
+	if desired != nil {
+		change.service = desired.Name
+	} else {
+		change.service = current.Name
+	}
+
+	c.reportMu.Lock()
+	defer c.reportMu.Unlock()
+	c.report[key] = append(c.report[key], change)
+}
+
+// WriteDryRunReport writes, for every workload reconciled in dry-run mode,
+// what would happen to its Services, with a diff of each pending change.
+// It reports whether any change is pending.
+func (c *Controller) WriteDryRunReport(w io.Writer) (bool, error) {
+	c.reportMu.Lock()
+	defer c.reportMu.Unlock()
+
+	keys := make([]string, 0, len(c.report))
+	for key := range c.report {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pending := false
+	var b strings.Builder
+	for _, key := range keys {
+		changes := c.report[key]
+		if len(changes) == 0 {
+			fmt.Fprintf(&b, "%s: no Service\n", key)
+			continue
+		}
+		for _, change := range changes {
+			fmt.Fprintf(&b, "%s: %s\n", key, change)
+			if !change.pending() {
+				continue
+			}
+			pending = true
+			for _, line := range strings.Split(strings.TrimRight(change.diff, "\n"), "\n") {
+				fmt.Fprintf(&b, "    %s\n", line)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return pending, err
+}
@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestDryRunReport(t *testing.T) {
+	// Reconcile the existing state for real first.
+	same := newDeployment("same", v1.ContainerPort{ContainerPort: 8080})
+	drifted := newDeployment("drifted", v1.ContainerPort{ContainerPort: 8080})
+	gone := newDeployment("gone", v1.ContainerPort{ContainerPort: 8080})
+	live := newFixture(t, Options{}, same, drifted, gone)
+	for _, key := range []string{"default/same", "default/drifted", "default/gone"} {
+		live.mustSync(key)
+	}
+	services, err := live.client.CoreV1().Services("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("listing services: %v", err)
+	}
+
+	objects := []runtime.Object{same, drifted, newDeployment("new", v1.ContainerPort{ContainerPort: 8080})}
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if svc.Name == "drifted-expose" {
+			svc.Spec.Selector = map[string]string{"app": "other"}
+		}
+		objects = append(objects, svc)
+	}
+	delete(gone.Annotations, enabledAnnotation)
+	plain := newDeployment("plain")
+	delete(plain.Annotations, enabledAnnotation)
+	objects = append(objects, gone, plain)
+
+	f := newFixture(t, Options{DryRun: true}, objects...)
+	f.refresh()
+	if failed := f.c.RunOnce(context.Background(), 2); failed != 0 {
+		t.Fatalf("%d reconciles failed", failed)
+	}
+
+	var out strings.Builder
+	pending, err := f.c.WriteDryRunReport(&out)
+	if err != nil {
+		t.Fatalf("WriteDryRunReport: %v", err)
+	}
+	if !pending {
+		t.Error("report has no pending changes")
+	}
+	report := out.String()
+	for _, want := range []string{
+		"default/drifted: Service drifted-expose would be updated\n",
+		"default/gone: Service gone-expose would be deleted\n",
+		"default/new: Service new-expose would be created\n",
+		"default/plain: no Service\n",
+		"default/same: Service same-expose is unchanged\n",
+		`"app": "other"`,
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report lacks %q:\n%s", want, report)
+		}
+	}
+
+	// Nothing was applied.
+	if f.service("default", "new-expose") != nil || f.service("default", "gone-expose") == nil {
+		t.Error("dry run changed the services")
+	}
+}
+
+func TestDryRunReportWithoutChanges(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	live := newFixture(t, Options{}, deploy)
+	live.mustSync("default/web")
+
+	f := newFixture(t, Options{DryRun: true}, deploy, live.service("default", "web-expose"))
+	f.refresh()
+	f.c.RunOnce(context.Background(), 1)
+
+	var out strings.Builder
+	pending, err := f.c.WriteDryRunReport(&out)
+	if err != nil {
+		t.Fatalf("WriteDryRunReport: %v", err)
+	}
+	if pending {
+		t.Errorf("unchanged service reported as pending:\n%s", out.String())
+	}
+	if want := "default/web: Service web-expose is unchanged\n"; out.String() != want {
+		t.Errorf("report = %q, want %q", out.String(), want)
+	}
+}
+
+func TestDryRunReportReplacedOnEverySync(t *testing.T) {
+	f := newFixture(t, Options{DryRun: true}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+	f.mustSync("default/web")
+	if len(f.c.report) != 0 {
+		t.Errorf("dry run outside RunOnce collected a report: %v", f.c.report)
+	}
+
+	f.c.reporting.Store(true)
+	f.mustSync("default/web")
+	f.mustSync("default/web")
+	if changes := f.c.report["default/web"]; len(changes) != 1 {
+		t.Errorf("report after two syncs = %v, want one change", changes)
+	}
+}
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// lastReconcileAnnotation records on the Deployment when the outcome of
+	// its reconciles last changed, in RFC3339.
+	lastReconcileAnnotation = annotationPrefix + "last-reconcile"
+	// statusAnnotation records the outcome of that reconcile.
+	statusAnnotation = annotationPrefix + "status"
+)
+
+// Reconcile outcomes recorded in the status annotation.
+const (
+	statusExposed = "Exposed"
+	statusSkipped = "Skipped"
+	statusError   = "Error"
+)
+
+// recordStatus patches the outcome of a reconcile onto an opted-in
+// Deployment when it differs from the recorded one, so a resync that
+// changes nothing does not write the Deployment. The status annotations of
+// a Deployment that is no longer opted in are removed. Failures are logged
+// rather than failing the reconcile, since the status is informational.
+func (c *Controller) recordStatus(ctx context.Context, wl *workload, enabled, exposed bool, syncErr error) {
+	if c.opts.DryRun || c.opts.OutputDir != "" || wl.DeletionTimestamp != nil || wl.kind != deploymentKind {
+		return
+	}
+
+	annotations := map[string]interface{}{}
+	switch {
+	case !enabled:
+		_, hasStatus := wl.Annotations[statusAnnotation]
+		_, hasLast := wl.Annotations[lastReconcileAnnotation]
+		if !hasStatus && !hasLast {
+			return
+		}
+		annotations[statusAnnotation] = nil
+		annotations[lastReconcileAnnotation] = nil
+	default:
+		status := statusSkipped
+		if syncErr != nil {
+			status = statusError
+		} else if exposed {
+			status = statusExposed
+		}
+		if _, hasLast := wl.Annotations[lastReconcileAnnotation]; hasLast && wl.Annotations[statusAnnotation] == status {
+			return
+		}
+		annotations[statusAnnotation] = status
+		annotations[lastReconcileAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	// Marshalling plain maps cannot fail.
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	_, err := c.clientset.AppsV1().Deployments(wl.Namespace).Patch(ctx, wl.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		klog.FromContext(ctx).Error(err, "Failed to record reconcile status")
+	}
+}
+
+// onlyStatusChanged reports whether the only difference between two
+// versions of a Deployment is in its status annotations, i.e. the update was
+// caused by recordStatus and needs no reconcile.
+func onlyStatusChanged(oldDeploy, newDeploy *appsv1.Deployment) bool {
+	strip := func(d *appsv1.Deployment) *appsv1.Deployment {
+		d = d.DeepCopy()
+		d.ResourceVersion = ""
+		d.ManagedFields = nil
+		delete(d.Annotations, statusAnnotation)
+		delete(d.Annotations, lastReconcileAnnotation)
+		return d
+	}
+	return equality.Semantic.DeepEqual(strip(oldDeploy), strip(newDeploy))
+}
@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// deploymentPatches counts the patches of Deployments since the actions
+// were last cleared.
+func (f *fixture) deploymentPatches() int {
+	n := 0
+	for _, action := range f.client.Actions() {
+		if action.GetResource().Resource == "deployments" && action.GetVerb() == "patch" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestStatusRecorded(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	f := newFixture(t, Options{}, deploy)
+	f.mustSync("default/web")
+
+	annotations := f.getDeployment("web").Annotations
+	if got := annotations[statusAnnotation]; got != statusExposed {
+		t.Errorf("status = %q, want %q", got, statusExposed)
+	}
+	last := annotations[lastReconcileAnnotation]
+	if last == "" {
+		t.Error("last reconcile was not recorded")
+	}
+
+	f.client.ClearActions()
+	f.mustSync("default/web")
+	if n := f.deploymentPatches(); n != 0 {
+		t.Errorf("reconcile with an unchanged outcome patched the deployment %d times", n)
+	}
+	if got := f.getDeployment("web").Annotations[lastReconcileAnnotation]; got != last {
+		t.Errorf("last reconcile moved from %q to %q although the outcome did not change", last, got)
+	}
+
+	// No ports to expose: the outcome changes to Skipped.
+	deploy = f.getDeployment("web")
+	deploy.Spec.Template.Spec.Containers[0].Ports = nil
+	deploy.Annotations[noFallbackPortAnnotation] = "true"
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+	if got := f.getDeployment("web").Annotations[statusAnnotation]; got != statusSkipped {
+		t.Errorf("status = %q, want %q", got, statusSkipped)
+	}
+}
+
+func TestStatusRemovedWhenOptedOut(t *testing.T) {
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+
+	deploy := f.getDeployment("web")
+	delete(deploy.Annotations, enabledAnnotation)
+	f.updateDeployment(deploy)
+	f.mustSync("default/web")
+
+	annotations := f.getDeployment("web").Annotations
+	if _, ok := annotations[statusAnnotation]; ok {
+		t.Error("status annotation of an opted-out deployment was kept")
+	}
+	if _, ok := annotations[lastReconcileAnnotation]; ok {
+		t.Error("last reconcile annotation of an opted-out deployment was kept")
+	}
+}
+
+func TestOnlyStatusChanged(t *testing.T) {
+	old := newDeployment("web")
+	withStatus := old.DeepCopy()
+	withStatus.ResourceVersion = "2"
+	withStatus.Annotations[statusAnnotation] = statusExposed
+	scaled := withStatus.DeepCopy()
+	scaled.Spec.Replicas = new(int32)
+
+	tests := []struct {
+		name string
+		new  *appsv1.Deployment
+		want bool
+	}{
+		{name: "status annotations", new: withStatus, want: true},
+		{name: "spec", new: scaled, want: false},
+	}
+	for _, tt := range tests {
+		if got := onlyStatusChanged(old, tt.new); got != tt.want {
+			t.Errorf("%s: onlyStatusChanged = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var (
+	spanExporterOnce sync.Once
+	spanExporter     *tracetest.InMemoryExporter
+)
+
+// recordSpans routes the package tracer to an in-memory exporter and
+// returns it emptied. The global provider can only be installed once, since
+// tracer delegates to the first one.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	spanExporterOnce.Do(func() {
+		spanExporter = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spanExporter)))
+	})
+	spanExporter.Reset()
+	t.Cleanup(spanExporter.Reset)
+	return spanExporter
+}
+
+// spanNamed returns the first ended span called name.
+func spanNamed(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("no %s span among %d spans", name, len(spans))
+	return tracetest.SpanStub{}
+}
+
+func TestReconcileSpan(t *testing.T) {
+	exporter := recordSpans(t)
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.mustSync("default/web")
+
+	spans := exporter.GetSpans()
+	reconcile := spanNamed(t, spans, "syncHandler")
+	want := map[attribute.Key]string{"kind": deploymentKind, "namespace": "default", "name": "web"}
+	got := map[attribute.Key]string{}
+	for _, attr := range reconcile.Attributes {
+		got[attr.Key] = attr.Value.AsString()
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("attribute %s = %q, want %q", k, got[k], v)
+		}
+	}
+	if reconcile.Status.Code == codes.Error {
+		t.Errorf("successful reconcile has status %v", reconcile.Status)
+	}
+
+	create := spanNamed(t, spans, "CreateService")
+	if create.Parent.SpanID() != reconcile.SpanContext.SpanID() {
+		t.Error("CreateService span is not a child of the reconcile span")
+	}
+}
+
+func TestReconcileSpanRecordsError(t *testing.T) {
+	exporter := recordSpans(t)
+	f := newFixture(t, Options{}, newDeployment("web", v1.ContainerPort{ContainerPort: 8080}))
+	f.client.PrependReactor("create", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("api server unavailable")
+	})
+	if err := f.sync("default/web"); err == nil {
+		t.Fatal("sync succeeded although creating the service failed")
+	}
+
+	spans := exporter.GetSpans()
+	for _, name := range []string{"syncHandler", "CreateService"} {
+		if span := spanNamed(t, spans, name); span.Status.Code != codes.Error {
+			t.Errorf("%s span status = %v, want Error", name, span.Status)
+		}
+	}
+}
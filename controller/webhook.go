@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// maxAdmissionReviewBytes bounds the request body a webhook call may send.
+const maxAdmissionReviewBytes = 3 << 20
+
+// ValidateAnnotations checks expose annotations with the same rules the
+// reconcile applies. It returns the problems that make a value unusable and,
+// separately, notices about values that parse but may be a mistake, such as
+// an annotation another one overrides. Only problems deny admission.
+func ValidateAnnotations(annotations map[string]string) (problems, notices []string) {
+	_, p := parseAnnotations(annotations)
+	return p.warnings, p.notices
+}
+
+// WebhookHandler serves a validating admission webhook for Deployments. It
+// denies creates and updates whose expose annotations are malformed and
+// passes notices back as admission warnings.
+func WebhookHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var review admissionv1.AdmissionReview
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdmissionReviewBytes)).Decode(&review); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode admission review: %v", err), http.StatusBadRequest)
+			return
+		}
+		if review.Request == nil {
+			http.Error(w, "admission review has no request", http.StatusBadRequest)
+			return
+		}
+
+		review.Response = admit(review.Request)
+		review.Request = nil
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(review); err != nil {
+			klog.FromContext(r.Context()).Error(err, "Error writing admission response")
+		}
+	})
+}
+
+// admit decides a single admission request.
+func admit(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return resp
+	}
+
+	var deploy appsv1.Deployment
+	if err := json.Unmarshal(req.Object.Raw, &deploy); err != nil {
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("failed to decode deployment: %v", err),
+		}
+		return resp
+	}
+
+	// Updates that leave the expose annotations alone are admitted as they
+	// are, so a Deployment admitted before the webhook existed, or before
+	// a rule was tightened, can still be scaled, rolled out, and have its
+	// finalizer and status annotations written by the controller.
+	if req.Operation == admissionv1.Update && len(req.OldObject.Raw) > 0 {
+		var old appsv1.Deployment
+		if err := json.Unmarshal(req.OldObject.Raw, &old); err == nil &&
+			equality.Semantic.DeepEqual(exposeAnnotations(old.Annotations), exposeAnnotations(deploy.Annotations)) {
+			return resp
+		}
+	}
+
+	problems, notices := ValidateAnnotations(deploy.Annotations)
+	resp.Warnings = notices
+	if len(problems) > 0 {
+		klog.V(4).InfoS("Denying deployment", "deployment", klog.KRef(req.Namespace, deploy.Name), "problems", problems)
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Code:    http.StatusUnprocessableEntity,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: "invalid expose annotations: " + strings.Join(problems, "; "),
+		}
+	}
+	return resp
+}
+
+// exposeAnnotations returns the annotations of m that configure the
+// controller, leaving out the status annotations it records itself.
+func exposeAnnotations(m map[string]string) map[string]string {
+	out := map[string]string{}
+	for k, v := range m {
+		if strings.HasPrefix(k, annotationPrefix) && k != statusAnnotation && k != lastReconcileAnnotation {
+			out[k] = v
+		}
+	}
+	return out
+}
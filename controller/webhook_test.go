@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// deploymentJSON encodes a Deployment with the given annotations.
+func deploymentJSON(t *testing.T, annotations map[string]string) runtime.RawExtension {
+	t.Helper()
+	deploy := newDeployment("web")
+	deploy.Annotations = annotations
+	raw, err := json.Marshal(deploy)
+	if err != nil {
+		t.Fatalf("encoding deployment: %v", err)
+	}
+	return runtime.RawExtension{Raw: raw}
+}
+
+// review posts an AdmissionReview for the Deployment with the given
+// annotations to the webhook and returns the response it sent back.
+func review(t *testing.T, op admissionv1.Operation, annotations map[string]string) *admissionv1.AdmissionResponse {
+	t.Helper()
+	return reviewRequest(t, &admissionv1.AdmissionRequest{
+		Operation: op,
+		Object:    deploymentJSON(t, annotations),
+	})
+}
+
+// reviewRequest posts req to the webhook and returns the response it sent
+// back.
+func reviewRequest(t *testing.T, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	t.Helper()
+	req.UID = types.UID("review-1")
+	req.Namespace = "default"
+	body, err := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request:  req,
+	})
+	if err != nil {
+		t.Fatalf("encoding admission review: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	WebhookHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var got admissionv1.AdmissionReview
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding admission review: %v", err)
+	}
+	if got.Request != nil {
+		t.Error("response echoes the request")
+	}
+	if got.Response == nil || got.Response.UID != "review-1" {
+		t.Fatalf("response = %+v, want one for uid review-1", got.Response)
+	}
+	return got.Response
+}
+
+func TestWebhookAdmission(t *testing.T) {
+	tests := []struct {
+		name        string
+		op          admissionv1.Operation
+		annotations map[string]string
+		allowed     bool
+		// wantMessage is a substring of the denial message.
+		wantMessage string
+		wantWarning string
+	}{
+		{
+			name:        "valid annotations",
+			op:          admissionv1.Create,
+			annotations: map[string]string{enabledAnnotation: "true", serviceTypeAnnotation: "NodePort", nodePortAnnotation: "30080"},
+			allowed:     true,
+		},
+		{
+			name:    "no annotations",
+			op:      admissionv1.Update,
+			allowed: true,
+		},
+		{
+			name:        "notice is a warning",
+			op:          admissionv1.Create,
+			annotations: map[string]string{enabledAnnotation: "true", nodePortAnnotation: "8080"},
+			allowed:     true,
+			wantWarning: "outside the default NodePort range",
+		},
+		{
+			name:        "ports override port",
+			op:          admissionv1.Create,
+			annotations: map[string]string{enabledAnnotation: "true", portsAnnotation: "81", portAnnotation: "80"},
+			allowed:     true,
+			wantWarning: "takes precedence",
+		},
+		{
+			name:        "gateway without host",
+			op:          admissionv1.Create,
+			annotations: map[string]string{enabledAnnotation: "true", gatewayAnnotation: "gw"},
+			allowed:     true,
+			wantWarning: "must be set together",
+		},
+		{
+			name:        "affinity timeout without client ip",
+			op:          admissionv1.Create,
+			annotations: map[string]string{enabledAnnotation: "true", sessionAffinityTimeoutAnnotation: "600"},
+			allowed:     true,
+			wantWarning: "only applies when",
+		},
+		{
+			name:        "invalid service type",
+			op:          admissionv1.Create,
+			annotations: map[string]string{enabledAnnotation: "true", serviceTypeAnnotation: "ExternalName"},
+			wantMessage: serviceTypeAnnotation,
+		},
+		{
+			name:        "invalid update",
+			op:          admissionv1.Update,
+			annotations: map[string]string{enabledAnnotation: "yes"},
+			wantMessage: enabledAnnotation,
+		},
+		{
+			name:        "delete is not checked",
+			op:          admissionv1.Delete,
+			annotations: map[string]string{enabledAnnotation: "yes"},
+			allowed:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := review(t, tt.op, tt.annotations)
+			if resp.Allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v (result %+v)", resp.Allowed, tt.allowed, resp.Result)
+			}
+			if !tt.allowed {
+				if resp.Result == nil || resp.Result.Code != http.StatusUnprocessableEntity || resp.Result.Reason != metav1.StatusReasonInvalid {
+					t.Errorf("result = %+v, want 422 Invalid", resp.Result)
+				} else if !strings.Contains(resp.Result.Message, tt.wantMessage) {
+					t.Errorf("message = %q, want it to mention %q", resp.Result.Message, tt.wantMessage)
+				}
+			}
+			if tt.wantWarning == "" {
+				if len(resp.Warnings) > 0 {
+					t.Errorf("unexpected warnings %q", resp.Warnings)
+				}
+			} else if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], tt.wantWarning) {
+				t.Errorf("warnings = %q, want one containing %q", resp.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestWebhookAdmitsUnchangedAnnotations(t *testing.T) {
+	invalid := map[string]string{enabledAnnotation: "yes"}
+	withStatus := map[string]string{enabledAnnotation: "yes", statusAnnotation: statusError, lastReconcileAnnotation: "2024-01-01T00:00:00Z"}
+	tests := []struct {
+		name     string
+		old, new map[string]string
+		allowed  bool
+	}{
+		{name: "finalizer or spec change", old: invalid, new: invalid, allowed: true},
+		{name: "status annotations written", old: invalid, new: withStatus, allowed: true},
+		{name: "expose annotation changed", old: map[string]string{enabledAnnotation: "true"}, new: invalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := reviewRequest(t, &admissionv1.AdmissionRequest{
+				Operation: admissionv1.Update,
+				Object:    deploymentJSON(t, tt.new),
+				OldObject: deploymentJSON(t, tt.old),
+			})
+			if resp.Allowed != tt.allowed {
+				t.Errorf("allowed = %v, want %v (result %+v)", resp.Allowed, tt.allowed, resp.Result)
+			}
+		})
+	}
+}
+
+func TestWebhookRejectsBadRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+	}{
+		{name: "not a post", method: http.MethodGet, want: http.StatusMethodNotAllowed},
+		{name: "malformed body", method: http.MethodPost, body: "{", want: http.StatusBadRequest},
+		{name: "missing request", method: http.MethodPost, body: `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			WebhookHandler().ServeHTTP(rec, httptest.NewRequest(tt.method, "/validate", strings.NewReader(tt.body)))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
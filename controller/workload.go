@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	deploymentKind  = "Deployment"
+	statefulSetKind = "StatefulSet"
+
+	// statefulSetKeyPrefix marks queue keys of StatefulSets. Keys without
+	// it are Deployment keys.
+	statefulSetKeyPrefix = "statefulset:"
+)
+
+// workloadKey returns the queue key of the kind's object with the given
+// namespace/name key.
+func workloadKey(kind, key string) string {
+	if kind == statefulSetKind {
+		return statefulSetKeyPrefix + key
+	}
+	return key
+}
+
+// splitWorkloadKey splits a queue key into the workload kind and the
+// object's namespace/name key.
+func splitWorkloadKey(key string) (kind, objKey string) {
+	if rest, ok := strings.CutPrefix(key, statefulSetKeyPrefix); ok {
+		return statefulSetKind, rest
+	}
+	return deploymentKind, key
+}
+
+// splitQueueKey returns the namespace and name of a queue key of any kind.
+func splitQueueKey(key string) (namespace, name string, err error) {
+	_, objKey := splitWorkloadKey(key)
+	return cache.SplitMetaNamespaceKey(objKey)
+}
+
+// workload is what reconciling reads of a Deployment or StatefulSet, so
+// both share the Service building and reconcile logic without either
+// posing as the other.
+type workload struct {
+	metav1.ObjectMeta
+	// kind is deploymentKind or statefulSetKind.
+	kind     string
+	selector *metav1.LabelSelector
+	template v1.PodTemplateSpec
+	replicas *int32
+	// available reports whether the workload serves traffic, see
+	// deploymentAvailable and statefulSetWorkload.
+	available bool
+	// object is the Deployment or StatefulSet itself, which events are
+	// recorded on.
+	object runtime.Object
+}
+
+func deploymentWorkload(deploy *appsv1.Deployment) *workload {
+	return &workload{
+		ObjectMeta: deploy.ObjectMeta,
+		kind:       deploymentKind,
+		selector:   deploy.Spec.Selector,
+		template:   deploy.Spec.Template,
+		replicas:   deploy.Spec.Replicas,
+		available:  deploymentAvailable(deploy),
+		object:     deploy,
+	}
+}
+
+// statefulSetWorkload returns the workload of sts. StatefulSets have no
+// Available condition, so one is available once any replica is.
+func statefulSetWorkload(sts *appsv1.StatefulSet) *workload {
+	return &workload{
+		ObjectMeta: sts.ObjectMeta,
+		kind:       statefulSetKind,
+		selector:   sts.Spec.Selector,
+		template:   sts.Spec.Template,
+		replicas:   sts.Spec.Replicas,
+		available:  sts.Status.AvailableReplicas > 0,
+		object:     sts,
+	}
+}
+
+// getWorkload returns the workload behind a queue key from the listers.
+func (c *Controller) getWorkload(kind, namespace, name string) (*workload, error) {
+	if kind == statefulSetKind {
+		if c.statefulSetLister == nil {
+			return nil, fmt.Errorf("statefulsets are not watched")
+		}
+		sts, err := c.statefulSetLister.StatefulSets(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		return statefulSetWorkload(sts), nil
+	}
+	deploy, err := c.deployLister.Deployments(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return deploymentWorkload(deploy), nil
+}
+
+// isWorkloadKind reports whether kind is a workload the controller exposes.
+func isWorkloadKind(kind string) bool {
+	return kind == deploymentKind || kind == statefulSetKind
+}
+
+// statefulSetHandlers enqueues the key of every added, deleted or changed
+// StatefulSet. Status-only updates are skipped.
+func (c *Controller) statefulSetHandlers() cache.ResourceEventHandler {
+	enqueue := func(obj interface{}, event string) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			klog.ErrorS(err, "Error creating key")
+			return
+		}
+		klog.InfoS(event+" event for statefulset", "key", key)
+		c.EnqueueKey(workloadKey(statefulSetKind, key))
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { enqueue(obj, "Add") },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if unchanged(oldObj, newObj) {
+				return
+			}
+			oldSts, oldOK := oldObj.(*appsv1.StatefulSet)
+			newSts, newOK := newObj.(*appsv1.StatefulSet)
+			if oldOK && newOK && !isResync(oldObj, newObj) && oldSts.Generation == newSts.Generation &&
+				equality.Semantic.DeepEqual(oldSts.Labels, newSts.Labels) &&
+				equality.Semantic.DeepEqual(oldSts.Annotations, newSts.Annotations) &&
+				equality.Semantic.DeepEqual(oldSts.DeletionTimestamp, newSts.DeletionTimestamp) {
+				return
+			}
+			enqueue(newObj, "Update")
+		},
+		DeleteFunc: func(obj interface{}) { enqueue(obj, "Delete") },
+	}
+}
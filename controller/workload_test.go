@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestWorkloadKeyRoundTrip(t *testing.T) {
+	tests := []struct {
+		kind     string
+		key      string
+		queueKey string
+	}{
+		{kind: deploymentKind, key: "default/web", queueKey: "default/web"},
+		{kind: statefulSetKind, key: "default/db", queueKey: "statefulset:default/db"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			queueKey := workloadKey(tt.kind, tt.key)
+			if queueKey != tt.queueKey {
+				t.Fatalf("workloadKey = %q, want %q", queueKey, tt.queueKey)
+			}
+			kind, key := splitWorkloadKey(queueKey)
+			if kind != tt.kind || key != tt.key {
+				t.Errorf("splitWorkloadKey = %q, %q, want %q, %q", kind, key, tt.kind, tt.key)
+			}
+			namespace, name, err := splitQueueKey(queueKey)
+			if err != nil {
+				t.Fatalf("splitQueueKey: %v", err)
+			}
+			if namespace+"/"+name != tt.key {
+				t.Errorf("splitQueueKey = %q, %q, want %q", namespace, name, tt.key)
+			}
+		})
+	}
+}
+
+// newStatefulSet returns an exposed StatefulSet with one container
+// declaring the given ports.
+func newStatefulSet(name string, ports ...v1.ContainerPort) *appsv1.StatefulSet {
+	deploy := newDeployment(name, ports...)
+	return &appsv1.StatefulSet{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: statefulSetKind},
+		ObjectMeta: deploy.ObjectMeta,
+		Spec: appsv1.StatefulSetSpec{
+			Selector: deploy.Spec.Selector,
+			Template: deploy.Spec.Template,
+		},
+	}
+}
+
+func TestSyncStatefulSet(t *testing.T) {
+	sts := newStatefulSet("db", v1.ContainerPort{ContainerPort: 5432})
+	f := newFixture(t, Options{WatchStatefulSets: true}, sts)
+	key := workloadKey(statefulSetKind, "default/db")
+
+	f.mustSync(key)
+	svc := f.service("default", "db-expose")
+	if svc == nil {
+		t.Fatal("service of the statefulset was not created")
+	}
+	ref := metav1.GetControllerOf(svc)
+	if ref == nil || ref.Kind != statefulSetKind || ref.UID != types.UID("uid-db") {
+		t.Errorf("controller reference = %+v, want StatefulSet db", ref)
+	}
+	if len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Port != 5432 {
+		t.Errorf("ports = %+v, want 5432", svc.Spec.Ports)
+	}
+
+	// A Deployment of the same name does not take the Service over.
+	f.mustSync("default/db")
+	if f.service("default", "db-expose") == nil {
+		t.Fatal("sync of a missing deployment removed the statefulset's service")
+	}
+
+	if err := f.client.AppsV1().StatefulSets("default").Delete(context.Background(), "db", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("deleting statefulset: %v", err)
+	}
+	f.mustSync(key)
+	if f.service("default", "db-expose") != nil {
+		t.Error("service outlived its statefulset")
+	}
+}
+
+func TestStatefulSetWorkload(t *testing.T) {
+	sts := newStatefulSet("db")
+	wl := statefulSetWorkload(sts)
+	if wl.kind != statefulSetKind || wl.object != sts || wl.Name != "db" {
+		t.Errorf("workload = %+v, want StatefulSet db", wl)
+	}
+	if wl.available {
+		t.Error("statefulset without available replicas is available")
+	}
+	sts.Status.AvailableReplicas = 1
+	if !statefulSetWorkload(sts).available {
+		t.Error("statefulset with an available replica is not available")
+	}
+}
+
+func TestServiceNameCollision(t *testing.T) {
+	deploy := newDeployment("web", v1.ContainerPort{ContainerPort: 8080})
+	sts := newStatefulSet("web", v1.ContainerPort{ContainerPort: 9090})
+	f := newFixture(t, Options{WatchStatefulSets: true}, deploy, sts)
+	f.mustSync("default/web")
+
+	f.client.ClearActions()
+	f.events()
+	stsKey := workloadKey(statefulSetKind, "default/web")
+	f.mustSync(stsKey)
+	if verbs := f.serviceActions(); len(verbs) != 0 {
+		t.Errorf("service actions of the colliding statefulset = %v, want none", verbs)
+	}
+	if !hasEvent(f.events(), v1.EventTypeWarning, "ServiceNameCollision") {
+		t.Error("no ServiceNameCollision event")
+	}
+
+	// Neither workload takes the Service from the other on later syncs.
+	f.mustSync("default/web")
+	f.mustSync(stsKey)
+	if verbs := f.serviceActions(); len(verbs) != 0 {
+		t.Errorf("service actions of later syncs = %v, want none", verbs)
+	}
+	svc := f.service("default", "web-expose")
+	if owner := workloadControllerRef(svc); owner == nil || owner.Kind != deploymentKind {
+		t.Errorf("service owner = %+v, want the deployment", owner)
+	}
+	if got := portNumbers(svc.Spec.Ports); !slices.Equal(got, []int32{8080}) {
+		t.Errorf("service ports = %v, want the deployment's [8080]", got)
+	}
+}
@@ -1,33 +1,177 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/abdul-saqib/expose-deployments/controller"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 )
 
 func main() {
+	// exitCode is applied after the deferred cleanups below have run.
+	var exitCode int
+	defer func() {
+		if exitCode != 0 {
+			klog.Flush()
+			os.Exit(exitCode)
+		}
+	}()
+
 	klog.InitFlags(nil)
 	klog.Info("Starting expose-controller...")
 
 	var kubeconfig string
 	var masterURL string
+	var otelEndpoint string
+	var metricsAddr string
+	var healthAddr string
+	var pprofAddr string
+	var webhookAddr string
+	var webhookCertFile string
+	var webhookKeyFile string
+	var ambiguousPortPolicy string
+	var immutableChangePolicy string
+	var namespace string
+	var leaderElect bool
+	var once bool
+	var leaderElectNamespace string
+	var copyPrefixes string
+	var excludeNamespaces string
+	var workers int
+	var resyncPeriod time.Duration
+	var selector string
+	var defaultPorts string
+	var logFormat string
+	var defaultServiceType string
+	rateLimiterOpts := controller.DefaultRateLimiterOptions
+	var opts controller.Options
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig")
+	flag.StringVar(&logFormat, "log-format", "text", "Log format: text or json")
 	flag.StringVar(&masterURL, "master", "", "API server address")
+	flag.StringVar(&otelEndpoint, "otel-endpoint", "", "OTLP/HTTP endpoint to export reconcile traces to (tracing is disabled when empty)")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Address to serve Prometheus metrics on, e.g. :8080 (disabled when empty)")
+	flag.StringVar(&healthAddr, "health-addr", ":8081", "Address to serve /healthz and /readyz on (disabled when empty)")
+	flag.StringVar(&pprofAddr, "pprof-addr", "", "Address to serve net/http/pprof profiles on under /debug/pprof/ (disabled when empty)")
+	flag.StringVar(&webhookAddr, "webhook-addr", "", "Address to serve the validating admission webhook for expose annotations on under /validate, over TLS (disabled when empty)")
+	flag.StringVar(&webhookCertFile, "webhook-cert-file", "", "TLS certificate file of the webhook server")
+	flag.StringVar(&webhookKeyFile, "webhook-key-file", "", "TLS private key file of the webhook server")
+	flag.StringVar(&immutableChangePolicy, "on-immutable-change", string(controller.ImmutableChangeUpdate), "What to do when a Service update is rejected as invalid, e.g. for an immutable field: update (retry) or recreate")
+	flag.StringVar(&defaultPorts, "default-ports", "", "Comma-separated ports exposed when no container declares a port, e.g. 80,443 (port 80 when empty)")
+	flag.StringVar(&ambiguousPortPolicy, "ambiguous-port-policy", string(controller.AmbiguousPortSkip), "What to do with multi-container Deployments declaring no ports: skip, default or error")
+	flag.DurationVar(&resyncPeriod, "resync-period", 10*time.Minute, "How often informers re-deliver every object so drift is corrected even after a missed watch event (0 disables)")
+	flag.StringVar(&selector, "selector", "", "Only expose Deployments whose labels match this label selector, e.g. tier=web,env!=dev")
+	flag.StringVar(&excludeNamespaces, "exclude-namespaces", "kube-system,kube-public,kube-node-lease", "Comma-separated namespaces whose Deployments are never exposed")
+	flag.IntVar(&workers, "workers", 2, "Number of Deployments reconciled concurrently")
+	flag.StringVar(&namespace, "namespace", "", "Only watch Deployments in this namespace (all namespaces when empty)")
+	flag.BoolVar(&leaderElect, "leader-elect", false, "Elect a leader through a Lease so only one replica reconciles at a time")
+	flag.StringVar(&defaultServiceType, "default-service-type", "NodePort", "Type of a Deployment's Service unless its service-type annotation overrides it: ClusterIP, NodePort or LoadBalancer")
+	flag.StringVar(&opts.ServiceNamespace, "service-namespace", "", "Also create an ExternalName Service per exposed Deployment in this namespace, resolving to the Deployment's Service")
+	flag.BoolVar(&opts.WaitForAvailable, "wait-for-available", false, "Defer creating a Deployment's Service until the Deployment is Available")
+	flag.BoolVar(&opts.WatchStatefulSets, "watch-statefulsets", false, "Also expose StatefulSets annotated like Deployments")
+	flag.BoolVar(&once, "once", false, "Reconcile every Deployment once after the caches sync and exit, with status 1 if any reconcile failed; with -dry-run, print the planned Service changes and exit with status 2 if any is pending")
+	flag.StringVar(&opts.ControllerName, "controller-name", controller.DefaultControllerName, "Name identifying this controller on the Services it manages and naming its leader election Lease; controllers with different names ignore each other's Services")
+	flag.StringVar(&leaderElectNamespace, "leader-elect-namespace", "default", "Namespace of the leader election Lease")
+	flag.IntVar(&opts.MaxRetries, "max-retries", controller.DefaultMaxRetries, "Stop requeuing a failing Deployment after this many retries (0 retries forever)")
+	flag.DurationVar(&rateLimiterOpts.BaseDelay, "retry-base-delay", rateLimiterOpts.BaseDelay, "First retry delay of a failing Deployment, doubled on every failure")
+	flag.DurationVar(&rateLimiterOpts.MaxDelay, "retry-max-delay", rateLimiterOpts.MaxDelay, "Longest retry delay of a failing Deployment")
+	flag.Float64Var(&rateLimiterOpts.Jitter, "retry-jitter", rateLimiterOpts.Jitter, "Randomly lengthen each retry delay by up to this fraction of it")
+	flag.Float64Var(&rateLimiterOpts.QPS, "retry-qps", rateLimiterOpts.QPS, "Overall rate of retries per second across all Deployments")
+	flag.IntVar(&rateLimiterOpts.Burst, "retry-burst", rateLimiterOpts.Burst, "Retries allowed in a burst above -retry-qps")
+	flag.BoolVar(&opts.Finalizer, "finalizer", false, "Add a cleanup finalizer to exposed Deployments so their Services are removed before the Deployment is deleted")
+	flag.BoolVar(&opts.NamespaceOptIn, "namespace-opt-in", false, "Only expose Deployments in namespaces annotated expose.abdul-saqib.io/enabled=true")
+	flag.IntVar(&opts.RetryMetricThreshold, "retry-metric-threshold", 3, "Export a key in expose_key_retries once it has been requeued more than this many times")
+	flag.BoolVar(&opts.OrphanOnDelete, "orphan-on-delete", false, "Leave the Services of a deleted Deployment in place instead of removing them")
+	flag.DurationVar(&opts.OrphanDeleteDelay, "orphan-delete-delay", 0, "Wait this long before removing the Service of a deleted Deployment; a Deployment recreated in time keeps its Service")
+	flag.BoolVar(&opts.ReadinessGates, "readiness-gates", false, "Watch Pods so Deployments can defer exposure with the readiness-gate annotation")
+	flag.BoolVar(&opts.PreferProbePort, "prefer-probe-port", false, "Expose a container's readiness probe port instead of its declared ports")
+	flag.StringVar(&opts.ServiceSuffix, "service-suffix", controller.DefaultServiceSuffix, "Suffix appended to a Deployment's name to name its Service")
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "Log the changes the controller would make, with a diff, instead of applying them")
+	flag.StringVar(&copyPrefixes, "copy-prefixes", "", "Comma-separated label and annotation key prefixes copied from a Deployment onto its Services")
+	flag.DurationVar(&opts.ReconcileTimeout, "reconcile-timeout", controller.DefaultReconcileTimeout, "Abort a single reconcile, including its API calls, after this long and retry it (0 disables)")
+	flag.DurationVar(&opts.DrainTimeout, "drain-timeout", controller.DefaultDrainTimeout, "On shutdown, keep reconciling queued Deployments for up to this long before aborting")
+	flag.StringVar(&opts.OutputDir, "output-dir", "", "Render Services as YAML files into this directory instead of applying them")
+	flag.DurationVar(&opts.Debounce, "debounce", 0, "Delay a Deployment enqueued within this long of its last reconcile until the window has passed, coalescing bursts of events (0 disables)")
+	flag.DurationVar(&opts.PostCreateRequeue, "post-create-requeue", 2*time.Second, "Re-reconcile a Deployment this long after its Service is created (0 disables)")
+	flag.StringVar(&opts.DNSAnnotationKey, "dns-annotation-key", controller.DefaultDNSAnnotationKey, "Service annotation that receives the hostname from the dns-hostname Deployment annotation")
+	flag.StringVar(&opts.WeightAnnotationKey, "weight-annotation-key", controller.DefaultWeightAnnotationKey, "Service annotation that receives the value of the weight Deployment annotation")
 	flag.Parse()
 
-	var cfg *rest.Config
+	if err := configureLogging(logFormat); err != nil {
+		klog.Fatalf("Invalid flags: %v", err)
+	}
+
+	opts.CopyPrefixes = splitList(copyPrefixes)
+	opts.ExcludeNamespaces = map[string]bool{}
+	for _, ns := range splitList(excludeNamespaces) {
+		opts.ExcludeNamespaces[ns] = true
+	}
+
+	if workers < 1 {
+		klog.Fatalf("Invalid flags: -workers must be at least 1, got %d", workers)
+	}
+	if webhookAddr != "" && (webhookCertFile == "" || webhookKeyFile == "") {
+		klog.Fatalf("Invalid flags: -webhook-addr requires -webhook-cert-file and -webhook-key-file")
+	}
+
 	var err error
+	opts.AmbiguousPortPolicy, err = controller.ParseAmbiguousPortPolicy(ambiguousPortPolicy)
+	if err != nil {
+		klog.Fatalf("Invalid flags: %v", err)
+	}
+	opts.ImmutableChangePolicy, err = controller.ParseImmutableChangePolicy(immutableChangePolicy)
+	if err != nil {
+		klog.Fatalf("Invalid flags: %v", err)
+	}
+	opts.DefaultPorts, err = controller.ParseDefaultPorts(defaultPorts)
+	if err != nil {
+		klog.Fatalf("Invalid flags: %v", err)
+	}
+	opts.DefaultServiceType, err = controller.ParseServiceType(defaultServiceType)
+	if err != nil {
+		klog.Fatalf("Invalid flags: %v", err)
+	}
+	if err := controller.ValidateServiceSuffix(opts.ServiceSuffix); err != nil {
+		klog.Fatalf("Invalid flags: %v", err)
+	}
+	if opts.ServiceNamespace != "" && namespace != "" && opts.ServiceNamespace != namespace {
+		klog.Fatalf("Invalid flags: -service-namespace %s is not watched with -namespace %s", opts.ServiceNamespace, namespace)
+	}
+	if selector != "" {
+		opts.Selector, err = labels.Parse(selector)
+		if err != nil {
+			klog.Fatalf("Invalid flags: invalid selector %q: %v", selector, err)
+		}
+	}
+
+	var cfg *rest.Config
 
 	if kubeconfig != "" {
 		klog.Infof("Using kubeconfig: %s", kubeconfig)
@@ -40,6 +184,19 @@ func main() {
 		klog.Fatalf("Error building config: %v", err)
 	}
 
+	if otelEndpoint != "" {
+		klog.Infof("Exporting traces to %s", otelEndpoint)
+		shutdownTracing, err := controller.InitTracing(context.Background(), otelEndpoint)
+		if err != nil {
+			klog.Fatalf("Error initializing tracing: %v", err)
+		}
+		defer func() {
+			if err := shutdownTracing(context.Background()); err != nil {
+				klog.Errorf("Error shutting down tracing: %v", err)
+			}
+		}()
+	}
+
 	clientset, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
 		klog.Fatalf("Error creating clientset: %v", err)
@@ -47,66 +204,307 @@ func main() {
 
 	klog.Info("Clientset created successfully")
 
-	factory := informers.NewSharedInformerFactory(clientset, 0)
-	deployInformer := factory.Apps().V1().Deployments()
-	serviceInformer := factory.Core().V1().Services()
+	if namespace != "" {
+		klog.Infof("Watching namespace %s", namespace)
+	}
+	factory := newInformerFactory(clientset, namespace, resyncPeriod)
+	queue := workqueue.NewNamedRateLimitingQueue(controller.NewRateLimiter(rateLimiterOpts), "deploy-expose")
+	ctrl, err := controller.NewController(clientset, factory, queue, opts)
+	if err != nil {
+		klog.Fatalf("Error creating controller: %v", err)
+	}
 
-	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "deploy-expose")
-	ctrl := controller.NewController(clientset, deployInformer.Lister(), serviceInformer.Lister(), queue)
+	if opts.OutputDir != "" {
+		klog.Infof("Rendering Services to %s instead of applying them", opts.OutputDir)
+	}
 
-	klog.Info("Adding event handlers for Deployments")
+	var dynamicFactory dynamicinformer.DynamicSharedInformerFactory
+	if controller.HTTPRouteAPIAvailable(clientset.Discovery()) {
+		klog.Info("Gateway API detected, enabling HTTPRoute reconciliation")
+		dynamicClient, err := dynamic.NewForConfig(cfg)
+		if err != nil {
+			klog.Fatalf("Error creating dynamic client: %v", err)
+		}
+		dynamicFactory = dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, resyncPeriod, namespace, nil)
+		ctrl.EnableHTTPRoutes(dynamicClient, dynamicFactory.ForResource(controller.HTTPRouteGVR).Lister())
+	}
 
-	_, err = deployInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			key, err := cache.MetaNamespaceKeyFunc(obj)
-			if err != nil {
-				klog.Errorf("Error creating key: %v", err)
-				return
-			}
-			klog.Infof("Add event for key: %s", key)
-			ctrl.EnqueueKey(key)
-		},
-		UpdateFunc: func(_, newObj interface{}) {
-			key, err := cache.MetaNamespaceKeyFunc(newObj)
-			if err != nil {
-				klog.Errorf("Error creating key: %v", err)
-				return
-			}
-			klog.Infof("Update event for key: %s", key)
-			ctrl.EnqueueKey(key)
-		},
-		DeleteFunc: func(obj interface{}) {
-			key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
-			if err != nil {
-				klog.Errorf("Error creating key: %v", err)
-				return
-			}
-			klog.Infof("Delete event for key: %s", key)
-			ctrl.EnqueueKey(key)
-		},
-	})
-	if err != nil {
-		klog.Fatalf("Error adding event handler: %v", err)
+	var servers []*http.Server
+	if metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(controller.Registry, promhttp.HandlerOpts{}))
+		servers = append(servers, serve("metrics", &http.Server{Addr: metricsAddr, Handler: mux}))
+	}
+	if healthAddr != "" {
+		servers = append(servers, serve("health", newHealthServer(healthAddr, ctrl)))
+	}
+	if pprofAddr != "" {
+		servers = append(servers, serve("pprof", newPprofServer(pprofAddr, ctrl)))
+	}
+	if webhookAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/validate", controller.WebhookHandler())
+		servers = append(servers, serveTLS("webhook", &http.Server{Addr: webhookAddr, Handler: mux}, webhookCertFile, webhookKeyFile))
 	}
 
 	klog.Info("Starting informer factory...")
 	factory.Start(ctrl.StopCh)
+	if dynamicFactory != nil {
+		dynamicFactory.Start(ctrl.StopCh)
+		for gvr, ok := range dynamicFactory.WaitForCacheSync(ctrl.StopCh) {
+			if !ok {
+				klog.Fatalf("Cache for %s did not sync", gvr)
+			}
+		}
+	}
 
 	klog.Info("Waiting for caches to sync...")
-	if !cache.WaitForCacheSync(ctrl.StopCh, deployInformer.Informer().HasSynced) {
+	if !ctrl.WaitForCacheSync() {
 		klog.Fatalf("Cache did not sync")
 	}
 	klog.Info("Caches synced successfully")
 
-	klog.Info("Starting controller workers...")
-	go ctrl.Run(2)
+	if once {
+		exitCode = runOnce(ctrl, workers, opts.DryRun, os.Stdout)
+		close(ctrl.StopCh)
+		return
+	}
+
+	var stopOnce sync.Once
+	stop := func() { stopOnce.Do(func() { close(ctrl.StopCh) }) }
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var running atomic.Bool
+	runDone := make(chan struct{})
+	run := func(ctx context.Context) {
+		running.Store(true)
+		defer close(runDone)
+		klog.Info("Starting controller workers...")
+		ctrl.Run(ctx, workers)
+	}
+
+	if leaderElect {
+		go runLeaderElection(ctx, clientset, leaderElectNamespace, opts.ControllerName, run, stop)
+	} else {
+		go run(ctx)
+	}
 
 	klog.Info("Controller is running. Waiting for shutdown signal...")
 
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt)
-	<-sig
+	select {
+	case <-ctx.Done():
+		klog.Info("Shutdown signal received. Stopping controller...")
+	case <-ctrl.StopCh:
+		klog.Info("Controller stopped")
+	}
+	cancel()
+	stop()
+	if running.Load() {
+		<-runDone
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelShutdown()
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			klog.Errorf("Error shutting down server on %s: %v", srv.Addr, err)
+		}
+	}
+}
+
+// Exit codes of -once.
+const (
+	exitFailed         = 1
+	exitChangesPending = 2
+)
+
+// runOnce reconciles every Deployment once and returns the exit code:
+// exitFailed if any reconcile failed, or with dryRun exitChangesPending if
+// any Service would change. With dryRun, the planned changes are written to
+// out, so -once -dry-run previews a migration and can gate CI.
+func runOnce(ctrl *controller.Controller, workers int, dryRun bool, out io.Writer) int {
+	failed := ctrl.RunOnce(context.Background(), workers)
+	if failed > 0 {
+		klog.Errorf("%d reconciles failed", failed)
+		return exitFailed
+	}
+	if !dryRun {
+		klog.Info("All Deployments reconciled")
+		return 0
+	}
+
+	pending, err := ctrl.WriteDryRunReport(out)
+	if err != nil {
+		klog.Errorf("Error writing dry-run report: %v", err)
+		return exitFailed
+	}
+	if pending {
+		klog.Info("Service changes are pending")
+		return exitChangesPending
+	}
+	klog.Info("No Service changes are pending")
+	return 0
+}
+
+// configureLogging routes klog through a JSON logger when format is json.
+// Verbosity is still governed by klog's -v flag.
+func configureLogging(format string) error {
+	switch format {
+	case "text":
+		return nil
+	case "json":
+		klog.SetLogger(newJSONLogger(os.Stderr))
+		return nil
+	}
+	return fmt.Errorf("invalid log format %q, must be one of text, json", format)
+}
+
+// newJSONLogger returns a logger writing one JSON object per line to w,
+// with ts, level and msg plus the logger's key/value pairs, such as
+// namespace and name during a reconcile.
+func newJSONLogger(w io.Writer) logr.Logger {
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{
+		// klog filters by verbosity before a line reaches the handler.
+		Level: slog.Level(math.MinInt),
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 {
+				return a
+			}
+			switch a.Key {
+			case slog.TimeKey:
+				a.Key = "ts"
+			case slog.LevelKey:
+				level := "info"
+				if a.Value.Any().(slog.Level) >= slog.LevelError {
+					level = "error"
+				}
+				a.Value = slog.StringValue(level)
+			}
+			return a
+		},
+	})
+	return logr.FromSlogHandler(handler)
+}
 
-	klog.Info("Shutdown signal received. Stopping controller...")
-	close(ctrl.StopCh)
+// serve starts srv in the background and returns it for shutdown.
+func serve(name string, srv *http.Server) *http.Server {
+	go func() {
+		klog.Infof("Serving %s on %s", name, srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("%s server stopped: %v", name, err)
+		}
+	}()
+	return srv
+}
+
+// serveTLS starts srv over TLS in the background and returns it for
+// shutdown.
+func serveTLS(name string, srv *http.Server, certFile, keyFile string) *http.Server {
+	go func() {
+		klog.Infof("Serving %s on %s", name, srv.Addr)
+		if err := srv.ListenAndServeTLS(certFile, keyFile); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("%s server stopped: %v", name, err)
+		}
+	}()
+	return srv
+}
+
+// newHealthServer serves /healthz, which succeeds while the process is up,
+// /readyz, which succeeds once the controller's caches have synced, and the
+// controller's Prometheus metrics on /metrics.
+func newHealthServer(addr string, ctrl *controller.Controller) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(controller.Registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if !ctrl.Ready() {
+			http.Error(w, "caches not synced", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	return &http.Server{Addr: addr, Handler: mux}
+}
+
+// newInformerFactory returns the informer factory the controller watches
+// through, limited to namespace unless it is empty.
+func newInformerFactory(clientset kubernetes.Interface, namespace string, resyncPeriod time.Duration) informers.SharedInformerFactory {
+	var opts []informers.SharedInformerOption
+	if namespace != "" {
+		opts = append(opts, informers.WithNamespace(namespace))
+	}
+	return informers.NewSharedInformerFactoryWithOptions(clientset, resyncPeriod, opts...)
+}
+
+// newPprofServer serves the net/http/pprof profiles under /debug/pprof/ and
+// a snapshot of the controller's queue on /debug/queue.
+func newPprofServer(addr string, ctrl *controller.Controller) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/queue", ctrl.QueueHandler())
+	return &http.Server{Addr: addr, Handler: mux}
+}
+
+// runLeaderElection blocks until this replica holds the Lease, then calls
+// run. stop is called when leadership is lost, so a former leader shuts down
+// instead of reconciling alongside the new one.
+func runLeaderElection(ctx context.Context, clientset kubernetes.Interface, namespace, name string, run func(context.Context), stop func()) {
+	id, err := os.Hostname()
+	if err != nil {
+		klog.Fatalf("Error getting hostname for leader election: %v", err)
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Client:     clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: id},
+	}
+
+	klog.Infof("Waiting to acquire Lease %s/%s as %s", namespace, name, id)
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.Info("Acquired leadership")
+				run(ctx)
+			},
+			OnStoppedLeading: func() {
+				klog.Info("Lost leadership, stopping controller")
+				stop()
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					klog.Infof("Current leader is %s", identity)
+				}
+			},
+		},
+	})
+}
+
+// splitList splits a comma-separated flag value, dropping blanks.
+func splitList(value string) []string {
+	var out []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			out = append(out, entry)
+		}
+	}
+	return out
 }
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abdul-saqib/expose-deployments/controller"
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// onceController returns a controller for -once against client, with its
+// caches synced.
+func onceController(t *testing.T, client *fake.Clientset, opts controller.Options) *controller.Controller {
+	t.Helper()
+	factory := informers.NewSharedInformerFactory(client, 0)
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test")
+	ctrl, err := controller.NewController(client, factory, queue, opts)
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	t.Cleanup(func() { close(ctrl.StopCh) })
+	factory.Start(ctrl.StopCh)
+	if !ctrl.WaitForCacheSync() {
+		t.Fatal("caches did not sync")
+	}
+	return ctrl
+}
+
+// exposedDeployment returns an opted-in Deployment in the default namespace
+// with one container port.
+func exposedDeployment(name string) *appsv1.Deployment {
+	labels := map[string]string{"app": name}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: map[string]string{"expose.abdul-saqib.io/enabled": "true"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{Containers: []v1.Container{{
+					Name:  "app",
+					Ports: []v1.ContainerPort{{ContainerPort: 8080}},
+				}}},
+			},
+		},
+	}
+}
+
+func TestRunOnceDryRunExitCode(t *testing.T) {
+	client := fake.NewSimpleClientset(exposedDeployment("web"))
+
+	var out strings.Builder
+	if code := runOnce(onceController(t, client, controller.Options{DryRun: true}), 1, true, &out); code != exitChangesPending {
+		t.Errorf("exit code with a missing service = %d, want %d", code, exitChangesPending)
+	}
+	if want := "default/web: Service web-expose would be created\n"; !strings.HasPrefix(out.String(), want) {
+		t.Errorf("report = %q, want it to start with %q", out.String(), want)
+	}
+
+	if code := runOnce(onceController(t, client, controller.Options{}), 1, false, &out); code != 0 {
+		t.Fatalf("exit code applying the changes = %d, want 0", code)
+	}
+	if _, err := client.CoreV1().Services("default").Get(context.Background(), "web-expose", metav1.GetOptions{}); err != nil {
+		t.Fatalf("service was not created: %v", err)
+	}
+
+	out.Reset()
+	if code := runOnce(onceController(t, client, controller.Options{DryRun: true}), 1, true, &out); code != 0 {
+		t.Errorf("exit code without pending changes = %d, want 0:\n%s", code, out.String())
+	}
+	if want := "default/web: Service web-expose is unchanged\n"; out.String() != want {
+		t.Errorf("report = %q, want %q", out.String(), want)
+	}
+}
+
+func TestRunOnceReportsFailures(t *testing.T) {
+	client := fake.NewSimpleClientset(exposedDeployment("good"), exposedDeployment("bad"))
+	var created []string
+	client.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		name := action.(k8stesting.CreateAction).GetObject().(*v1.Service).Name
+		created = append(created, name)
+		if name == "bad-expose" {
+			return true, nil, fmt.Errorf("quota exceeded")
+		}
+		return false, nil, nil
+	})
+
+	if code := runOnce(onceController(t, client, controller.Options{}), 1, false, io.Discard); code != exitFailed {
+		t.Errorf("exit code with a failing reconcile = %d, want %d", code, exitFailed)
+	}
+	slices.Sort(created)
+	if want := []string{"bad-expose", "good-expose"}; !slices.Equal(created, want) {
+		t.Errorf("services created = %v, want %v", created, want)
+	}
+	if _, err := client.CoreV1().Services("default").Get(context.Background(), "good-expose", metav1.GetOptions{}); err != nil {
+		t.Errorf("service of the good deployment: %v", err)
+	}
+}
+
+func TestLeaderElectionWaitsForLease(t *testing.T) {
+	now := metav1.NewMicroTime(time.Now())
+	client := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: "expose-controller", Namespace: "default"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr("other-replica"),
+			LeaseDurationSeconds: ptr(int32(15)),
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runLeaderElection(ctx, client, "default", "expose-controller", func(context.Context) { close(started) }, func() {})
+	}()
+
+	select {
+	case <-started:
+		t.Fatal("workers started while another replica holds the lease")
+	case <-time.After(200 * time.Millisecond):
+	}
+	cancel()
+	<-done
+}
+
+func TestLeaderElectionRunsAndStops(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	run := func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+	}
+	go runLeaderElection(ctx, client, "default", "expose-controller", run, func() { close(stopped) })
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("workers did not start after acquiring a free lease")
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop was not called after leadership ended")
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestNamespaceLimitsWatch(t *testing.T) {
+	deployment := func(namespace string) *appsv1.Deployment {
+		labels := map[string]string{"app": "web"}
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "web",
+				Namespace:   namespace,
+				Annotations: map[string]string{"expose.abdul-saqib.io/enabled": "true"},
+			},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: v1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}},
+			},
+		}
+	}
+	client := fake.NewSimpleClientset(deployment("team-a"), deployment("team-b"))
+	factory := newInformerFactory(client, "team-a", 0)
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test")
+	defer queue.ShutDown()
+	ctrl, err := controller.NewController(client, factory, queue, controller.Options{})
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	defer close(ctrl.StopCh)
+	factory.Start(ctrl.StopCh)
+	if !ctrl.WaitForCacheSync() {
+		t.Fatal("caches did not sync")
+	}
+
+	key, _ := queue.Get()
+	if key != "team-a/web" {
+		t.Errorf("enqueued %v, want team-a/web", key)
+	}
+	queue.Done(key)
+	// Give a stray event of the other namespace time to arrive.
+	time.Sleep(100 * time.Millisecond)
+	if n := queue.Len(); n != 0 {
+		t.Errorf("queue length = %d, want only the watched namespace enqueued", n)
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(client, 0)
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test")
+	defer queue.ShutDown()
+	ctrl, err := controller.NewController(client, factory, queue, controller.Options{})
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	defer close(ctrl.StopCh)
+	handler := newHealthServer(":0", ctrl).Handler
+
+	status := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+	if got := status("/healthz"); got != http.StatusOK {
+		t.Errorf("/healthz before sync = %d, want 200", got)
+	}
+	if got := status("/readyz"); got != http.StatusServiceUnavailable {
+		t.Errorf("/readyz before sync = %d, want 503", got)
+	}
+
+	factory.Start(ctrl.StopCh)
+	if !ctrl.WaitForCacheSync() {
+		t.Fatal("caches did not sync")
+	}
+	if got := status("/healthz"); got != http.StatusOK {
+		t.Errorf("/healthz after sync = %d, want 200", got)
+	}
+	if got := status("/readyz"); got != http.StatusOK {
+		t.Errorf("/readyz after sync = %d, want 200", got)
+	}
+}
+
+func TestResyncEnqueuesUnchangedDeployments(t *testing.T) {
+	labels := map[string]string{"app": "web"}
+	client := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			Annotations: map[string]string{"expose.abdul-saqib.io/enabled": "true"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: v1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}},
+		},
+	})
+	factory := newInformerFactory(client, "", 100*time.Millisecond)
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test")
+	defer queue.ShutDown()
+	ctrl, err := controller.NewController(client, factory, queue, controller.Options{})
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	defer close(ctrl.StopCh)
+	factory.Start(ctrl.StopCh)
+	if !ctrl.WaitForCacheSync() {
+		t.Fatal("caches did not sync")
+	}
+
+	// The add, then a resync without any change to the Deployment.
+	for i := range 2 {
+		got := make(chan interface{})
+		go func() {
+			key, _ := queue.Get()
+			got <- key
+		}()
+		select {
+		case key := <-got:
+			if key != "default/web" {
+				t.Fatalf("enqueued %v, want default/web", key)
+			}
+			queue.Done(key)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event %d within 5s", i+1)
+		}
+	}
+}
+
+func TestPprofServer(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(client, 0)
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test")
+	defer queue.ShutDown()
+	ctrl, err := controller.NewController(client, factory, queue, controller.Options{})
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+	srv := httptest.NewServer(newPprofServer("", ctrl).Handler)
+	defer srv.Close()
+
+	for _, path := range []string{"/debug/pprof/", "/debug/queue"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s = %d, want 200", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestJSONLogFormat(t *testing.T) {
+	var out bytes.Buffer
+	newJSONLogger(&out).WithValues("namespace", "default", "name", "web").Info("Service created", "service", "web-expose")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("log line %q is not JSON: %v", out.String(), err)
+	}
+	want := map[string]interface{}{"level": "info", "msg": "Service created", "namespace": "default", "name": "web", "service": "web-expose"}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("%s = %v, want %v", k, line[k], v)
+		}
+	}
+	if _, ok := line["ts"]; !ok {
+		t.Errorf("log line %v has no ts", line)
+	}
+}
+
+func TestInvalidLogFormat(t *testing.T) {
+	if err := configureLogging("xml"); err == nil {
+		t.Error("configureLogging accepted an unknown format")
+	}
+}